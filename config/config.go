@@ -0,0 +1,313 @@
+// Package config loads the configuration of the backend from a YAML or
+// TOML file, environment variables and command line flags. Later sources
+// override earlier ones: defaults, then the file, then the environment,
+// then flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// File is the configuration file that was loaded, if any
+	File string
+
+	Database DatabaseConfig
+	RPC      RPCConfig
+	Indexer  IndexerConfig
+	Mempool  MempoolConfig
+	ZMQ      ZMQConfig
+	HTTP     HTTPConfig
+	Electrum ElectrumConfig
+	Webhooks WebhooksConfig
+	Log      LogConfig
+
+	// sources records where each setting came from, by key
+	sources map[string]string
+}
+
+type DatabaseConfig struct {
+	// Connection is a PostgreSQL connection string, or sqlite:// followed
+	// by the path of a SQLite database
+	Connection string
+}
+
+type RPCConfig struct {
+	Host     string
+	User     string
+	Password string
+}
+
+type IndexerConfig struct {
+	// StartHeight is the height before the first block to index, -1 to
+	// start at genesis
+	StartHeight   int64
+	MaxReorgDepth int64
+	FetchWorkers  int
+	FetchQueue    int
+}
+
+type MempoolConfig struct {
+	Enabled           bool
+	Interval          time.Duration
+	UnconfirmedMaxAge time.Duration
+}
+
+type ZMQConfig struct {
+	Endpoint string
+}
+
+type HTTPConfig struct {
+	Listen       string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	BatchMax     int
+	AdminToken   string
+}
+
+type ElectrumConfig struct {
+	Listen string
+}
+
+type WebhooksConfig struct {
+	MaxAttempts int
+	RetryDelay  time.Duration
+	Timeout     time.Duration
+}
+
+type LogConfig struct {
+	Debug      bool
+	Levels     string
+	Format     string
+	File       string
+	MaxSizeMB  int
+	Rotate     time.Duration
+	MaxAgeDays int
+	MaxFiles   int
+	Compress   bool
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Indexer: IndexerConfig{
+			StartHeight:   -1,
+			MaxReorgDepth: 1000,
+			FetchWorkers:  4,
+			FetchQueue:    16,
+		},
+		Mempool: MempoolConfig{
+			Interval:          10 * time.Second,
+			UnconfirmedMaxAge: 60 * time.Minute,
+		},
+		HTTP: HTTPConfig{
+			Listen:       ":8000",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+			BatchMax:     100,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts: 8,
+			RetryDelay:  30 * time.Second,
+			Timeout:     10 * time.Second,
+		},
+		Log: LogConfig{
+			Format:    "text",
+			MaxSizeMB: 100,
+			MaxFiles:  10,
+		},
+		sources: map[string]string{},
+	}
+}
+
+// Load registers the configuration flags on fs, parses args and loads the
+// configuration. The file is given with -config or OCM_BACKEND_CONFIG.
+// Flags the caller registered on fs before are parsed along.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	configFile := fs.String("config", os.Getenv("OCM_BACKEND_CONFIG"), "Configuration file (.yaml, .yml or .toml)")
+	flagged := map[string]string{}
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		fs.Var(&deferredFlag{setting: s, values: flagged}, s.flag, fmt.Sprintf("%s (%s, env %s)", s.usage, s.key, s.env))
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		err = c.loadFile(*configFile, settings)
+		if err != nil {
+			return nil, err
+		}
+		c.File = *configFile
+	}
+
+	for _, s := range settings {
+		str, ok := os.LookupEnv(s.env)
+		if !ok || str == "" {
+			continue
+		}
+		err = s.value.Set(str)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s", s.env, str)
+		}
+		c.sources[s.key] = "env " + s.env
+	}
+
+	// Flags were only recorded while parsing, so they can override the file
+	// and the environment
+	for _, s := range settings {
+		str, ok := flagged[s.flag]
+		if !ok {
+			continue
+		}
+		err = s.value.Set(str)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -%s: %s", s.flag, str)
+		}
+		c.sources[s.key] = "flag -" + s.flag
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// deferredFlag records the value of a flag to apply it after the file and
+// the environment. It checks the value right away so flag errors are
+// reported with the usage.
+type deferredFlag struct {
+	setting setting
+	values  map[string]string
+}
+
+func (f *deferredFlag) String() string {
+	if f.setting.value == nil {
+		return ""
+	}
+	return f.setting.value.String()
+}
+
+func (f *deferredFlag) Set(s string) error {
+	err := f.setting.value.check(s)
+	if err != nil {
+		return err
+	}
+	f.values[f.setting.flag] = s
+	return nil
+}
+
+func (f *deferredFlag) IsBoolFlag() bool {
+	_, ok := f.setting.value.(*boolValue)
+	return ok
+}
+
+// Validate checks the configuration as a whole, and reports all problems
+// at once. The connection to vertcoind isn't required here, as not every
+// command uses it; see RequireRPC.
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Database.Connection != "", "database.connection (PGSQL_CONNECTION) is required")
+	check(c.Indexer.StartHeight >= -1, "indexer.startHeight must be -1 or higher")
+	check(c.Indexer.MaxReorgDepth >= 1, "indexer.maxReorgDepth must be at least 1")
+	check(c.Indexer.FetchWorkers >= 1, "indexer.fetchWorkers must be at least 1")
+	check(c.Indexer.FetchQueue >= 1, "indexer.fetchQueue must be at least 1")
+	check(c.Mempool.Interval >= time.Second, "mempool.interval must be at least 1s")
+	check(c.Mempool.UnconfirmedMaxAge >= time.Minute, "mempool.unconfirmedMaxAge must be at least 1m")
+	check(c.HTTP.Listen != "", "http.listen is required")
+	check(c.HTTP.ReadTimeout > 0, "http.readTimeout must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.writeTimeout must be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idleTimeout must be positive")
+	check(c.HTTP.BatchMax >= 1, "http.batchMax must be at least 1")
+	check(c.Webhooks.MaxAttempts >= 1, "webhooks.maxAttempts must be at least 1")
+	check(c.Webhooks.RetryDelay >= time.Second, "webhooks.retryDelay must be at least 1s")
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+	check(validLevels(c.Log.Levels), "log.levels must be like info,http=debug with levels error, warn, info or debug")
+	check(c.Log.MaxSizeMB >= 1, "log.maxSizeMB must be at least 1")
+	check(c.Log.Rotate == 0 || c.Log.Rotate >= time.Minute, "log.rotate must be 0 or at least 1m")
+	check(c.Log.MaxAgeDays >= 0, "log.maxAgeDays can't be negative")
+	check(c.Log.MaxFiles >= 0, "log.maxFiles can't be negative")
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// RequireRPC checks that the connection to vertcoind is configured, for the
+// commands that use it
+func (c *Config) RequireRPC() error {
+	if c.RPC.Host == "" {
+		return errors.New("Invalid configuration:\n  rpc.host (RPCHOST) is required")
+	}
+	return nil
+}
+
+func validLevels(spec string) bool {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		lvl := parts[len(parts)-1]
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "" {
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(lvl)) {
+		case "error", "warn", "warning", "info", "debug":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes the effective configuration with the source of every
+// setting. Secrets are redacted.
+func (c *Config) Print(w io.Writer) {
+	settings := c.settings()
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].key < settings[j].key })
+	if c.File != "" {
+		fmt.Fprintf(w, "# Configuration file: %s\n", c.File)
+	}
+	for _, s := range settings {
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s = %s  # %s\n", s.key, s.display(), source)
+	}
+}
+
+// Lines returns the effective configuration like Print, one setting per
+// line
+func (c *Config) Lines() []string {
+	var sb strings.Builder
+	c.Print(&sb)
+	return strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile applies the settings in a YAML or TOML file. Sections in the
+// file correspond to the first part of the keys, so http.listen is
+//
+//	http:
+//	  listen: ":8000"
+//
+// in YAML. Keys are case insensitive. Unknown keys are an error, so typos
+// don't go unnoticed.
+func (c *Config) loadFile(path string, settings []setting) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read configuration file: %v", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return fmt.Errorf("Unknown configuration file type %s, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to parse %s: %v", path, err)
+	}
+
+	flat := map[string]interface{}{}
+	flatten("", raw, flat)
+
+	byKey := map[string]setting{}
+	for _, s := range settings {
+		byKey[strings.ToLower(s.key)] = s
+	}
+
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, ok := byKey[strings.ToLower(k)]
+		if !ok {
+			return fmt.Errorf("Unknown setting %s in %s", k, path)
+		}
+		v := flat[k]
+		if v == nil {
+			continue
+		}
+		err = s.value.Set(fmt.Sprint(v))
+		if err != nil {
+			return fmt.Errorf("Invalid %s in %s: %v", k, path, err)
+		}
+		c.sources[s.key] = "file"
+	}
+	return nil
+}
+
+func flatten(prefix string, m map[string]interface{}, out map[string]interface{}) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			flatten(key, sub, out)
+			continue
+		}
+		out[key] = v
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a single configuration value, with its key in the
+// configuration file, its environment variable and its flag
+type setting struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	value  value
+	secret secretKind
+}
+
+type secretKind int
+
+const (
+	notSecret secretKind = iota
+	// secretValue is hidden completely
+	secretValue
+	// secretConnection only has the password hidden
+	secretConnection
+)
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"database.connection", "PGSQL_CONNECTION", "database", "Database connection string, sqlite:// followed by a path for SQLite", &stringValue{&c.Database.Connection}, secretConnection},
+		{"rpc.host", "RPCHOST", "rpc-host", "Host where vertcoind listens for RPC commands", &stringValue{&c.RPC.Host}, notSecret},
+		{"rpc.user", "RPCUSER", "rpc-user", "RPC user", &stringValue{&c.RPC.User}, notSecret},
+		{"rpc.password", "RPCPASS", "rpc-password", "RPC password", &stringValue{&c.RPC.Password}, secretValue},
+		{"indexer.startHeight", "OCM_BACKEND_STARTHEIGHT", "start-height", "Height at which to begin indexing, -1 for genesis", &int64Value{&c.Indexer.StartHeight}, notSecret},
+		{"indexer.maxReorgDepth", "OCM_BACKEND_MAXREORGDEPTH", "max-reorg-depth", "Maximum number of blocks to walk back to find a fork point", &int64Value{&c.Indexer.MaxReorgDepth}, notSecret},
+		{"indexer.fetchWorkers", "OCM_BACKEND_FETCH_WORKERS", "fetch-workers", "Concurrent RPC calls to fetch blocks ahead", &intValue{&c.Indexer.FetchWorkers}, notSecret},
+		{"indexer.fetchQueue", "OCM_BACKEND_FETCH_QUEUE", "fetch-queue", "Maximum number of blocks fetched ahead", &intValue{&c.Indexer.FetchQueue}, notSecret},
+		{"mempool.enabled", "OCM_BACKEND_MEMPOOL", "mempool", "Follow the mempool of vertcoind", &boolValue{&c.Mempool.Enabled}, notSecret},
+		{"mempool.interval", "OCM_BACKEND_MEMPOOL_INTERVAL", "mempool-interval", "Interval between mempool synchronizations, plain numbers are seconds", &durationValue{&c.Mempool.Interval, time.Second}, notSecret},
+		{"mempool.unconfirmedMaxAge", "OCM_BACKEND_UNCONFIRMED_MAXAGE", "unconfirmed-max-age", "Age after which unconfirmed transactions are checked against vertcoind, plain numbers are minutes", &durationValue{&c.Mempool.UnconfirmedMaxAge, time.Minute}, notSecret},
+		{"zmq.endpoint", "OCM_BACKEND_ZMQ", "zmq", "ZMQ endpoint of vertcoind", &stringValue{&c.ZMQ.Endpoint}, notSecret},
+		{"http.listen", "OCM_BACKEND_LISTEN", "listen", "Address to serve the API on", &stringValue{&c.HTTP.Listen}, notSecret},
+		{"http.readTimeout", "OCM_BACKEND_HTTP_READ_TIMEOUT", "http-read-timeout", "Timeout for reading requests, plain numbers are seconds", &durationValue{&c.HTTP.ReadTimeout, time.Second}, notSecret},
+		{"http.writeTimeout", "OCM_BACKEND_HTTP_WRITE_TIMEOUT", "http-write-timeout", "Timeout for writing responses, plain numbers are seconds", &durationValue{&c.HTTP.WriteTimeout, time.Second}, notSecret},
+		{"http.idleTimeout", "OCM_BACKEND_HTTP_IDLE_TIMEOUT", "http-idle-timeout", "Timeout for idle keep-alive connections, plain numbers are seconds", &durationValue{&c.HTTP.IdleTimeout, time.Second}, notSecret},
+		{"http.batchMax", "OCM_BACKEND_BATCH_MAX", "batch-max", "Maximum number of scripts per batch request", &intValue{&c.HTTP.BatchMax}, notSecret},
+		{"http.adminToken", "OCM_BACKEND_ADMIN_TOKEN", "admin-token", "Bearer token for the admin endpoints, which are disabled without one", &stringValue{&c.HTTP.AdminToken}, secretValue},
+		{"electrum.listen", "OCM_BACKEND_ELECTRUM", "electrum", "Address to serve the Electrum protocol on", &stringValue{&c.Electrum.Listen}, notSecret},
+		{"webhooks.maxAttempts", "OCM_BACKEND_WEBHOOK_MAXATTEMPTS", "webhook-max-attempts", "Delivery attempts before a webhook delivery is dead lettered", &intValue{&c.Webhooks.MaxAttempts}, notSecret},
+		{"webhooks.retryDelay", "OCM_BACKEND_WEBHOOK_RETRY_DELAY", "webhook-retry-delay", "Delay before the first retry of a webhook delivery, plain numbers are seconds", &durationValue{&c.Webhooks.RetryDelay, time.Second}, notSecret},
+		{"webhooks.timeout", "OCM_BACKEND_WEBHOOK_TIMEOUT", "webhook-timeout", "Timeout of a webhook delivery, plain numbers are seconds", &durationValue{&c.Webhooks.Timeout, time.Second}, notSecret},
+		{"log.debug", "DEBUG", "debug", "Log at debug level by default", &boolValue{&c.Log.Debug}, notSecret},
+		{"log.levels", "OCM_BACKEND_LOG_LEVELS", "log-levels", "Log levels per subsystem, like info,http=debug", &stringValue{&c.Log.Levels}, notSecret},
+		{"log.format", "OCM_BACKEND_LOG_FORMAT", "log-format", "Log format, text or json", &stringValue{&c.Log.Format}, notSecret},
+		{"log.file", "OCM_BACKEND_LOG_FILE", "log-file", "File to write the log to in addition to stdout", &stringValue{&c.Log.File}, notSecret},
+		{"log.maxSizeMB", "OCM_BACKEND_LOG_MAXSIZE", "log-max-size", "Size in megabytes at which the log file is rotated", &intValue{&c.Log.MaxSizeMB}, notSecret},
+		{"log.rotate", "OCM_BACKEND_LOG_ROTATE", "log-rotate", "Interval at which the log file is rotated regardless of its size", &durationValue{&c.Log.Rotate, 0}, notSecret},
+		{"log.maxAgeDays", "OCM_BACKEND_LOG_MAXAGE", "log-max-age", "Days after which rotated log files are removed, 0 to keep them", &intValue{&c.Log.MaxAgeDays}, notSecret},
+		{"log.maxFiles", "OCM_BACKEND_LOG_MAXFILES", "log-max-files", "Number of rotated log files to keep, 0 for all", &intValue{&c.Log.MaxFiles}, notSecret},
+		{"log.compress", "OCM_BACKEND_LOG_COMPRESS", "log-compress", "Gzip rotated log files", &boolValue{&c.Log.Compress}, notSecret},
+	}
+}
+
+var connPassword = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// display returns the value as printed in the effective configuration
+func (s setting) display() string {
+	v := s.value.String()
+	switch s.secret {
+	case secretValue:
+		if v != "" {
+			return "[redacted]"
+		}
+	case secretConnection:
+		if u, err := url.Parse(v); err == nil && u.Scheme != "" && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "redacted")
+			}
+			return u.String()
+		}
+		return connPassword.ReplaceAllString(v, "${1}[redacted]")
+	}
+	return v
+}
+
+// value parses and holds a setting. check validates a string without
+// storing it.
+type value interface {
+	Set(s string) error
+	String() string
+	check(s string) error
+}
+
+type stringValue struct{ p *string }
+
+func (v *stringValue) Set(s string) error   { *v.p = s; return nil }
+func (v *stringValue) String() string       { return *v.p }
+func (v *stringValue) check(s string) error { return nil }
+
+type intValue struct{ p *int }
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*v.p = i
+	return nil
+}
+func (v *intValue) String() string { return strconv.Itoa(*v.p) }
+func (v *intValue) check(s string) error {
+	_, err := strconv.Atoi(strings.TrimSpace(s))
+	return err
+}
+
+type int64Value struct{ p *int64 }
+
+func (v *int64Value) Set(s string) error {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return err
+	}
+	*v.p = i
+	return nil
+}
+func (v *int64Value) String() string { return strconv.FormatInt(*v.p, 10) }
+func (v *int64Value) check(s string) error {
+	_, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return err
+}
+
+type boolValue struct{ p *bool }
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*v.p = b
+	return nil
+}
+func (v *boolValue) String() string { return strconv.FormatBool(*v.p) }
+func (v *boolValue) check(s string) error {
+	_, err := strconv.ParseBool(strings.TrimSpace(s))
+	return err
+}
+
+// durationValue parses durations like 1m30s. When unit is set, plain
+// numbers are taken in that unit, as the environment variables used to be.
+type durationValue struct {
+	p    *time.Duration
+	unit time.Duration
+}
+
+func (v *durationValue) parse(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if v.unit != 0 {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Duration(i) * v.unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+func (v *durationValue) Set(s string) error {
+	d, err := v.parse(s)
+	if err != nil {
+		return err
+	}
+	*v.p = d
+	return nil
+}
+func (v *durationValue) String() string { return v.p.String() }
+func (v *durationValue) check(s string) error {
+	_, err := v.parse(s)
+	return err
+}
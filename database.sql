@@ -30,7 +30,15 @@ SET default_table_access_method = heap;
 CREATE TABLE public.blocks (
     id bigint NOT NULL,
     height integer,
-    hash bytea
+    hash bytea,
+    version integer,
+    prev_hash bytea,
+    merkle_root bytea,
+    "time" bigint,
+    bits bigint,
+    nonce bigint,
+    tx_count integer,
+    difficulty double precision
 );
 
 
@@ -109,7 +117,8 @@ ALTER SEQUENCE public.outputs_id_seq OWNED BY public.outputs.id;
 
 CREATE TABLE public.scripts (
     id bigint NOT NULL,
-    script bytea
+    script bytea,
+    scripthash bytea
 );
 
 
@@ -262,6 +271,13 @@ CREATE INDEX blocks_idx_hash ON public.blocks USING btree (hash);
 CREATE INDEX blocks_idx_height ON public.blocks USING btree (height DESC NULLS LAST);
 
 
+--
+-- Name: blocks_idx_time; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX blocks_idx_time ON public.blocks USING btree ("time");
+
+
 --
 -- TOC entry 2827 (class 1259 OID 958727)
 -- Name: outputs_idx_created_vout_unique; Type: INDEX; Schema: public; Owner: postgres
@@ -294,6 +310,13 @@ CREATE INDEX outputs_idx_spent ON public.outputs USING btree (spent_in_tx);
 CREATE UNIQUE INDEX scripts_idx_script ON public.scripts USING btree (script);
 
 
+--
+-- Name: scripts_idx_scripthash; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX scripts_idx_scripthash ON public.scripts USING btree (scripthash);
+
+
 --
 -- TOC entry 2824 (class 1259 OID 958920)
 -- Name: transaction_hash; Type: INDEX; Schema: public; Owner: postgres
@@ -338,6 +361,54 @@ ALTER TABLE ONLY public.transactions
     ADD CONSTRAINT fkey_transaction_block FOREIGN KEY (block_id) REFERENCES public.blocks(id);
 
 
+--
+-- Name: webhooks; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE IF NOT EXISTS public.webhooks (
+    id bigserial PRIMARY KEY,
+    script bytea NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    created bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_idx_script ON public.webhooks USING btree (script);
+
+
+--
+-- Name: webhook_deliveries; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE IF NOT EXISTS public.webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES public.webhooks(id) ON DELETE CASCADE,
+    payload bytea NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt bigint NOT NULL,
+    last_error text,
+    created bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_idx_next_attempt ON public.webhook_deliveries USING btree (next_attempt);
+
+
+--
+-- Name: webhook_dead_letters; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE IF NOT EXISTS public.webhook_dead_letters (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL,
+    url text NOT NULL,
+    payload bytea NOT NULL,
+    attempts integer NOT NULL,
+    last_error text,
+    created bigint NOT NULL,
+    failed bigint NOT NULL
+);
+
+
 -- Completed on 2021-02-24 23:05:26 UTC
 
 --
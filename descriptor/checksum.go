@@ -0,0 +1,89 @@
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The checksum is the one defined in BIP-380, a BCH code over the
+// characters of the descriptor grouped in symbols of 5 bits.
+const (
+	inputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+func polymod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ uint64(val)
+	if c0&1 != 0 {
+		c ^= 0xf5dee51989
+	}
+	if c0&2 != 0 {
+		c ^= 0xa9fdca3312
+	}
+	if c0&4 != 0 {
+		c ^= 0x1bab10e32d
+	}
+	if c0&8 != 0 {
+		c ^= 0x3706b1677a
+	}
+	if c0&16 != 0 {
+		c ^= 0x644d626ffd
+	}
+	return c
+}
+
+// Checksum returns the 8 character checksum of a descriptor without one
+func Checksum(desc string) (string, error) {
+	c := uint64(1)
+	cls := 0
+	clsCount := 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(inputCharset, ch)
+		if pos == -1 {
+			return "", fmt.Errorf("Invalid character %q in descriptor", ch)
+		}
+		c = polymod(c, pos&31)
+		cls = cls*3 + (pos >> 5)
+		clsCount++
+		if clsCount == 3 {
+			c = polymod(c, cls)
+			cls = 0
+			clsCount = 0
+		}
+	}
+	if clsCount > 0 {
+		c = polymod(c, cls)
+	}
+	for i := 0; i < 8; i++ {
+		c = polymod(c, 0)
+	}
+	c ^= 1
+
+	result := make([]byte, 8)
+	for i := range result {
+		result[i] = checksumCharset[(c>>(5*(7-uint(i))))&31]
+	}
+	return string(result), nil
+}
+
+// splitChecksum separates the descriptor from its checksum, and verifies
+// the checksum when there is one.
+func splitChecksum(s string) (string, error) {
+	idx := strings.LastIndex(s, "#")
+	if idx == -1 {
+		return s, nil
+	}
+	desc, given := s[:idx], s[idx+1:]
+	if len(given) != 8 {
+		return "", fmt.Errorf("Checksum must be 8 characters, got %d", len(given))
+	}
+	expected, err := Checksum(desc)
+	if err != nil {
+		return "", err
+	}
+	if given != expected {
+		return "", fmt.Errorf("Checksum mismatch, expected %s", expected)
+	}
+	return desc, nil
+}
@@ -0,0 +1,231 @@
+// Package descriptor parses output script descriptors as specified in
+// BIP-380 and onwards, and expands them into the scripts they describe.
+// Supported are pkh, wpkh, sh, wsh, multi and sortedmulti, with fixed
+// public keys or extended public keys with unhardened paths.
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+// maxMultisigKeys is the largest number of keys a multi() can have, so the
+// key count fits in a small integer opcode
+const maxMultisigKeys = 16
+
+type context int
+
+const (
+	contextTop context = iota
+	contextSh
+	contextWsh
+)
+
+// node is a parsed script expression
+type node struct {
+	name      string
+	keys      []*key
+	threshold int
+	sub       *node
+}
+
+// Descriptor is a parsed output descriptor
+type Descriptor struct {
+	root *node
+	str  string
+}
+
+// Parse parses a descriptor. When it has a checksum, the checksum must be
+// valid.
+func Parse(s string) (*Descriptor, error) {
+	desc, err := splitChecksum(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseExpr(desc, contextTop)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{root: root, str: desc}, nil
+}
+
+// String returns the descriptor with its checksum
+func (d *Descriptor) String() string {
+	checksum, _ := Checksum(d.str)
+	return d.str + "#" + checksum
+}
+
+// IsRange reports whether the descriptor has a wildcard, and so describes
+// a different script for every index
+func (d *Descriptor) IsRange() bool {
+	return d.root.isRange()
+}
+
+// Script returns the output script at index. The index is ignored when
+// the descriptor is not ranged.
+func (d *Descriptor) Script(index uint32) ([]byte, error) {
+	return d.root.script(index)
+}
+
+func parseExpr(s string, ctx context) (*node, error) {
+	name, args, err := splitCall(s)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{name: name}
+
+	switch name {
+	case "pkh", "wpkh":
+		if name == "wpkh" && ctx == contextWsh {
+			return nil, errors.New("wpkh() is not allowed inside wsh()")
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes exactly one key", name)
+		}
+		k, err := parseKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if (name == "wpkh" || ctx == contextWsh) && !k.isCompressed() {
+			return nil, errors.New("Uncompressed keys are not allowed in segwit scripts")
+		}
+		n.keys = []*key{k}
+	case "sh", "wsh":
+		if (name == "sh" && ctx != contextTop) || (name == "wsh" && ctx == contextWsh) {
+			return nil, fmt.Errorf("%s() is not allowed here", name)
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes exactly one script", name)
+		}
+		subCtx := contextSh
+		if name == "wsh" {
+			subCtx = contextWsh
+		}
+		n.sub, err = parseExpr(args[0], subCtx)
+		if err != nil {
+			return nil, err
+		}
+	case "multi", "sortedmulti":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s() needs a threshold and at least one key", name)
+		}
+		if len(args)-1 > maxMultisigKeys {
+			return nil, fmt.Errorf("%s() supports at most %d keys", name, maxMultisigKeys)
+		}
+		n.threshold, err = strconv.Atoi(args[0])
+		if err != nil || n.threshold < 1 || n.threshold > len(args)-1 {
+			return nil, fmt.Errorf("Invalid threshold %s", args[0])
+		}
+		for _, a := range args[1:] {
+			k, err := parseKey(a)
+			if err != nil {
+				return nil, err
+			}
+			if ctx == contextWsh && !k.isCompressed() {
+				return nil, errors.New("Uncompressed keys are not allowed in segwit scripts")
+			}
+			n.keys = append(n.keys, k)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported script expression %s()", name)
+	}
+	return n, nil
+}
+
+// splitCall splits name(arg,arg,...) into its name and its arguments,
+// taking nested parentheses and key origins into account
+func splitCall(s string) (string, []string, error) {
+	open := strings.Index(s, "(")
+	if open < 1 || !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("Invalid script expression %s", s)
+	}
+	name := s[:open]
+	inner := s[open+1 : len(s)-1]
+
+	args := make([]string, 0)
+	depth := 0
+	last := 0
+	for i, ch := range inner {
+		switch ch {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+			if depth < 0 {
+				return "", nil, fmt.Errorf("Unbalanced parentheses in %s", s)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, inner[last:i])
+				last = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return "", nil, fmt.Errorf("Unbalanced parentheses in %s", s)
+	}
+	args = append(args, inner[last:])
+	return name, args, nil
+}
+
+func (n *node) isRange() bool {
+	if n.sub != nil {
+		return n.sub.isRange()
+	}
+	for _, k := range n.keys {
+		if k.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *node) script(index uint32) ([]byte, error) {
+	switch n.name {
+	case "sh", "wsh":
+		sub, err := n.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		if n.name == "sh" {
+			return vertcoin.PayToScriptHashScript(btcutil.Hash160(sub)), nil
+		}
+		hash := sha256.Sum256(sub)
+		return vertcoin.PayToWitnessScript(0, hash[:]), nil
+	}
+
+	pubKeys := make([][]byte, 0, len(n.keys))
+	for _, k := range n.keys {
+		pubKey, err := k.derive(index)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	switch n.name {
+	case "pkh":
+		return vertcoin.PayToPubKeyHashScript(btcutil.Hash160(pubKeys[0])), nil
+	case "wpkh":
+		return vertcoin.PayToWitnessScript(0, btcutil.Hash160(pubKeys[0])), nil
+	}
+
+	if n.name == "sortedmulti" {
+		sort.Slice(pubKeys, func(i, j int) bool { return bytes.Compare(pubKeys[i], pubKeys[j]) < 0 })
+	}
+	script := []byte{byte(txscript.OP_1 + n.threshold - 1)}
+	for _, pubKey := range pubKeys {
+		script = append(script, byte(len(pubKey)))
+		script = append(script, pubKey...)
+	}
+	return append(script, byte(txscript.OP_1+len(pubKeys)-1), txscript.OP_CHECKMULTISIG), nil
+}
@@ -0,0 +1,224 @@
+package descriptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+// Keys from the test vectors of BIP-381 to BIP-383
+const (
+	pkhKey          = "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"
+	wpkhKey         = "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+	shKey           = "03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556"
+	wshKey          = "02e493dbf1c10d80f3581e4904930b1404cc6c13900ee0758474fa94abe8c4cd13"
+	multiKey1       = "022f8bde4d1a07209355b4a7250a5c5128e88b84bddc619ab7cba8d569b240efe4"
+	multiKey2       = "025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc"
+	uncompressedKey = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+
+	// The master key of BIP-32 test vector 2, and its child m/0
+	masterXpub = "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB"
+	childXpub  = "xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH"
+	masterXprv = "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"
+)
+
+func TestChecksum(t *testing.T) {
+	// From BIP-380
+	for desc, want := range map[string]string{
+		"raw(deadbeef)": "89f8spxm",
+		"addr(mkmZxiEcEd8ZqjQWVZuC6so5dFMKEFpN2j)": "02wpgw69",
+	} {
+		got, err := Checksum(desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Checksum of %s is %s, want %s", desc, got, want)
+		}
+	}
+
+	if _, err := Checksum("raw(deadbeef)\n"); err == nil {
+		t.Error("Checksum accepted a character outside the input charset")
+	}
+}
+
+func TestParse(t *testing.T) {
+	multi := "5121" + multiKey1 + "21" + multiKey2 + "52ae"
+	for _, c := range []struct {
+		desc   string
+		script string
+	}{
+		{"pkh(" + pkhKey + ")", "76a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac"},
+		{"wpkh(" + wpkhKey + ")", "00147dd65592d0ab2fe0d0257d571abf032cd9db93dc"},
+		{"sh(wpkh(" + shKey + "))", "a914cc6ffbc0bf31af759451068f90ba7a0272b6b33287"},
+		{"wsh(pkh(" + wshKey + "))", "0020fc5acc302aab97f821f9a61e1cc572e7968a603551e95d4ba12b51df6581482f"},
+		{"sh(wsh(pkh(" + wshKey + ")))", "a91455e8d5e8ee4f3604aba23c71c2684fa0a56a3a1287"},
+		{"multi(1," + multiKey1 + "," + multiKey2 + ")", multi},
+		// multi() keeps the order, sortedmulti() sorts the keys
+		{"multi(1," + multiKey2 + "," + multiKey1 + ")", "5121" + multiKey2 + "21" + multiKey1 + "52ae"},
+		{"sortedmulti(1," + multiKey2 + "," + multiKey1 + ")", multi},
+		// Key origins are ignored
+		{"pkh([d34db33f/44'/0'/0']" + pkhKey + ")", "76a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac"},
+		{"pkh(" + uncompressedKey + ")", "76a91491b24bf9f5288532960ac687abb035127b1d28a588ac"},
+	} {
+		d, err := Parse(c.desc)
+		if err != nil {
+			t.Errorf("%s: %v", c.desc, err)
+			continue
+		}
+		if d.IsRange() {
+			t.Errorf("%s is ranged", c.desc)
+		}
+		script, err := d.Script(0)
+		if err != nil {
+			t.Errorf("%s: %v", c.desc, err)
+			continue
+		}
+		if hex.EncodeToString(script) != c.script {
+			t.Errorf("%s: script is %x, want %s", c.desc, script, c.script)
+		}
+
+		// The descriptor with its checksum parses to the same
+		again, err := Parse(d.String())
+		if err != nil || again.String() != d.String() {
+			t.Errorf("%s: round trip gave %v, %v", c.desc, again, err)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	var seventeen []string
+	for i := 0; i < 17; i++ {
+		seventeen = append(seventeen, multiKey1)
+	}
+	for _, desc := range []string{
+		"",
+		"pkh",
+		"pk(" + pkhKey + ")",
+		"pkh(" + pkhKey,
+		"pkh(" + pkhKey + "))",
+		"pkh()",
+		"pkh(02zz)",
+		"pkh(" + pkhKey + "," + pkhKey + ")",
+		"pkh([d34db33f" + pkhKey + ")",
+		// sh() only at the top, wsh() not inside wsh()
+		"sh(sh(pkh(" + pkhKey + ")))",
+		"wsh(sh(pkh(" + pkhKey + ")))",
+		"wsh(wsh(pkh(" + pkhKey + ")))",
+		"wsh(wpkh(" + wpkhKey + "))",
+		"sh()",
+		// Segwit needs compressed keys
+		"wpkh(" + uncompressedKey + ")",
+		"wsh(pkh(" + uncompressedKey + "))",
+		"wsh(multi(1," + uncompressedKey + "))",
+		"multi(1)",
+		"multi(0," + multiKey1 + ")",
+		"multi(2," + multiKey1 + ")",
+		"multi(x," + multiKey1 + ")",
+		"multi(1," + strings.Join(seventeen, ",") + ")",
+		// Extended keys
+		"pkh(" + masterXprv + ")",
+		"pkh(" + masterXpub + "/0')",
+		"pkh(" + masterXpub + "/0h)",
+		"pkh(" + masterXpub + "/*/0)",
+		"pkh(" + masterXpub + "/2147483648)",
+		"pkh(" + masterXpub + "/x)",
+		"pkh(xpubnotakey)",
+	} {
+		if _, err := Parse(desc); err == nil {
+			t.Errorf("%s was accepted", desc)
+		}
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	desc := "wpkh(" + wpkhKey + ")"
+	checksum, err := Checksum(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(desc + "#" + checksum); err != nil {
+		t.Errorf("Valid checksum rejected: %v", err)
+	}
+
+	wrong := []byte(checksum)
+	wrong[0] = checksumCharset[(strings.IndexByte(checksumCharset, wrong[0])+1)%32]
+	for _, s := range []string{
+		desc + "#" + string(wrong),
+		desc + "#" + checksum[:7],
+		desc + "#" + checksum + "q",
+		desc + "#",
+		// The checksum covers the whole descriptor
+		"wpkh(" + pkhKey + ")#" + checksum,
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%s was accepted", s)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	child, err := hdkeychain.NewKeyFromString(childXpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	childKey, err := child.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	childScript := vertcoin.PayToPubKeyHashScript(btcutil.Hash160(childKey.SerializeCompressed()))
+
+	ranged, err := Parse("pkh(" + masterXpub + "/*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ranged.IsRange() {
+		t.Error("Descriptor with a wildcard is not ranged")
+	}
+	first, err := ranged.Script(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, childScript) {
+		t.Errorf("Index 0 is %x, want %x", first, childScript)
+	}
+	second, err := ranged.Script(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("Index 1 has the same script as index 0")
+	}
+
+	// A fixed path derives the same key, whatever the index
+	fixed, err := Parse("pkh(" + masterXpub + "/0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed.IsRange() {
+		t.Error("Descriptor without a wildcard is ranged")
+	}
+	for _, index := range []uint32{0, 5} {
+		script, err := fixed.Script(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(script, childScript) {
+			t.Errorf("Index %d is %x, want %x", index, script, childScript)
+		}
+	}
+
+	// A wildcard below a nested multisig makes the whole descriptor ranged
+	nested, err := Parse("sh(wsh(sortedmulti(1," + multiKey1 + "," + masterXpub + "/0/*)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nested.IsRange() {
+		t.Error("Nested descriptor with a wildcard is not ranged")
+	}
+}
@@ -0,0 +1,99 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// key is a key expression: either a fixed public key, or an extended
+// public key with a derivation path that can end in a wildcard.
+type key struct {
+	pubKey   []byte
+	ext      *hdkeychain.ExtendedKey
+	path     []uint32
+	wildcard bool
+}
+
+func parseKey(s string) (*key, error) {
+	// Key origin information is only informational
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return nil, errors.New("Key origin is missing its closing bracket")
+		}
+		s = s[end+1:]
+	}
+	if s == "" {
+		return nil, errors.New("Empty key")
+	}
+
+	if b, err := hex.DecodeString(s); err == nil {
+		_, err = btcec.ParsePubKey(b, btcec.S256())
+		if err != nil {
+			return nil, fmt.Errorf("Invalid public key %s: %v", s, err)
+		}
+		return &key{pubKey: b}, nil
+	}
+
+	parts := strings.Split(s, "/")
+	ext, err := hdkeychain.NewKeyFromString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key %s: %v", parts[0], err)
+	}
+	if ext.IsPrivate() {
+		return nil, errors.New("Private keys are not accepted")
+	}
+	k := &key{ext: ext}
+	for i, p := range parts[1:] {
+		if p == "*" && i == len(parts)-2 {
+			k.wildcard = true
+			continue
+		}
+		if strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") {
+			return nil, fmt.Errorf("Hardened derivation step %s can't be derived from a public key", p)
+		}
+		step, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || step >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("Invalid derivation step %s", p)
+		}
+		k.path = append(k.path, uint32(step))
+	}
+	return k, nil
+}
+
+func (k *key) isCompressed() bool {
+	return len(k.pubKey) != 65
+}
+
+// derive returns the serialized public key, at index when the key ends in
+// a wildcard
+func (k *key) derive(index uint32) ([]byte, error) {
+	if k.ext == nil {
+		return k.pubKey, nil
+	}
+	ext := k.ext
+	var err error
+	for _, step := range k.path {
+		ext, err = ext.Derive(step)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if k.wildcard {
+		ext, err = ext.Derive(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pubKey, err := ext.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.SerializeCompressed(), nil
+}
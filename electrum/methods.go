@@ -0,0 +1,388 @@
+package electrum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// maxHistory is the largest history returned for a script. Bigger ones
+// are refused, like ElectrumX does.
+const maxHistory = 10000
+
+type method func(s *Server, sess *session, params []json.RawMessage) (interface{}, error)
+
+var methods = map[string]method{
+	"server.version":                    serverVersion,
+	"server.ping":                       serverPing,
+	"blockchain.scripthash.get_balance": scriptHashGetBalance,
+	"blockchain.scripthash.get_history": scriptHashGetHistory,
+	"blockchain.scripthash.listunspent": scriptHashListUnspent,
+	"blockchain.scripthash.subscribe":   scriptHashSubscribe,
+	"blockchain.scripthash.unsubscribe": scriptHashUnsubscribe,
+	"blockchain.headers.subscribe":      headersSubscribe,
+	"blockchain.transaction.broadcast":  transactionBroadcast,
+	"blockchain.transaction.get":        transactionGet,
+	"blockchain.estimatefee":            estimateFee,
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &rpcError{Code: errCodeBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+func invalidParams(format string, args ...interface{}) error {
+	return &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// param decodes the parameter at i into v. Missing optional parameters
+// leave v untouched.
+func param(params []json.RawMessage, i int, v interface{}, required bool) error {
+	if i >= len(params) {
+		if required {
+			return invalidParams("Missing parameter %d", i)
+		}
+		return nil
+	}
+	err := json.Unmarshal(params[i], v)
+	if err != nil {
+		return invalidParams("Invalid parameter %d", i)
+	}
+	return nil
+}
+
+// scriptHashParam reads the script hash in the first parameter. Electrum
+// script hashes are the sha256 of the script in reversed byte order.
+func scriptHashParam(params []json.RawMessage) (string, []byte, error) {
+	var sh string
+	err := param(params, 0, &sh, true)
+	if err != nil {
+		return "", nil, err
+	}
+	b, err := hex.DecodeString(sh)
+	if err != nil || len(b) != sha256.Size {
+		return "", nil, badRequest("Invalid script hash %s", sh)
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return sh, b, nil
+}
+
+func serverVersion(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return []string{"ocm-backend", ProtocolVersion}, nil
+}
+
+func serverPing(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func scriptHashGetBalance(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	_, hash, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	var balance store.Balance
+	script, err := s.store.ScriptByScriptHash(hash)
+	if err == nil {
+		balance, err = s.store.Balance(script)
+	}
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	// Electrum has no notion of maturing outputs, they are confirmed
+	return map[string]int64{
+		"confirmed":   balance.Confirmed + balance.Maturing,
+		"unconfirmed": balance.Unconfirmed,
+	}, nil
+}
+
+type historyItem struct {
+	TxHash string `json:"tx_hash"`
+	Height int64  `json:"height"`
+}
+
+// history returns the history of a script oldest first, with unconfirmed
+// transactions last at height 0
+func (s *Server) history(hash []byte) ([]historyItem, error) {
+	result := make([]historyItem, 0)
+	script, err := s.store.ScriptByScriptHash(hash)
+	if err == store.ErrNotFound {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := ""
+	for {
+		entries, next, err := s.store.History(script, cursor, 500)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			item := historyItem{TxHash: e.TxHash.String()}
+			if e.Confirmed {
+				item.Height = e.Height
+			}
+			result = append(result, item)
+		}
+		if len(result) > maxHistory {
+			return nil, badRequest("History too large")
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+// scriptHashStatus is the status of a script as defined by the protocol:
+// the hash of its history, or nil when it has none
+func (s *Server) scriptHashStatus(sh string) (*string, error) {
+	b, err := hex.DecodeString(sh)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	history, err := s.history(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	for _, h := range history {
+		fmt.Fprintf(&buf, "%s:%d:", h.TxHash, h.Height)
+	}
+	hash := sha256.Sum256(buf.Bytes())
+	status := hex.EncodeToString(hash[:])
+	return &status, nil
+}
+
+func scriptHashGetHistory(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	_, hash, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	return s.history(hash)
+}
+
+type unspentItem struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  int64  `json:"tx_pos"`
+	Height int64  `json:"height"`
+	Value  int64  `json:"value"`
+}
+
+// scriptHashListUnspent returns the spendable outputs, the same as /utxos,
+// followed by the unconfirmed ones at height 0
+func scriptHashListUnspent(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	_, hash, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]unspentItem, 0)
+	script, err := s.store.ScriptByScriptHash(hash)
+	if err == store.ErrNotFound {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := s.store.Utxos(script)
+	if err != nil {
+		return nil, err
+	}
+	// The unconfirmed outputs are part of the unconfirmed balance, so
+	// wallets need them to spend it
+	unconfirmed, err := s.store.UnconfirmedUtxos(script)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range append(utxos, unconfirmed...) {
+		result = append(result, unspentItem{
+			TxHash: u.TxHash.String(),
+			TxPos:  u.Vout,
+			Height: u.Height,
+			Value:  u.Value,
+		})
+	}
+	return result, nil
+}
+
+func scriptHashSubscribe(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	sh, _, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.scriptHashStatus(sh)
+	if err != nil {
+		return nil, err
+	}
+	sess.subsMtx.Lock()
+	sess.subs[sh] = status
+	sess.subsMtx.Unlock()
+	return status, nil
+}
+
+func scriptHashUnsubscribe(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	sh, _, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	sess.subsMtx.Lock()
+	_, ok := sess.subs[sh]
+	delete(sess.subs, sh)
+	sess.subsMtx.Unlock()
+	return ok, nil
+}
+
+type headerItem struct {
+	Height int64  `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+// tipHeader returns the serialized header of the last indexed block
+func (s *Server) tipHeader() (*headerItem, error) {
+	blk, err := s.store.BlockByHeight(s.proc.TipHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	header := blk.Header()
+	if header == nil {
+		// Indexed before headers were stored
+		header, err = s.rpc.GetBlockHeader(&blk.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	err = header.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return &headerItem{Height: blk.Height, Hex: hex.EncodeToString(buf.Bytes())}, nil
+}
+
+func headersSubscribe(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	header, err := s.tipHeader()
+	if err != nil {
+		return nil, err
+	}
+	sess.subsMtx.Lock()
+	sess.headers = true
+	sess.subsMtx.Unlock()
+	return header, nil
+}
+
+func transactionBroadcast(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var rawTx string
+	err := param(params, 0, &rawTx, true)
+	if err != nil {
+		return nil, err
+	}
+	txBytes, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, badRequest("Invalid transaction hex")
+	}
+	tx := wire.NewMsgTx(2)
+	err = tx.Deserialize(bytes.NewReader(txBytes))
+	if err != nil {
+		return nil, badRequest("Invalid transaction: %v", err)
+	}
+
+	hexParam, _ := json.Marshal(rawTx)
+	responseBytes, err := s.rpc.RawRequest("sendrawtransaction", []json.RawMessage{hexParam, json.RawMessage("0")})
+	if err != nil {
+		electrumLog.Warn("Transaction rejected by vertcoind", "err", err)
+		return nil, badRequest("Transaction rejected: %v", err)
+	}
+	var txid string
+	err = json.Unmarshal(responseBytes, &txid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like POST /tx, make the spend show up in balances right away
+	err = s.store.AddUnconfirmedTransaction(tx)
+	if err != nil {
+		electrumLog.Error("Error marking outputs as spent", "txid", txid, "err", err)
+		return txid, nil
+	}
+	s.proc.Events.Publish(events.Event{
+		Type:   events.TypeTx,
+		Height: s.proc.TipHeight,
+		Data:   map[string]string{"txid": txid},
+	})
+	return txid, nil
+}
+
+func transactionGet(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var txid string
+	err := param(params, 0, &txid, true)
+	if err != nil {
+		return nil, err
+	}
+	verbose := false
+	err = param(params, 1, &verbose, false)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, badRequest("Invalid transaction hash %s", txid)
+	}
+
+	txidParam, _ := json.Marshal(hash.String())
+	verboseParam, _ := json.Marshal(verbose)
+	result, err := s.rpc.RawRequest("getrawtransaction", []json.RawMessage{txidParam, verboseParam})
+	if err != nil {
+		return nil, &rpcError{Code: errCodeDaemonError, Message: err.Error()}
+	}
+	return json.RawMessage(result), nil
+}
+
+// estimateFee returns the fee rate in coins per kilobyte, or -1 when
+// vertcoind has no estimate
+func estimateFee(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var blocks int64
+	err := param(params, 0, &blocks, true)
+	if err != nil {
+		return nil, err
+	}
+	if blocks < 1 {
+		return nil, badRequest("Invalid number of blocks")
+	}
+
+	blocksParam, _ := json.Marshal(blocks)
+	result, err := s.rpc.RawRequest("estimatesmartfee", []json.RawMessage{blocksParam})
+	if err != nil {
+		electrumLog.Debug("Unable to estimate fee", "blocks", blocks, "err", err)
+		return -1, nil
+	}
+	var estimate struct {
+		FeeRate *float64 `json:"feerate"`
+	}
+	err = json.Unmarshal(result, &estimate)
+	if err != nil || estimate.FeeRate == nil {
+		return -1, nil
+	}
+	return *estimate.FeeRate, nil
+}
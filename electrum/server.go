@@ -0,0 +1,314 @@
+// Package electrum serves the Electrum protocol (JSON-RPC over TCP) on top
+// of the index, so Electrum based wallets can use the backend directly.
+package electrum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/processor"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// ProtocolVersion is the version of the Electrum protocol that is served
+const ProtocolVersion = "1.4"
+
+// maxLineLength is the longest request we accept, which leaves room for
+// broadcasting large transactions
+const maxLineLength = 4 * 1024 * 1024
+
+// eventBuffer is the number of bus events buffered while notifications
+// are sent. Events that arrive meanwhile are coalesced anyway.
+const eventBuffer = 100
+
+type Server struct {
+	rpc         *rpcclient.Client
+	store       store.Store
+	proc        *processor.Processor
+	events      *events.Subscription
+	addr        string
+	sessions    map[*session]bool
+	sessionsMtx sync.Mutex
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type notification struct {
+	JsonRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+var electrumLog = logging.Named("electrum")
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+const (
+	errCodeBadRequest     = 1
+	errCodeDaemonError    = 2
+	errCodeParseError     = -32700
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeInternalError  = -32603
+)
+
+// session is a connected client with its subscriptions
+type session struct {
+	conn     net.Conn
+	writeMtx sync.Mutex
+	subsMtx  sync.Mutex
+	subs     map[string]*string
+	headers  bool
+}
+
+func NewServer(rpc *rpcclient.Client, s store.Store, p *processor.Processor, addr string) *Server {
+	return &Server{
+		rpc:      rpc,
+		store:    s,
+		proc:     p,
+		events:   p.Events.Subscribe(eventBuffer),
+		addr:     addr,
+		sessions: map[*session]bool{},
+	}
+}
+
+func (s *Server) Run() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	electrumLog.Info("Listening", "addr", s.addr)
+
+	go s.indexScriptHashes()
+	go s.notifyLoop()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// indexScriptHashes stores the hashes of scripts that were indexed before
+// the hashes were stored, so they can be looked up by scripthash
+func (s *Server) indexScriptHashes() {
+	total := int64(0)
+	for {
+		n, err := s.store.IndexScriptHashes(10000)
+		if err != nil {
+			electrumLog.Warn("Unable to index script hashes", "err", err)
+			time.Sleep(time.Minute)
+			continue
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+		electrumLog.Info("Indexed script hashes", "count", total)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	sess := &session{conn: conn, subs: map[string]*string{}}
+	s.sessionsMtx.Lock()
+	s.sessions[sess] = true
+	s.sessionsMtx.Unlock()
+	electrumLog.Debug("Client connected", "remoteAddr", conn.RemoteAddr().String())
+
+	defer func() {
+		s.sessionsMtx.Lock()
+		delete(s.sessions, sess)
+		s.sessionsMtx.Unlock()
+		conn.Close()
+		electrumLog.Debug("Client disconnected", "remoteAddr", conn.RemoteAddr().String())
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		// Requests can be sent in batches, which are answered in one go
+		if line[0] == '[' {
+			var reqs []request
+			err := json.Unmarshal(line, &reqs)
+			if err != nil {
+				sess.send(response{JsonRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: errCodeParseError, Message: "Invalid JSON"}})
+				continue
+			}
+			resps := make([]response, 0, len(reqs))
+			for _, req := range reqs {
+				resps = append(resps, s.handle(sess, req))
+			}
+			sess.send(resps)
+			continue
+		}
+
+		var req request
+		err := json.Unmarshal(line, &req)
+		if err != nil {
+			sess.send(response{JsonRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: errCodeParseError, Message: "Invalid JSON"}})
+			continue
+		}
+		sess.send(s.handle(sess, req))
+	}
+	if err := scanner.Err(); err != nil {
+		electrumLog.Debug("Unable to read from client", "remoteAddr", conn.RemoteAddr().String(), "err", err)
+	}
+}
+
+func (s *Server) handle(sess *session, req request) response {
+	resp := response{JsonRPC: "2.0", ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	method, ok := methods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{Code: errCodeMethodNotFound, Message: "Unknown method " + req.Method}
+		return resp
+	}
+	result, err := method(s, sess, req.Params)
+	if err != nil {
+		if rerr, ok := err.(*rpcError); ok {
+			resp.Error = rerr
+		} else {
+			electrumLog.Error("Error handling request", "method", req.Method, "err", err)
+			resp.Error = &rpcError{Code: errCodeInternalError, Message: "Internal error"}
+		}
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+func (sess *session) send(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		electrumLog.Error("Unable to encode message", "err", err)
+		return
+	}
+	sess.writeMtx.Lock()
+	defer sess.writeMtx.Unlock()
+	sess.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err = sess.conn.Write(append(b, '\n'))
+	if err != nil {
+		// The read loop will notice the connection is gone
+		sess.conn.Close()
+	}
+}
+
+// notifyLoop sends the new tip to clients subscribed to headers, and the
+// new status of subscribed scripts whose history changed. Statuses are only
+// recomputed when the bus announces a block, a reorg or a change of the
+// unconfirmed transactions.
+func (s *Server) notifyLoop() {
+	s.events.Coalesce(func(batch []events.Event, dropped uint64) {
+		// Lost events may have moved the tip
+		newTip := dropped > 0
+		for _, e := range batch {
+			newTip = newTip || e.ChangesTip()
+		}
+		s.notify(newTip)
+	})
+}
+
+func (s *Server) notify(newTip bool) {
+	s.sessionsMtx.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.sessionsMtx.Unlock()
+
+	if newTip {
+		header, err := s.tipHeader()
+		if err != nil {
+			electrumLog.Warn("Unable to get tip header", "err", err)
+		} else {
+			for _, sess := range sessions {
+				if sess.subscribedToHeaders() {
+					sess.send(notification{JsonRPC: "2.0", Method: "blockchain.headers.subscribe", Params: []interface{}{header}})
+				}
+			}
+		}
+	}
+
+	// Several clients can watch the same script, only compute its status
+	// once
+	statuses := map[string]*string{}
+	for _, sess := range sessions {
+		sess.subsMtx.Lock()
+		scriptHashes := make([]string, 0, len(sess.subs))
+		for sh := range sess.subs {
+			scriptHashes = append(scriptHashes, sh)
+		}
+		sess.subsMtx.Unlock()
+
+		for _, sh := range scriptHashes {
+			status, ok := statuses[sh]
+			if !ok {
+				var err error
+				status, err = s.scriptHashStatus(sh)
+				if err != nil {
+					electrumLog.Warn("Unable to get status of script hash", "scriptHash", sh, "err", err)
+					continue
+				}
+				statuses[sh] = status
+			}
+
+			sess.subsMtx.Lock()
+			old, subscribed := sess.subs[sh]
+			changed := subscribed && !equalStatus(old, status)
+			if changed {
+				sess.subs[sh] = status
+			}
+			sess.subsMtx.Unlock()
+			if changed {
+				sess.send(notification{JsonRPC: "2.0", Method: "blockchain.scripthash.subscribe", Params: []interface{}{sh, status}})
+			}
+		}
+	}
+}
+
+func (sess *session) subscribedToHeaders() bool {
+	sess.subsMtx.Lock()
+	defer sess.subsMtx.Unlock()
+	return sess.headers
+}
+
+func equalStatus(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,198 @@
+package electrum
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/nodetest"
+	"github.com/gertjaap/ocm-backend/processor"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/store/storetest"
+)
+
+var testScript = []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+
+// client is a connection to the server, as an Electrum wallet makes it
+type client struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan []byte
+}
+
+func newTestServer(t *testing.T) (*Server, *nodetest.Node, store.Store, *client) {
+	n := nodetest.NewNode()
+	t.Cleanup(n.Close)
+	s := storetest.NewSQLite(t)
+	p, err := processor.NewProcessor(n.Client(), s, config.Default().Indexer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CatchUp()
+
+	srv := NewServer(n.Client(), s, p, "")
+	go srv.notifyLoop()
+	serverConn, clientConn := net.Pipe()
+	go srv.serve(serverConn)
+	t.Cleanup(func() { clientConn.Close() })
+
+	c := &client{t: t, conn: clientConn, lines: make(chan []byte, 100)}
+	go func() {
+		scanner := bufio.NewScanner(clientConn)
+		for scanner.Scan() {
+			c.lines <- append([]byte{}, scanner.Bytes()...)
+		}
+	}()
+	return srv, n, s, c
+}
+
+func (c *client) call(method string, params ...interface{}) json.RawMessage {
+	c.t.Helper()
+	b, _ := json.Marshal(map[string]interface{}{"id": 1, "method": method, "params": params})
+	_, err := c.conn.Write(append(b, '\n'))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var resp struct {
+		Result json.RawMessage
+		Error  *rpcError
+	}
+	json.Unmarshal(c.next(), &resp)
+	if resp.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, resp.Error.Message)
+	}
+	return resp.Result
+}
+
+func (c *client) next() []byte {
+	c.t.Helper()
+	select {
+	case line := <-c.lines:
+		return line
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("Nothing received from the server")
+		return nil
+	}
+}
+
+func (c *client) assertSilent() {
+	c.t.Helper()
+	select {
+	case line := <-c.lines:
+		c.t.Fatalf("Unexpected message %s", line)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func scriptHash(script []byte) string {
+	h := sha256.Sum256(script)
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+	return hex.EncodeToString(h[:])
+}
+
+func TestScriptHashNotifiedOnEvents(t *testing.T) {
+	srv, n, s, c := newTestServer(t)
+	sh := scriptHash(testScript)
+	if status := c.call("blockchain.scripthash.subscribe", sh); string(status) != "null" {
+		t.Fatalf("Status of an unused script is %s, want null", status)
+	}
+
+	genesis := n.Block(0).Transactions[0].TxHash()
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&genesis, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(25e8, testScript))
+	err := s.AddUnconfirmedTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Statuses are only recomputed when the bus announces a change
+	c.assertSilent()
+
+	srv.proc.Events.Publish(events.Event{Type: events.TypeMempool})
+	var note notification
+	json.Unmarshal(c.next(), &note)
+	if note.Method != "blockchain.scripthash.subscribe" || len(note.Params) != 2 || note.Params[0] != sh || note.Params[1] == nil {
+		t.Fatalf("Notification is %+v, want a status for %s", note, sh)
+	}
+	mempoolStatus := note.Params[1]
+
+	// Confirming the transaction changes the status once the block is indexed
+	n.Mine(tx)
+	srv.proc.CatchUp()
+	json.Unmarshal(c.next(), &note)
+	if note.Method != "blockchain.scripthash.subscribe" || note.Params[1] == mempoolStatus {
+		t.Fatalf("Notification is %+v, want a new status for %s", note, sh)
+	}
+
+	// Events that don't change the history send nothing
+	srv.proc.Events.Publish(events.Event{Type: events.TypeMempool})
+	c.assertSilent()
+}
+
+func TestHeadersNotifiedOnBlock(t *testing.T) {
+	srv, n, _, c := newTestServer(t)
+	var tip headerItem
+	json.Unmarshal(c.call("blockchain.headers.subscribe"), &tip)
+	if tip.Height != 0 {
+		t.Fatalf("Tip is at %d, want 0", tip.Height)
+	}
+
+	n.Mine()
+	srv.proc.CatchUp()
+	var note struct {
+		Method string
+		Params []headerItem
+	}
+	json.Unmarshal(c.next(), &note)
+	if note.Method != "blockchain.headers.subscribe" || len(note.Params) != 1 || note.Params[0].Height != 1 {
+		t.Fatalf("Notification is %+v, want the header at height 1", note)
+	}
+}
+
+func TestListUnspentIncludesUnconfirmed(t *testing.T) {
+	srv, n, s, c := newTestServer(t)
+	sh := scriptHash(testScript)
+
+	genesis := n.Block(0).Transactions[0].TxHash()
+	confirmed := wire.NewMsgTx(2)
+	confirmed.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&genesis, 0), nil, nil))
+	confirmed.AddTxOut(wire.NewTxOut(20e8, testScript))
+	confirmed.AddTxOut(wire.NewTxOut(30e8, []byte{0x51}))
+	n.Mine(confirmed)
+	srv.proc.CatchUp()
+
+	parent := confirmed.TxHash()
+	pending := wire.NewMsgTx(2)
+	pending.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parent, 1), nil, nil))
+	pending.AddTxOut(wire.NewTxOut(5e8, testScript))
+	err := s.AddUnconfirmedTransaction(pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unspent []unspentItem
+	json.Unmarshal(c.call("blockchain.scripthash.listunspent", sh), &unspent)
+	want := []unspentItem{
+		{TxHash: confirmed.TxHash().String(), TxPos: 0, Height: 1, Value: 20e8},
+		{TxHash: pending.TxHash().String(), TxPos: 0, Height: 0, Value: 5e8},
+	}
+	if len(unspent) != 2 || unspent[0] != want[0] || unspent[1] != want[1] {
+		t.Errorf("Unspent outputs are %+v, want %+v", unspent, want)
+	}
+
+	// The balance counts the same outputs
+	var balance map[string]int64
+	json.Unmarshal(c.call("blockchain.scripthash.get_balance", sh), &balance)
+	if balance["confirmed"] != 20e8 || balance["unconfirmed"] != 5e8 {
+		t.Errorf("Balance is %v", balance)
+	}
+}
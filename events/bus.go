@@ -0,0 +1,120 @@
+// Package events is the internal event bus between the indexer and the
+// servers that push updates to clients.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Type string
+
+const (
+	// TypeBlock is published when a block was indexed
+	TypeBlock Type = "block"
+	// TypeReorg is published when blocks were reverted because vertcoind
+	// switched to another chain
+	TypeReorg Type = "reorg"
+	// TypeTx is published when a transaction was accepted through the API
+	TypeTx Type = "tx"
+	// TypeMempool is published when transactions from the mempool of
+	// vertcoind were added to or removed from the unconfirmed ones
+	TypeMempool Type = "mempool"
+)
+
+// Event is something that happened to the index. Height is the tip height
+// after the event, Data is the JSON serializable payload.
+type Event struct {
+	Type   Type
+	Height int64
+	Data   interface{}
+	Time   time.Time
+}
+
+// ChangesTip reports whether e changed the tip, as opposed to only the
+// unconfirmed transactions
+func (e Event) ChangesTip() bool {
+	return e.Type == TypeBlock || e.Type == TypeReorg
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a
+// subscriber that doesn't keep up misses events, and can find out how many
+// through Dropped.
+type Bus struct {
+	mtx  sync.Mutex
+	subs map[*Subscription]bool
+}
+
+type Subscription struct {
+	C       chan Event
+	bus     *Bus
+	dropped uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]bool{}}
+}
+
+// Subscribe returns a subscription that buffers up to buffer events
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	s := &Subscription{C: make(chan Event, buffer), bus: b}
+	b.mtx.Lock()
+	b.subs[s] = true
+	b.mtx.Unlock()
+	return s
+}
+
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for s := range b.subs {
+		select {
+		case s.C <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of events that were dropped because the
+// buffer was full since the last call
+func (s *Subscription) Dropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
+// Coalesce calls fn with the events received on C until it is closed.
+// Events that arrive while fn runs are passed together in the next call,
+// for subscribers that recompute their state from scratch anyway. dropped
+// is the number of events that were lost since the previous call.
+func (s *Subscription) Coalesce(fn func(batch []Event, dropped uint64)) {
+	for e := range s.C {
+		batch := []Event{e}
+	drain:
+		for {
+			select {
+			case next, ok := <-s.C:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		fn(batch, s.Dropped())
+	}
+}
+
+// Close unsubscribes and closes C
+func (s *Subscription) Close() {
+	s.bus.mtx.Lock()
+	defer s.bus.mtx.Unlock()
+	if s.bus.subs[s] {
+		delete(s.bus.subs, s)
+		close(s.C)
+	}
+}
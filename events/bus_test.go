@@ -0,0 +1,49 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestPublishDropsWhenFull(t *testing.T) {
+	b := NewBus()
+	sub := b.Subscribe(2)
+	for i := int64(0); i < 5; i++ {
+		b.Publish(Event{Type: TypeBlock, Height: i})
+	}
+	if n := sub.Dropped(); n != 3 {
+		t.Errorf("Dropped %d events, want 3", n)
+	}
+	if n := sub.Dropped(); n != 0 {
+		t.Errorf("Dropped count not reset, got %d", n)
+	}
+	if e := <-sub.C; e.Height != 0 || e.Time.IsZero() {
+		t.Errorf("First event is %+v", e)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	b := NewBus()
+	sub := b.Subscribe(3)
+	b.Publish(Event{Type: TypeTx})
+	b.Publish(Event{Type: TypeBlock, Height: 1})
+	b.Publish(Event{Type: TypeMempool, Height: 1})
+	b.Publish(Event{Type: TypeBlock, Height: 2})
+	sub.Close()
+
+	calls := 0
+	sub.Coalesce(func(batch []Event, dropped uint64) {
+		calls++
+		if len(batch) != 3 || dropped != 1 {
+			t.Errorf("Got %d events and %d dropped, want 3 and 1", len(batch), dropped)
+		}
+		if batch[0].ChangesTip() || !batch[1].ChangesTip() || batch[2].ChangesTip() {
+			t.Errorf("Unexpected batch %+v", batch)
+		}
+	})
+	if calls != 1 {
+		t.Errorf("Called %d times, want once", calls)
+	}
+
+	// Nothing is published to a closed subscription
+	b.Publish(Event{Type: TypeBlock})
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gertjaap/ocm-backend/logging"
+)
+
+// requireAdmin only lets requests through that carry the admin token from
+// OCM_BACKEND_ADMIN_TOKEN as a bearer token. Without a token configured,
+// the admin endpoints are disabled.
+func (h *HttpServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken == "" {
+			http.Error(w, "Admin API is disabled, set OCM_BACKEND_ADMIN_TOKEN to enable it", 403)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(h.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type SubsystemLevelEntry struct {
+	Level     string `json:"level"`
+	Inherited bool   `json:"inherited"`
+}
+
+type LogLevelsReply struct {
+	Default    string                         `json:"default"`
+	Subsystems map[string]SubsystemLevelEntry `json:"subsystems"`
+}
+
+func (h *HttpServer) logLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, currentLogLevels())
+}
+
+// setLogLevelsHandler changes log levels without a restart. The body maps
+// "default" or a subsystem to a level, like {"default":"info","http":"debug"}.
+// Setting a subsystem to "inherit" makes it follow the default level again.
+// Nothing is changed when any of the levels is invalid.
+func (h *HttpServer) setLogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	var req map[string]string
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", 400)
+		return
+	}
+
+	levels := map[string]logging.LogLevel{}
+	for name, lvlStr := range req {
+		if strings.EqualFold(lvlStr, "inherit") {
+			if name == "default" {
+				http.Error(w, "The default level can't inherit", 400)
+				return
+			}
+			continue
+		}
+		lvl, err := logging.ParseLevel(lvlStr)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		levels[name] = lvl
+	}
+
+	for name, lvlStr := range req {
+		lvl, ok := levels[name]
+		switch {
+		case name == "default":
+			logging.SetLogLevel(int(lvl))
+		case !ok:
+			logging.ResetNamedLevel(name)
+		default:
+			logging.SetNamedLevel(name, lvl)
+		}
+		requestLog(r).Warn("Changed log level", "name", name, "level", lvlStr)
+	}
+	writeJson(w, currentLogLevels())
+}
+
+func currentLogLevels() LogLevelsReply {
+	reply := LogLevelsReply{
+		Default:    logging.GetLogLevel().String(),
+		Subsystems: map[string]SubsystemLevelEntry{},
+	}
+	for name, lvl := range logging.NamedLevels() {
+		reply.Subsystems[name] = SubsystemLevelEntry{Level: lvl.Level.String(), Inherited: lvl.Inherited}
+	}
+	return reply
+}
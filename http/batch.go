@@ -0,0 +1,151 @@
+package http
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+type batchRequest struct {
+	Scripts   []string `json:"scripts"`
+	Addresses []string `json:"addresses"`
+}
+
+type batchItem struct {
+	Script  string `json:"script"`
+	Address string `json:"address,omitempty"`
+}
+
+type BalanceEntry struct {
+	batchItem
+	Confirmed   int64 `json:"confirmed"`
+	Maturing    int64 `json:"maturing"`
+	Unconfirmed int64 `json:"unconfirmed"`
+}
+
+type UtxosEntry struct {
+	batchItem
+	Utxos []Utxo `json:"utxos"`
+}
+
+// parseBatchRequest reads the scripts and addresses from the request body
+// and resolves them to scripts. Scripts that are requested more than once
+// are only looked up once, so the totals don't count them twice. It writes
+// the error response when the request is invalid.
+func (h *HttpServer) parseBatchRequest(w http.ResponseWriter, r *http.Request) ([]batchItem, [][]byte, bool) {
+	var req batchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", 400)
+		return nil, nil, false
+	}
+	if len(req.Scripts)+len(req.Addresses) == 0 {
+		http.Error(w, "No scripts or addresses given", 400)
+		return nil, nil, false
+	}
+	if len(req.Scripts)+len(req.Addresses) > h.maxBatch {
+		http.Error(w, fmt.Sprintf("Too many scripts, at most %d are allowed per request", h.maxBatch), 400)
+		return nil, nil, false
+	}
+
+	items := make([]batchItem, 0, len(req.Scripts)+len(req.Addresses))
+	scripts := make([][]byte, 0, cap(items))
+	seen := map[string]bool{}
+	add := func(item batchItem, script []byte) {
+		item.Script = hex.EncodeToString(script)
+		if seen[item.Script] {
+			return
+		}
+		seen[item.Script] = true
+		items = append(items, item)
+		scripts = append(scripts, script)
+	}
+	for _, s := range req.Scripts {
+		script, err := hex.DecodeString(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid script %s", s), 400)
+			return nil, nil, false
+		}
+		add(batchItem{}, script)
+	}
+	for _, a := range req.Addresses {
+		script, err := vertcoin.AddressToScript(a)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid address %s: %v", a, err), 400)
+			return nil, nil, false
+		}
+		add(batchItem{Address: a}, script)
+	}
+	return items, scripts, true
+}
+
+func (h *HttpServer) balancesHandler(w http.ResponseWriter, r *http.Request) {
+	items, scripts, ok := h.parseBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	balances, err := h.store.Balances(scripts)
+	if err != nil {
+		requestLog(r).Error("Error querying balances", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	result := make([]BalanceEntry, 0, len(items))
+	total := map[string]int64{"confirmed": 0, "maturing": 0, "unconfirmed": 0}
+	for i, b := range balances {
+		result = append(result, BalanceEntry{
+			batchItem:   items[i],
+			Confirmed:   b.Confirmed,
+			Maturing:    b.Maturing,
+			Unconfirmed: b.Unconfirmed,
+		})
+		total["confirmed"] += b.Confirmed
+		total["maturing"] += b.Maturing
+		total["unconfirmed"] += b.Unconfirmed
+	}
+
+	writeJson(w, map[string]interface{}{
+		"balances": result,
+		"total":    total,
+	})
+}
+
+func (h *HttpServer) batchUtxosHandler(w http.ResponseWriter, r *http.Request) {
+	items, scripts, ok := h.parseBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	utxos, err := h.store.UtxosForScripts(scripts)
+	if err != nil {
+		requestLog(r).Error("Error querying utxos", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	result := make([]UtxosEntry, 0, len(items))
+	total := map[string]int64{"count": 0, "satoshis": 0}
+	for i, us := range utxos {
+		entry := UtxosEntry{batchItem: items[i], Utxos: make([]Utxo, 0, len(us))}
+		for _, u := range us {
+			entry.Utxos = append(entry.Utxos, Utxo{
+				Vout:   u.Vout,
+				Amount: u.Value,
+				TxID:   u.TxHash.String(),
+			})
+			total["count"]++
+			total["satoshis"] += u.Value
+		}
+		result = append(result, entry)
+	}
+
+	writeJson(w, map[string]interface{}{
+		"utxos": result,
+		"total": total,
+	})
+}
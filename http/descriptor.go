@@ -0,0 +1,126 @@
+package http
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gertjaap/ocm-backend/descriptor"
+)
+
+// maxDescriptorRange is the largest number of indexes a ranged descriptor
+// can be expanded over in one request
+const maxDescriptorRange = 1000
+
+type DescriptorScript struct {
+	Index       uint32 `json:"index"`
+	Script      string `json:"script"`
+	Confirmed   int64  `json:"confirmed"`
+	Maturing    int64  `json:"maturing"`
+	Unconfirmed int64  `json:"unconfirmed"`
+}
+
+type DescriptorUtxo struct {
+	Utxo
+	Index  uint32 `json:"index"`
+	Script string `json:"script"`
+}
+
+// descriptorHandler expands the descriptor in the query string over the
+// range from-to (inclusive) and returns the balance and utxos of the
+// scripts. Descriptors without a wildcard describe a single script.
+func (h *HttpServer) descriptorHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	desc, err := descriptor.Parse(q.Get("descriptor"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid descriptor: %v", err), 400)
+		return
+	}
+
+	from, to := uint64(0), uint64(0)
+	if desc.IsRange() {
+		from, err = parseUintParam(q.Get("from"), 0)
+		if err != nil {
+			http.Error(w, "Invalid from", 400)
+			return
+		}
+		to, err = parseUintParam(q.Get("to"), from+19)
+		if err != nil {
+			http.Error(w, "Invalid to", 400)
+			return
+		}
+		if to < from || to-from >= maxDescriptorRange {
+			http.Error(w, fmt.Sprintf("Invalid range, must cover 1 to %d indexes", maxDescriptorRange), 400)
+			return
+		}
+	}
+
+	scripts := make([][]byte, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		script, err := desc.Script(uint32(i))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to derive index %d: %v", i, err), 400)
+			return
+		}
+		scripts = append(scripts, script)
+	}
+
+	balances, err := h.store.Balances(scripts)
+	if err != nil {
+		requestLog(r).Error("Error querying balances", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	scriptUtxos, err := h.store.UtxosForScripts(scripts)
+	if err != nil {
+		requestLog(r).Error("Error querying utxos", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	total := map[string]int64{"confirmed": 0, "maturing": 0, "unconfirmed": 0}
+	result := make([]DescriptorScript, 0, len(scripts))
+	utxos := make([]DescriptorUtxo, 0)
+	for i, script := range scripts {
+		index := uint32(from) + uint32(i)
+		b := balances[i]
+		result = append(result, DescriptorScript{
+			Index:       index,
+			Script:      hex.EncodeToString(script),
+			Confirmed:   b.Confirmed,
+			Maturing:    b.Maturing,
+			Unconfirmed: b.Unconfirmed,
+		})
+		total["confirmed"] += b.Confirmed
+		total["maturing"] += b.Maturing
+		total["unconfirmed"] += b.Unconfirmed
+
+		for _, u := range scriptUtxos[i] {
+			utxos = append(utxos, DescriptorUtxo{
+				Utxo: Utxo{
+					Vout:   u.Vout,
+					Amount: u.Value,
+					TxID:   u.TxHash.String(),
+				},
+				Index:  index,
+				Script: hex.EncodeToString(script),
+			})
+		}
+	}
+
+	writeJson(w, map[string]interface{}{
+		"descriptor": desc.String(),
+		"balance":    total,
+		"scripts":    result,
+		"utxos":      utxos,
+	})
+}
+
+func parseUintParam(v string, def uint64) (uint64, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.ParseUint(v, 10, 31)
+}
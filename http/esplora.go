@@ -0,0 +1,395 @@
+package http
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+	"github.com/gorilla/mux"
+)
+
+// esploraFeeTargets are the confirmation targets /fee-estimates reports
+var esploraFeeTargets = []int{1, 2, 3, 4, 5, 6, 10, 20, 25, 144, 504, 1008}
+
+type EsploraStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight *int64 `json:"block_height,omitempty"`
+	BlockHash   string `json:"block_hash,omitempty"`
+	BlockTime   *int64 `json:"block_time,omitempty"`
+}
+
+type EsploraUtxo struct {
+	TxID   string        `json:"txid"`
+	Vout   int64         `json:"vout"`
+	Status EsploraStatus `json:"status"`
+	Value  int64         `json:"value"`
+}
+
+type EsploraBlock struct {
+	ID                string  `json:"id"`
+	Height            int64   `json:"height"`
+	Version           int32   `json:"version"`
+	Timestamp         *int64  `json:"timestamp"`
+	TxCount           int64   `json:"tx_count"`
+	MerkleRoot        string  `json:"merkle_root"`
+	PreviousBlockHash string  `json:"previousblockhash"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              uint32  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+}
+
+// registerEsploraRoutes adds a subset of the Esplora REST API, so tooling
+// written for it can use the backend. The routes live under /api like on
+// the Esplora instances, which also keeps them apart from our own routes.
+func (h *HttpServer) registerEsploraRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/blocks/tip/height", h.esploraTipHeightHandler).Methods("GET")
+	api.HandleFunc("/blocks/tip/hash", h.esploraTipHashHandler).Methods("GET")
+	api.HandleFunc("/block-height/{height}", h.esploraBlockHeightHandler).Methods("GET")
+	api.HandleFunc("/block/{hash}", h.esploraBlockHandler).Methods("GET")
+	api.HandleFunc("/block/{hash}/header", h.esploraBlockHeaderHandler).Methods("GET")
+	api.HandleFunc("/block/{hash}/status", h.esploraBlockStatusHandler).Methods("GET")
+	api.HandleFunc("/address/{address}/utxo", h.esploraUtxoHandler).Methods("GET")
+	api.HandleFunc("/scripthash/{scripthash}/utxo", h.esploraUtxoHandler).Methods("GET")
+	api.HandleFunc("/tx/{txid}/status", h.esploraTxStatusHandler).Methods("GET")
+	api.HandleFunc("/tx/{txid}/hex", h.esploraTxHexHandler).Methods("GET")
+	api.HandleFunc("/tx/{txid}/raw", h.esploraTxHexHandler).Methods("GET")
+	api.HandleFunc("/tx", h.esploraBroadcastHandler).Methods("POST")
+	api.HandleFunc("/fee-estimates", h.esploraFeeEstimatesHandler).Methods("GET")
+}
+
+func writeText(w http.ResponseWriter, s string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	w.Write([]byte(s))
+}
+
+func (h *HttpServer) esploraTipHeightHandler(w http.ResponseWriter, r *http.Request) {
+	height, err := h.store.TipHeight()
+	if err != nil {
+		requestLog(r).Error("Error querying tip height", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeText(w, strconv.FormatInt(height, 10))
+}
+
+func (h *HttpServer) esploraTipHashHandler(w http.ResponseWriter, r *http.Request) {
+	height, err := h.store.TipHeight()
+	if err != nil {
+		requestLog(r).Error("Error querying tip height", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	hash, err := h.store.BlockHash(height)
+	if err != nil {
+		requestLog(r).Error("Error querying tip hash", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeText(w, hash.String())
+}
+
+func (h *HttpServer) esploraBlockHeightHandler(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseInt(mux.Vars(r)["height"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid block height", 400)
+		return
+	}
+	hash, err := h.store.BlockHash(height)
+	if err != nil {
+		if err == store.ErrNotFound {
+			http.Error(w, "Block not found", 404)
+			return
+		}
+		requestLog(r).Error("Error querying block hash", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeText(w, hash.String())
+}
+
+// esploraBlock looks up the block in the hash route variable. It writes the
+// error response when there is none.
+func (h *HttpServer) esploraBlock(w http.ResponseWriter, r *http.Request) (*store.Block, bool) {
+	hash, err := chainhash.NewHashFromStr(mux.Vars(r)["hash"])
+	if err != nil {
+		http.Error(w, "Invalid block hash", 400)
+		return nil, false
+	}
+	blk, err := h.store.BlockByHash(hash)
+	if err != nil {
+		if err == store.ErrNotFound {
+			http.Error(w, "Block not found", 404)
+			return nil, false
+		}
+		requestLog(r).Error("Error querying block", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return nil, false
+	}
+	return blk, true
+}
+
+func (h *HttpServer) esploraBlockHandler(w http.ResponseWriter, r *http.Request) {
+	blk, ok := h.esploraBlock(w, r)
+	if !ok {
+		return
+	}
+
+	result := EsploraBlock{
+		ID:      blk.Hash.String(),
+		Height:  blk.Height,
+		Version: blk.Version,
+		TxCount: blk.TxCount,
+		Nonce:   blk.Nonce,
+		Bits:    blk.Bits,
+	}
+	if blk.PrevHash != nil {
+		result.PreviousBlockHash = blk.PrevHash.String()
+	}
+	if blk.MerkleRoot != nil {
+		result.MerkleRoot = blk.MerkleRoot.String()
+	}
+	if blk.Time != nil {
+		blockTime := blk.Time.Unix()
+		result.Timestamp = &blockTime
+	}
+	if blk.Bits != 0 {
+		result.Difficulty = h.proc.BitsToDiff(blk.Bits)
+	}
+
+	writeJson(w, result)
+}
+
+func (h *HttpServer) esploraBlockHeaderHandler(w http.ResponseWriter, r *http.Request) {
+	blk, ok := h.esploraBlock(w, r)
+	if !ok {
+		return
+	}
+
+	header := blk.Header()
+	if header == nil {
+		var err error
+		header, err = h.rpc.GetBlockHeader(&blk.Hash)
+		if err != nil {
+			requestLog(r).Error("Error fetching block header", "err", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	err := header.Serialize(&buf)
+	if err != nil {
+		requestLog(r).Error("Error serializing block header", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	writeText(w, hex.EncodeToString(buf.Bytes()))
+}
+
+func (h *HttpServer) esploraBlockStatusHandler(w http.ResponseWriter, r *http.Request) {
+	hash, err := chainhash.NewHashFromStr(mux.Vars(r)["hash"])
+	if err != nil {
+		http.Error(w, "Invalid block hash", 400)
+		return
+	}
+
+	// Blocks that were reorged out are removed, so only blocks in the best
+	// chain are known
+	result := map[string]interface{}{"in_best_chain": false}
+	blk, err := h.store.BlockByHash(hash)
+	if err != nil && err != store.ErrNotFound {
+		requestLog(r).Error("Error querying block", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if blk != nil {
+		result["in_best_chain"] = true
+		result["height"] = blk.Height
+		next, err := h.store.BlockHash(blk.Height + 1)
+		if err == nil {
+			result["next_best"] = next.String()
+		}
+	}
+
+	writeJson(w, result)
+}
+
+func (h *HttpServer) esploraUtxoHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var script []byte
+	var err error
+	if addr, ok := vars["address"]; ok {
+		script, err = vertcoin.AddressToScript(addr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid address: %v", err), 400)
+			return
+		}
+	} else {
+		// Esplora script hashes are the plain sha256 of the script, not
+		// reversed like Electrum's
+		hash, err := hex.DecodeString(vars["scripthash"])
+		if err != nil || len(hash) != 32 {
+			http.Error(w, "Invalid scripthash", 400)
+			return
+		}
+		script, err = h.store.ScriptByScriptHash(hash)
+		if err == store.ErrNotFound {
+			writeJson(w, []EsploraUtxo{})
+			return
+		}
+		if err != nil {
+			requestLog(r).Error("Error querying script", "err", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+
+	utxos, err := h.store.Utxos(script)
+	if err != nil {
+		requestLog(r).Error("Error querying utxos", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	blocks := map[int64]*store.Block{}
+	result := make([]EsploraUtxo, 0, len(utxos))
+	for _, u := range utxos {
+		blk, ok := blocks[u.Height]
+		if !ok {
+			blk, err = h.store.BlockByHeight(u.Height)
+			if err != nil {
+				requestLog(r).Error("Error querying block", "err", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			blocks[u.Height] = blk
+		}
+		result = append(result, EsploraUtxo{
+			TxID:   u.TxHash.String(),
+			Vout:   u.Vout,
+			Status: esploraStatus(blk),
+			Value:  u.Value,
+		})
+	}
+
+	writeJson(w, result)
+}
+
+func esploraStatus(blk *store.Block) EsploraStatus {
+	if blk == nil {
+		return EsploraStatus{}
+	}
+	status := EsploraStatus{
+		Confirmed:   true,
+		BlockHeight: &blk.Height,
+		BlockHash:   blk.Hash.String(),
+	}
+	if blk.Time != nil {
+		blockTime := blk.Time.Unix()
+		status.BlockTime = &blockTime
+	}
+	return status
+}
+
+func (h *HttpServer) esploraTxStatusHandler(w http.ResponseWriter, r *http.Request) {
+	hash, err := chainhash.NewHashFromStr(mux.Vars(r)["txid"])
+	if err != nil {
+		http.Error(w, "Invalid transaction hash", 400)
+		return
+	}
+	blk, err := h.store.TransactionBlock(hash)
+	if err != nil {
+		if err == store.ErrNotFound {
+			http.Error(w, "Transaction not found", 404)
+			return
+		}
+		requestLog(r).Error("Error querying transaction", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	writeJson(w, esploraStatus(blk))
+}
+
+// esploraTxHexHandler returns a transaction from vertcoind, as hex or as
+// raw bytes depending on the route
+func (h *HttpServer) esploraTxHexHandler(w http.ResponseWriter, r *http.Request) {
+	hash, err := chainhash.NewHashFromStr(mux.Vars(r)["txid"])
+	if err != nil {
+		http.Error(w, "Invalid transaction hash", 400)
+		return
+	}
+	tx, err := h.rpc.GetRawTransaction(hash)
+	if err != nil {
+		requestLog(r).Debug("Unable to get transaction", "txid", hash, "err", err)
+		http.Error(w, "Transaction not found", 404)
+		return
+	}
+	var buf bytes.Buffer
+	err = tx.MsgTx().Serialize(&buf)
+	if err != nil {
+		requestLog(r).Error("Error serializing transaction", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/raw") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(200)
+		w.Write(buf.Bytes())
+	} else {
+		writeText(w, hex.EncodeToString(buf.Bytes()))
+	}
+}
+
+// esploraBroadcastHandler takes the transaction as hex in the body and
+// returns its id as text
+func (h *HttpServer) esploraBroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 4*1024*1024))
+	if err != nil {
+		http.Error(w, "Request invalid", 400)
+		return
+	}
+	txHash, err := h.broadcastTransaction(r.Context(), strings.TrimSpace(string(body)))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	writeText(w, txHash.String())
+}
+
+// esploraFeeEstimatesHandler returns the fee rates in sat/vB for a number
+// of confirmation targets. Targets vertcoind has no estimate for are left
+// out.
+func (h *HttpServer) esploraFeeEstimatesHandler(w http.ResponseWriter, r *http.Request) {
+	result := map[string]float64{}
+	for _, target := range esploraFeeTargets {
+		responseBytes, err := h.rpc.RawRequest("estimatesmartfee", []json.RawMessage{json.RawMessage(strconv.Itoa(target))})
+		if err != nil {
+			requestLog(r).Debug("Unable to estimate fee", "target", target, "err", err)
+			continue
+		}
+		var estimate struct {
+			FeeRate *float64 `json:"feerate"`
+		}
+		err = json.Unmarshal(responseBytes, &estimate)
+		if err != nil || estimate.FeeRate == nil {
+			continue
+		}
+		// feerate is in coins per kB
+		result[strconv.Itoa(target)] = *estimate.FeeRate * 1e8 / 1000
+	}
+
+	writeJson(w, result)
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/nodetest"
+	"github.com/gertjaap/ocm-backend/processor"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// addTestBlocks adds blocks 0 to count-1 to the chain of s, each with a
+// coinbase of its own and a time of 1600000000 plus the height
+func addTestBlocks(t *testing.T, s *fakeStore, count int64) []*wire.MsgBlock {
+	blocks := make([]*wire.MsgBlock, 0, count)
+	for height := int64(0); height < count; height++ {
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0xFFFFFFFF}, []byte{byte(height), 0x00}, nil))
+		coinbase.AddTxOut(wire.NewTxOut(25e8, []byte{0x51}))
+		blk := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, 0x1e0ffff0, uint32(height)))
+		blk.Header.Timestamp = time.Unix(1600000000+height, 0)
+		blk.AddTransaction(coinbase)
+		err := s.InsertBlock(height, blk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blk)
+	}
+	return blocks
+}
+
+func TestEsploraTipHeight(t *testing.T) {
+	s := newFakeStore()
+	addTestBlocks(t, s, 3)
+	h := newTestServer(t, s, "")
+
+	rec := do(h, "GET", "/api/blocks/tip/height", nil, nil)
+	if rec.Code != 200 || rec.Body.String() != "2" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Tip height is %d %q (%s), want 2 as text", rec.Code, rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestEsploraUtxo(t *testing.T) {
+	s := newFakeStore()
+	blocks := addTestBlocks(t, s, 3)
+	txHash := blocks[2].Transactions[0].TxHash()
+	s.utxos[testScript] = []store.Utxo{{TxHash: txHash, Vout: 1, Value: 5e8, Height: 2}}
+	h := newTestServer(t, s, "")
+
+	var utxos []EsploraUtxo
+	decode(t, do(h, "GET", "/api/address/"+testAddress+"/utxo", nil, nil), &utxos)
+	if len(utxos) != 1 {
+		t.Fatalf("Got %d utxos, want 1", len(utxos))
+	}
+	u := utxos[0]
+	if u.TxID != txHash.String() || u.Vout != 1 || u.Value != 5e8 {
+		t.Errorf("Utxo is %+v", u)
+	}
+	st := u.Status
+	if !st.Confirmed || st.BlockHeight == nil || *st.BlockHeight != 2 || st.BlockHash != blocks[2].BlockHash().String() || st.BlockTime == nil || *st.BlockTime != 1600000002 {
+		t.Errorf("Status is %+v, want confirmed in block 2 at 1600000002", st)
+	}
+
+	rec := do(h, "GET", "/api/address/notanaddress/utxo", nil, nil)
+	if rec.Code != 400 {
+		t.Errorf("Invalid address returned %d, want 400", rec.Code)
+	}
+}
+
+func TestEsploraTxStatus(t *testing.T) {
+	s := newFakeStore()
+	blocks := addTestBlocks(t, s, 2)
+	h := newTestServer(t, s, "")
+
+	var st EsploraStatus
+	decode(t, do(h, "GET", "/api/tx/"+blocks[1].Transactions[0].TxHash().String()+"/status", nil, nil), &st)
+	if !st.Confirmed || st.BlockHeight == nil || *st.BlockHeight != 1 || st.BlockHash != blocks[1].BlockHash().String() {
+		t.Errorf("Status is %+v, want confirmed in block 1", st)
+	}
+
+	for _, c := range []struct {
+		txid string
+		code int
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000001", 404},
+		{"nothex", 400},
+	} {
+		rec := do(h, "GET", "/api/tx/"+c.txid+"/status", nil, nil)
+		if rec.Code != c.code {
+			t.Errorf("Status of %s returned %d, want %d", c.txid, rec.Code, c.code)
+		}
+	}
+}
+
+func TestEsploraFeeEstimates(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	// vertcoind estimates in coins per kB
+	n.SetFeeRate(1, 0.0002)
+	n.SetFeeRate(6, 0.00001234)
+	n.SetFeeRate(144, 0.00001)
+
+	s := newFakeStore()
+	cfg := config.Default()
+	p, err := processor.NewProcessor(nil, s, cfg.Indexer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHttpServer(n.Client(), s, p, cfg.HTTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var estimates map[string]float64
+	decode(t, do(h, "GET", "/api/fee-estimates", nil, nil), &estimates)
+	want := map[string]float64{"1": 20, "6": 1.234, "144": 1}
+	if len(estimates) != len(want) {
+		t.Errorf("Estimates are %v, want %v", estimates, want)
+	}
+	for target, rate := range want {
+		if got := estimates[target]; got < rate-1e-9 || got > rate+1e-9 {
+			t.Errorf("Estimate for %s blocks is %v sat/vB, want %v", target, got, rate)
+		}
+	}
+}
@@ -2,68 +2,114 @@ package http
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"os"
-	"runtime"
-	"time"
+	"strconv"
+	"sync"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/events"
 	"github.com/gertjaap/ocm-backend/logging"
 	"github.com/gertjaap/ocm-backend/processor"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/vertcoin"
 	"github.com/gorilla/mux"
-	"github.com/paulbellamy/ratecounter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type HttpServer struct {
 	srv           *http.Server
 	rpc           *rpcclient.Client
-	db            *sql.DB
+	store         store.Store
 	proc          *processor.Processor
-	mem           runtime.MemStats
-	responseTimes map[string]*ratecounter.AvgRateCounter
+	blockTimes    map[chainhash.Hash]int64
+	blockTimesMtx sync.Mutex
+	maxBatch      int
+	adminToken    string
+	log           *logging.Logger
+	ws            *wsHub
+	sse           *eventRing
 }
 
-func NewHttpServer(rpc *rpcclient.Client, db *sql.DB, p *processor.Processor) *HttpServer {
+func NewHttpServer(rpc *rpcclient.Client, s store.Store, p *processor.Processor, cfg config.HTTPConfig) (*HttpServer, error) {
 	h := new(HttpServer)
+	h.log = logging.Named("http")
+	h.maxBatch = cfg.BatchMax
+	h.adminToken = cfg.AdminToken
 
 	r := mux.NewRouter()
 	r.HandleFunc("/info", h.infoHandler)
 	r.HandleFunc("/health", h.healthHandler)
+	r.Handle("/metrics", promhttp.Handler())
 	r.HandleFunc("/balance/{script}", h.balanceHandler)
 	r.HandleFunc("/utxos/{script}", h.utxosHandler)
+	r.HandleFunc("/history/{script}", h.historyHandler)
+	r.HandleFunc("/address/{address}/balance", h.balanceHandler)
+	r.HandleFunc("/address/{address}/utxos", h.utxosHandler)
+	r.HandleFunc("/address/{address}/history", h.historyHandler)
+	r.HandleFunc("/xpub/{key}", h.xpubHandler)
+	r.HandleFunc("/descriptor", h.descriptorHandler)
+	r.HandleFunc("/block/{hashOrHeight}", h.blockHandler)
+	r.HandleFunc("/stats/difficulty", h.difficultyStatsHandler)
+	r.HandleFunc("/stats/hashrate", h.hashrateStatsHandler)
+	r.HandleFunc("/balances", h.balancesHandler).Methods("POST")
+	r.HandleFunc("/utxos", h.batchUtxosHandler).Methods("POST")
 	r.HandleFunc("/tx", h.txHandler).Methods("POST")
-
-	h.srv = &http.Server{
-		Handler: r,
-		Addr:    ":8000",
-		// Good practice: enforce timeouts for servers you create!
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-	}
-	h.db = db
+	r.HandleFunc("/ws", h.wsHandler)
+	r.HandleFunc("/events", h.eventsHandler)
+	r.HandleFunc("/admin/log-levels", h.requireAdmin(h.logLevelsHandler)).Methods("GET")
+	r.HandleFunc("/admin/log-levels", h.requireAdmin(h.setLogLevelsHandler)).Methods("PUT", "POST")
+	r.HandleFunc("/webhooks", h.requireAdmin(h.listWebhooksHandler)).Methods("GET")
+	r.HandleFunc("/webhooks", h.requireAdmin(h.addWebhookHandler)).Methods("POST")
+	r.HandleFunc("/webhooks/dead-letters", h.requireAdmin(h.webhookDeadLettersHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", h.requireAdmin(h.deleteWebhookHandler)).Methods("DELETE")
+	h.registerEsploraRoutes(r)
+	r.Use(h.requestMiddleware)
+
+	h.srv = newServer(r, cfg)
+	h.store = s
 	h.rpc = rpc
 	h.proc = p
-	h.responseTimes = map[string]*ratecounter.AvgRateCounter{
-		"info":    ratecounter.NewAvgRateCounter(15 * time.Minute),
-		"health":  ratecounter.NewAvgRateCounter(15 * time.Minute),
-		"utxos":   ratecounter.NewAvgRateCounter(15 * time.Minute),
-		"balance": ratecounter.NewAvgRateCounter(15 * time.Minute),
-	}
-	go h.memstatsLoop()
+	h.blockTimes = map[chainhash.Hash]int64{}
+	h.ws = newWsHub(h)
+	go h.ws.run(p.Events.Subscribe(wsEventBuffer))
+	h.sse = newEventRing(h.log)
+	go h.sse.run(p.Events.Subscribe(sseSubscribeDepth))
+	return h, nil
+}
+
+// NewMonitoringServer serves only /health and /metrics, for an indexer
+// that runs without the API
+func NewMonitoringServer(p *processor.Processor, cfg config.HTTPConfig) *HttpServer {
+	h := new(HttpServer)
+	h.log = logging.Named("http")
+	h.proc = p
+
+	r := mux.NewRouter()
+	r.HandleFunc("/health", h.healthHandler)
+	r.Handle("/metrics", promhttp.Handler())
+	r.Use(h.requestMiddleware)
+	h.srv = newServer(r, cfg)
 	return h
 }
 
-func (h *HttpServer) memstatsLoop() {
-	for {
-		runtime.ReadMemStats(&h.mem)
-		time.Sleep(time.Minute * 5)
+func newServer(handler http.Handler, cfg config.HTTPConfig) *http.Server {
+	return &http.Server{
+		Handler: handler,
+		Addr:    cfg.Listen,
+		// Good practice: enforce timeouts for servers you create!
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 }
 
@@ -72,29 +118,22 @@ func (h *HttpServer) Run() error {
 }
 
 func (h *HttpServer) infoHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	writeJson(w, map[string]interface{}{
 		"tipHeight":        h.proc.TipHeight,
 		"backendTipHeight": h.proc.BackendTipHeight,
 		"difficulty":       h.proc.Difficulty,
 	})
-	h.responseTimes["info"].Incr(time.Since(start).Nanoseconds())
 }
 
+// healthHandler reports whether the backend is up and how far it is behind
+// vertcoind. Response times and other metrics are exported on /metrics.
 func (h *HttpServer) healthHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	reply := map[string]interface{}{}
-
-	for k, v := range h.responseTimes {
-		reply[fmt.Sprintf("response_time_%s", k)] = roundDecimals(float64(v.Rate())/float64(math.Pow(10, 6)), 3)
-		reply[fmt.Sprintf("rps_last_15m_%s", k)] = roundDecimals(float64(v.Hits())/float64(15*60), 3)
-	}
-
-	reply["mem_alloc_mb"] = (h.mem.Alloc / 1024 / 1024)
-	reply["mem_sys_mb"] = (h.mem.Sys / 1024 / 1024)
+	reply["blocks_per_sec"] = roundDecimals(h.proc.BlocksPerSecond(), 3)
+	reply["tip_height"] = h.proc.TipHeight
+	reply["backend_tip_height"] = h.proc.BackendTipHeight
 	reply["hostname"], _ = os.Hostname()
 	writeJson(w, reply)
-	h.responseTimes["health"].Incr(time.Since(start).Nanoseconds())
 }
 
 func roundDecimals(v float64, d int) float64 {
@@ -102,47 +141,47 @@ func roundDecimals(v float64, d int) float64 {
 	return math.Round(v*div) / div
 }
 
-func (h *HttpServer) balanceHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+// scriptFromRequest returns the script a request is about, either given as
+// hex or as an address. It writes the error response when there is none.
+func scriptFromRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
 	vars := mux.Vars(r)
 
-	script, err := hex.DecodeString(vars["script"])
-	if err != nil {
-		logging.Errorf("Error decoding script: %v", err)
-		http.Error(w, "Invalid request", 500)
+	if addr, ok := vars["address"]; ok {
+		script, err := vertcoin.AddressToScript(addr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid address: %v", err), 400)
+			return nil, false
+		}
+		return script, true
 	}
 
-	var scriptID int64
-	scriptID = -1
-	err = h.db.QueryRow("select id from scripts where script=$1", script).Scan(&scriptID)
+	script, err := hex.DecodeString(vars["script"])
 	if err != nil {
-		if err != sql.ErrNoRows {
-			logging.Errorf("Error querying script: %v", err)
-			http.Error(w, "Internal server error", 500)
-		}
+		requestLog(r).Debug("Invalid script", "err", err)
+		http.Error(w, "Invalid script, must be hex", 400)
+		return nil, false
 	}
+	return script, true
+}
 
-	var confirmed int64
-	var immature int64
+func (h *HttpServer) balanceHandler(w http.ResponseWriter, r *http.Request) {
+	script, ok := scriptFromRequest(w, r)
+	if !ok {
+		return
+	}
 
-	if scriptID != -1 {
-		err = h.db.QueryRow("select coalesce(sum(value),0) from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where script_id=$1 AND (coinbase=true and b.height > (select height-101 from blocks order by height desc limit 1)) AND spent_in_tx IS NULL", scriptID).Scan(&immature)
-		if err != nil {
-			logging.Errorf("Error querying immature balance: %v", err)
-			http.Error(w, "Internal server error", 500)
-		}
-		err = h.db.QueryRow("select coalesce(sum(value),0) from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where script_id=$1 AND (coinbase=false or b.height <= (select height-101 from blocks order by height desc limit 1)) AND spent_in_tx IS NULL", scriptID).Scan(&confirmed)
-		if err != nil {
-			logging.Errorf("Error querying confirmed balance: %v", err)
-			http.Error(w, "Internal server error", 500)
-		}
+	balance, err := h.store.Balance(script)
+	if err != nil {
+		requestLog(r).Error("Error querying balance", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
 	}
 
 	writeJson(w, map[string]interface{}{
-		"confirmed": confirmed,
-		"maturing":  immature,
+		"confirmed":   balance.Confirmed,
+		"maturing":    balance.Maturing,
+		"unconfirmed": balance.Unconfirmed,
 	})
-	h.responseTimes["balance"].Incr(time.Since(start).Nanoseconds())
 }
 
 type Utxo struct {
@@ -159,146 +198,262 @@ func (h *HttpServer) txHandler(w http.ResponseWriter, r *http.Request) {
 	var txs txSend
 	json.NewDecoder(r.Body).Decode(&txs)
 
-	txBytes, err := hex.DecodeString(txs.RawTx)
+	txHash, err := h.broadcastTransaction(r.Context(), txs.RawTx)
 	if err != nil {
-		logging.Warnf("Received invalid transaction hex: %s", err.Error())
-		http.Error(w, "Request invalid", 500)
+		http.Error(w, err.Error(), 500)
 		return
 	}
+
+	writeJson(w, map[string]interface{}{
+		"txid": txHash.String(),
+	})
+}
+
+// broadcastTransaction sends a transaction to vertcoind and records it as
+// unconfirmed. The errors it returns are meant for the client.
+func (h *HttpServer) broadcastTransaction(ctx context.Context, rawTx string) (*chainhash.Hash, error) {
+	log := logging.FromContext(ctx)
+	txBytes, err := hex.DecodeString(rawTx)
+	if err != nil {
+		log.Warn("Received invalid transaction hex", "err", err)
+		return nil, errors.New("Request invalid")
+	}
 	tx := wire.NewMsgTx(2)
 	err = tx.Deserialize(bytes.NewReader(txBytes))
 	if err != nil {
-		logging.Warnf("Received invalid transaction: %s", err.Error())
-		http.Error(w, "Request invalid", 500)
-		return
+		log.Warn("Received invalid transaction", "err", err)
+		return nil, errors.New("Request invalid")
 	}
 
-	responseBytes, err := h.rpc.RawRequest("sendrawtransaction", []json.RawMessage{json.RawMessage([]byte(fmt.Sprintf("\"%s\"", txs.RawTx))), json.RawMessage([]byte("0"))})
+	responseBytes, err := h.rpc.RawRequest("sendrawtransaction", []json.RawMessage{json.RawMessage([]byte(fmt.Sprintf("\"%s\"", rawTx))), json.RawMessage([]byte("0"))})
 	if err != nil {
-		logging.Warnf("Transaction rejected by Core: %s", err.Error())
-		http.Error(w, "Transaction rejected", 500)
-		return
+		log.Warn("Transaction rejected by Core", "txid", tx.TxHash(), "err", err)
+		return nil, errors.New("Transaction rejected")
 	}
 
 	var response interface{}
 	err = json.Unmarshal(responseBytes, &response)
 	if err != nil {
-		logging.Warnf("Could not parse Core response to sendrawtransaction: %s", err.Error())
-		http.Error(w, "Internal Server Error - Transaction might have gone through", 500)
-		return
+		log.Warn("Could not parse Core response to sendrawtransaction", "err", err)
+		return nil, errors.New("Internal Server Error - Transaction might have gone through")
 	}
 
 	txHashStr, ok := response.(string)
 	if !ok {
-		logging.Warnf("Could not parse Core response to sendrawtransaction: %s", err.Error())
-		http.Error(w, "Internal Server Error - Transaction might have gone through", 500)
-		return
+		log.Warn("Could not parse Core response to sendrawtransaction", "response", string(responseBytes))
+		return nil, errors.New("Internal Server Error - Transaction might have gone through")
 	}
 
 	txHash, err := chainhash.NewHashFromStr(txHashStr)
 	if err != nil {
-		logging.Warnf("Unable to parse response [%s] into a TX Hash: %s", string(txHashStr), err.Error())
-		http.Error(w, "Transaction rejected", 500)
-		return
+		log.Warn("Unable to parse response into a TX Hash", "response", txHashStr, "err", err)
+		return nil, errors.New("Transaction rejected")
 	}
 	// Now the transaction is accepted, create a preliminary transaction without a block_id
 	// and make the inputs spent by that. Then the balances immediately reflect the spend.
-	// Outputs will be created once the block comes in that confirms the transaction
-	trx, err := h.db.Begin()
+	// Its outputs count as unconfirmed until the block comes in that confirms the transaction
+	err = h.store.AddUnconfirmedTransaction(tx)
 	if err != nil {
-		logging.Errorf("Error creating transaction: %s", err.Error())
-		http.Error(w, "Internal Server Error", 500)
+		log.Error("Error marking outputs as spent", "txid", txHash, "err", err)
+		return nil, errors.New("Internal Server Error")
+	}
+	h.proc.Events.Publish(events.Event{
+		Type:   events.TypeTx,
+		Height: h.proc.TipHeight,
+		Data:   map[string]string{"txid": txHash.String()},
+	})
+	return txHash, nil
+}
+
+func (h *HttpServer) utxosHandler(w http.ResponseWriter, r *http.Request) {
+	script, ok := scriptFromRequest(w, r)
+	if !ok {
 		return
 	}
-	var transID int64
-	err = trx.QueryRow("INSERT INTO transactions(hash, received) VALUES ($1, NOW()) RETURNING id", txHash.CloneBytes()).Scan(&transID)
+
+	utxos, err := h.store.Utxos(script)
 	if err != nil {
-		logging.Errorf("Error inserting transaction: %s", err.Error())
-		http.Error(w, "Internal Server Error", 500)
+		requestLog(r).Error("Error querying utxos", "err", err)
+		http.Error(w, "Internal server error", 500)
 		return
 	}
 
-	transactionsSpentInBlock := make([]*chainhash.Hash, 0)
-	for _, i := range tx.TxIn {
-		transactionsSpentInBlock = append(transactionsSpentInBlock, &i.PreviousOutPoint.Hash)
+	result := make([]Utxo, 0, len(utxos))
+	for _, u := range utxos {
+		result = append(result, Utxo{
+			Vout:   u.Vout,
+			Amount: u.Value,
+			TxID:   u.TxHash.String(),
+		})
 	}
-	txIDs, err := h.proc.QueryTransactionIDs(trx, transactionsSpentInBlock)
-	if err != nil {
-		logging.Errorf("Error getting spent transaction IDs: %s", err.Error())
-		http.Error(w, "Internal Server Error", 500)
+
+	writeJson(w, result)
+}
+
+type HistoryEntry struct {
+	TxID      string `json:"txid"`
+	Height    *int64 `json:"height"`
+	Time      *int64 `json:"time"`
+	Value     int64  `json:"value"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+func (h *HttpServer) historyHandler(w http.ResponseWriter, r *http.Request) {
+	script, ok := scriptFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	err = h.proc.MarkOutputsSpent(trx, transID, tx, txIDs)
-	if err != nil {
-		logging.Errorf("Error marking outputs as spent: %s", err.Error())
-		http.Error(w, "Internal Server Error", 500)
-		return
+	limit := 50
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, "Invalid limit, must be between 1 and 500", 400)
+			return
+		}
 	}
 
-	err = trx.Commit()
+	entries, next, err := h.store.History(script, r.URL.Query().Get("cursor"), limit)
 	if err != nil {
-		logging.Errorf("Error committing to database: %s", err.Error())
-		http.Error(w, "Internal Server Error", 500)
+		if err == store.ErrInvalidCursor {
+			http.Error(w, "Invalid cursor", 400)
+			return
+		}
+		requestLog(r).Error("Error querying history", "err", err)
+		http.Error(w, "Internal server error", 500)
 		return
 	}
 
-	writeJson(w, map[string]interface{}{
-		"txid": txHash.String(),
-	})
+	result := make([]HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		entry := HistoryEntry{
+			TxID:      e.TxHash.String(),
+			Value:     e.Value,
+			Confirmed: e.Confirmed,
+		}
+		if e.Confirmed {
+			height := e.Height
+			entry.Height = &height
+			if e.BlockTime != nil {
+				blockTime := e.BlockTime.Unix()
+				entry.Time = &blockTime
+			} else {
+				blockTime, err := h.blockTime(e.BlockHash)
+				if err != nil {
+					requestLog(r).Warn("Unable to get time of block", "hash", e.BlockHash, "err", err)
+				} else {
+					entry.Time = &blockTime
+				}
+			}
+		}
+		result = append(result, entry)
+	}
+
+	reply := map[string]interface{}{
+		"transactions": result,
+	}
+	if next != "" {
+		reply["nextCursor"] = next
+	}
+	writeJson(w, reply)
 }
 
-func (h *HttpServer) utxosHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	vars := mux.Vars(r)
+// blockTime returns the timestamp of a block from vertcoind, for blocks
+// that were indexed before their header was stored. Timestamps don't
+// change, so they are cached.
+func (h *HttpServer) blockTime(hash *chainhash.Hash) (int64, error) {
+	h.blockTimesMtx.Lock()
+	t, ok := h.blockTimes[*hash]
+	h.blockTimesMtx.Unlock()
+	if ok {
+		return t, nil
+	}
 
-	script, err := hex.DecodeString(vars["script"])
+	hdr, err := h.rpc.GetBlockHeader(hash)
 	if err != nil {
-		logging.Errorf("Error decoding script: %v", err)
-		http.Error(w, "Invalid request", 500)
+		return 0, err
 	}
+	t = hdr.Timestamp.Unix()
 
-	var scriptID int64
-	scriptID = -1
-	err = h.db.QueryRow("select id from scripts where script=$1", script).Scan(&scriptID)
-	if err != nil {
-		if err != sql.ErrNoRows {
-			logging.Errorf("Error querying script: %v", err)
-			http.Error(w, "Internal server error", 500)
-		}
+	h.blockTimesMtx.Lock()
+	if len(h.blockTimes) > 100000 {
+		h.blockTimes = map[chainhash.Hash]int64{}
 	}
+	h.blockTimes[*hash] = t
+	h.blockTimesMtx.Unlock()
+	return t, nil
+}
 
-	result := make([]Utxo, 0)
-	if scriptID != -1 {
-		rows, err := h.db.Query("select t.hash, o.vout, o.value from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where script_id=$1 AND (coinbase=false or b.height <= (select height-101 from blocks order by height desc limit 1)) AND spent_in_tx IS NULL", scriptID)
+type Block struct {
+	Hash       string  `json:"hash"`
+	Height     int64   `json:"height"`
+	Version    int32   `json:"version"`
+	PrevHash   string  `json:"prevHash,omitempty"`
+	MerkleRoot string  `json:"merkleRoot,omitempty"`
+	Time       *int64  `json:"time"`
+	Bits       string  `json:"bits"`
+	Nonce      uint32  `json:"nonce"`
+	TxCount    int64   `json:"txCount"`
+	Difficulty float64 `json:"difficulty"`
+}
+
+func (h *HttpServer) blockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var blk *store.Block
+	var err error
+	if len(vars["hashOrHeight"]) == 2*chainhash.HashSize {
+		var hash *chainhash.Hash
+		hash, err = chainhash.NewHashFromStr(vars["hashOrHeight"])
 		if err != nil {
-			logging.Errorf("Error querying utxos: %v", err)
-			http.Error(w, "Internal server error", 500)
+			http.Error(w, "Invalid block hash", 400)
+			return
 		}
-		for rows.Next() {
-			var txid []byte
-			var vout int64
-			var value int64
-			err = rows.Scan(&txid, &vout, &value)
-			if err == nil {
-				h, err := chainhash.NewHash(txid)
-				if err == nil {
-					result = append(result, Utxo{
-						Vout:   vout,
-						Amount: value,
-						TxID:   h.String(),
-					})
-				} else {
-					logging.Warnf("Utxo has invalid tx hash: %v", err)
-				}
-			} else {
-				logging.Warnf("Error scanning utxo row: %v", err)
-			}
+		blk, err = h.store.BlockByHash(hash)
+	} else {
+		var height int64
+		height, err = strconv.ParseInt(vars["hashOrHeight"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid block hash or height", 400)
+			return
 		}
+		blk, err = h.store.BlockByHeight(height)
+	}
+	if err != nil {
+		if err == store.ErrNotFound {
+			http.Error(w, "Block not found", 404)
+			return
+		}
+		requestLog(r).Error("Error querying block", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	result := Block{
+		Hash:    blk.Hash.String(),
+		Height:  blk.Height,
+		Version: blk.Version,
+		Bits:    fmt.Sprintf("%08x", blk.Bits),
+		Nonce:   blk.Nonce,
+		TxCount: blk.TxCount,
+	}
+	if blk.PrevHash != nil {
+		result.PrevHash = blk.PrevHash.String()
+	}
+	if blk.MerkleRoot != nil {
+		result.MerkleRoot = blk.MerkleRoot.String()
+	}
+	if blk.Time != nil {
+		blockTime := blk.Time.Unix()
+		result.Time = &blockTime
+	}
+	if blk.Bits != 0 {
+		result.Difficulty = h.proc.BitsToDiff(blk.Bits)
 	}
 
 	writeJson(w, result)
-	h.responseTimes["utxos"].Incr(time.Since(start).Nanoseconds())
 }
 
 func writeJson(w http.ResponseWriter, v interface{}) {
@@ -0,0 +1,396 @@
+package http
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/processor"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/store/storetest"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+// fakeStore answers the script lookups from maps keyed by the hex of the
+// script. The mempool and webhook methods are left unimplemented.
+type fakeStore struct {
+	*storetest.Chain
+	store.MempoolStore
+	store.WebhookStore
+
+	balances map[string]store.Balance
+	utxos    map[string][]store.Utxo
+	history  map[string][]store.HistoryEntry
+	cursors  []string
+	err      error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		Chain:    storetest.NewChain(),
+		balances: map[string]store.Balance{},
+		utxos:    map[string][]store.Utxo{},
+		history:  map[string][]store.HistoryEntry{},
+	}
+}
+
+func (f *fakeStore) Balance(script []byte) (store.Balance, error) {
+	return f.balances[hex.EncodeToString(script)], f.err
+}
+
+func (f *fakeStore) Utxos(script []byte) ([]store.Utxo, error) {
+	return f.utxos[hex.EncodeToString(script)], f.err
+}
+
+func (f *fakeStore) UnconfirmedUtxos(script []byte) ([]store.Utxo, error) {
+	return []store.Utxo{}, f.err
+}
+
+func (f *fakeStore) Balances(scripts [][]byte) ([]store.Balance, error) {
+	result := make([]store.Balance, 0, len(scripts))
+	for _, s := range scripts {
+		result = append(result, f.balances[hex.EncodeToString(s)])
+	}
+	return result, f.err
+}
+
+func (f *fakeStore) UtxosForScripts(scripts [][]byte) ([][]store.Utxo, error) {
+	result := make([][]store.Utxo, 0, len(scripts))
+	for _, s := range scripts {
+		result = append(result, f.utxos[hex.EncodeToString(s)])
+	}
+	return result, f.err
+}
+
+// History returns one entry per page, with the index of the next entry as
+// the cursor
+func (f *fakeStore) History(script []byte, cursor string, limit int) ([]store.HistoryEntry, string, error) {
+	f.cursors = append(f.cursors, cursor)
+	if f.err != nil {
+		return nil, "", f.err
+	}
+	entries := f.history[hex.EncodeToString(script)]
+	i := 0
+	if cursor != "" {
+		if cursor != "1" {
+			return nil, "", store.ErrInvalidCursor
+		}
+		i = 1
+	}
+	if i >= len(entries) {
+		return []store.HistoryEntry{}, "", nil
+	}
+	next := ""
+	if i+1 < len(entries) {
+		next = "1"
+	}
+	return entries[i : i+1], next, nil
+}
+
+func (f *fakeStore) ScriptByScriptHash(hash []byte) ([]byte, error) { return nil, store.ErrNotFound }
+func (f *fakeStore) IndexScriptHashes(limit int) (int64, error)     { return 0, nil }
+func (f *fakeStore) KnownScripts(scripts [][]byte) ([]bool, error) {
+	result := make([]bool, len(scripts))
+	for i, s := range scripts {
+		_, result[i] = f.balances[hex.EncodeToString(s)]
+	}
+	return result, f.err
+}
+
+func (f *fakeStore) Verify() ([]string, error) { return nil, nil }
+func (f *fakeStore) Close() error              { return nil }
+
+func newTestServer(t *testing.T, s *fakeStore, adminToken string) *HttpServer {
+	cfg := config.Default()
+	cfg.HTTP.AdminToken = adminToken
+	p, err := processor.NewProcessor(nil, s, cfg.Indexer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHttpServer(nil, s, p, cfg.HTTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func do(h *HttpServer, method, path string, body interface{}, header map[string]string) *httptest.ResponseRecorder {
+	var b bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&b).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &b)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.srv.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if rec.Code != 200 {
+		t.Fatalf("Status %d: %s", rec.Code, rec.Body.String())
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("Invalid response %s: %v", rec.Body.String(), err)
+	}
+}
+
+var (
+	testPubKeyHash = bytes.Repeat([]byte{0x11}, 20)
+	testScript     = hex.EncodeToString(vertcoin.PayToPubKeyHashScript(testPubKeyHash))
+	testAddress    = base58.CheckEncode(testPubKeyHash, vertcoin.MainNetParams.PubKeyHashAddrID)
+)
+
+func TestBalanceByScriptAndAddress(t *testing.T) {
+	s := newFakeStore()
+	s.balances[testScript] = store.Balance{Confirmed: 100, Maturing: 20, Unconfirmed: 3}
+	h := newTestServer(t, s, "")
+
+	for _, path := range []string{"/balance/" + testScript, "/address/" + testAddress + "/balance"} {
+		var reply map[string]int64
+		decode(t, do(h, "GET", path, nil, nil), &reply)
+		if reply["confirmed"] != 100 || reply["maturing"] != 20 || reply["unconfirmed"] != 3 {
+			t.Errorf("%s: unexpected balance %v", path, reply)
+		}
+	}
+
+	if rec := do(h, "GET", "/address/notanaddress/balance", nil, nil); rec.Code != 400 {
+		t.Errorf("Invalid address gave status %d", rec.Code)
+	}
+	for _, path := range []string{"/balance/nothex", "/utxos/abc", "/history/zz"} {
+		if rec := do(h, "GET", path, nil, nil); rec.Code != 400 {
+			t.Errorf("%s: invalid script gave status %d", path, rec.Code)
+		}
+	}
+
+	s.err = errors.New("Database down")
+	if rec := do(h, "GET", "/balance/"+testScript, nil, nil); rec.Code != 500 {
+		t.Errorf("Store error gave status %d", rec.Code)
+	}
+}
+
+func TestUtxos(t *testing.T) {
+	s := newFakeStore()
+	txHash := chainhash.Hash{1, 2, 3}
+	s.utxos[testScript] = []store.Utxo{{TxHash: txHash, Vout: 1, Value: 5000, Height: 10}}
+	h := newTestServer(t, s, "")
+
+	var reply []Utxo
+	decode(t, do(h, "GET", "/utxos/"+testScript, nil, nil), &reply)
+	if len(reply) != 1 || reply[0].TxID != txHash.String() || reply[0].Vout != 1 || reply[0].Amount != 5000 {
+		t.Errorf("Unexpected utxos %+v", reply)
+	}
+}
+
+func TestHistoryPages(t *testing.T) {
+	s := newFakeStore()
+	blockTime := time.Unix(1600000000, 0)
+	blockHash := chainhash.Hash{9}
+	s.history[testScript] = []store.HistoryEntry{
+		{TxHash: chainhash.Hash{2}, Value: -5},
+		{TxHash: chainhash.Hash{1}, Confirmed: true, Height: 7, BlockHash: &blockHash, BlockTime: &blockTime, Value: 10},
+	}
+	h := newTestServer(t, s, "")
+
+	var first struct {
+		Transactions []HistoryEntry `json:"transactions"`
+		NextCursor   string         `json:"nextCursor"`
+	}
+	decode(t, do(h, "GET", "/history/"+testScript+"?limit=1", nil, nil), &first)
+	if len(first.Transactions) != 1 || first.Transactions[0].Confirmed || first.Transactions[0].Height != nil || first.NextCursor != "1" {
+		t.Fatalf("Unexpected first page %+v", first)
+	}
+
+	var second struct {
+		Transactions []HistoryEntry `json:"transactions"`
+		NextCursor   *string        `json:"nextCursor"`
+	}
+	decode(t, do(h, "GET", "/history/"+testScript+"?limit=1&cursor="+first.NextCursor, nil, nil), &second)
+	tx := second.Transactions[0]
+	if !tx.Confirmed || *tx.Height != 7 || *tx.Time != blockTime.Unix() || tx.Value != 10 || second.NextCursor != nil {
+		t.Fatalf("Unexpected second page %+v", second)
+	}
+	if s.cursors[0] != "" || s.cursors[1] != "1" {
+		t.Errorf("Store got cursors %v", s.cursors)
+	}
+
+	for _, q := range []string{"?limit=0", "?limit=501", "?limit=x", "?cursor=bogus"} {
+		if rec := do(h, "GET", "/history/"+testScript+q, nil, nil); rec.Code != 400 {
+			t.Errorf("%s gave status %d", q, rec.Code)
+		}
+	}
+}
+
+func TestBatchBalances(t *testing.T) {
+	s := newFakeStore()
+	s.balances[testScript] = store.Balance{Confirmed: 100}
+	other := hex.EncodeToString([]byte{0x51})
+	s.balances[other] = store.Balance{Confirmed: 1, Unconfirmed: 2}
+	h := newTestServer(t, s, "")
+
+	var reply struct {
+		Balances []BalanceEntry   `json:"balances"`
+		Total    map[string]int64 `json:"total"`
+	}
+	// The address is the same script, so it is only counted once
+	body := batchRequest{Scripts: []string{testScript, other}, Addresses: []string{testAddress}}
+	decode(t, do(h, "POST", "/balances", body, nil), &reply)
+	if len(reply.Balances) != 2 || reply.Total["confirmed"] != 101 || reply.Total["unconfirmed"] != 2 {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+
+	tooMany := batchRequest{}
+	for i := 0; i <= h.maxBatch; i++ {
+		tooMany.Scripts = append(tooMany.Scripts, testScript)
+	}
+	for _, b := range []interface{}{batchRequest{}, tooMany, batchRequest{Scripts: []string{"zz"}}} {
+		if rec := do(h, "POST", "/balances", b, nil); rec.Code != 400 {
+			t.Errorf("%+v gave status %d", b, rec.Code)
+		}
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	disabled := newTestServer(t, newFakeStore(), "")
+	if rec := do(disabled, "GET", "/admin/log-levels", nil, map[string]string{"Authorization": "Bearer "}); rec.Code != 403 {
+		t.Errorf("Admin endpoint without a token configured gave status %d", rec.Code)
+	}
+
+	h := newTestServer(t, newFakeStore(), "secret")
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		if rec := do(h, "GET", "/admin/log-levels", nil, map[string]string{"Authorization": auth}); rec.Code != 401 {
+			t.Errorf("Authorization %q gave status %d", auth, rec.Code)
+		}
+	}
+	if rec := do(h, "GET", "/admin/log-levels", nil, map[string]string{"Authorization": "Bearer secret"}); rec.Code != 200 {
+		t.Errorf("Valid token gave status %d", rec.Code)
+	}
+}
+
+func TestHealthAndRequestID(t *testing.T) {
+	h := newTestServer(t, newFakeStore(), "")
+	h.proc.TipHeight = 42
+
+	rec := do(h, "GET", "/health", nil, map[string]string{requestIDHeader: "abc-123"})
+	var reply map[string]interface{}
+	decode(t, rec, &reply)
+	if reply["tip_height"] != float64(42) {
+		t.Errorf("Unexpected health %v", reply)
+	}
+	if rec.Header().Get(requestIDHeader) != "abc-123" {
+		t.Errorf("Request id not taken over, got %q", rec.Header().Get(requestIDHeader))
+	}
+	if do(h, "GET", "/health", nil, nil).Header().Get(requestIDHeader) == "" {
+		t.Error("No request id generated")
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	// pkh() of a key from the BIP-381 test vectors
+	const script = "76a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac"
+	const desc = "pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)"
+	s := newFakeStore()
+	s.balances[script] = store.Balance{Confirmed: 50, Unconfirmed: 5}
+	s.utxos[script] = []store.Utxo{{TxHash: chainhash.Hash{4}, Vout: 2, Value: 50}}
+	h := newTestServer(t, s, "")
+
+	var reply struct {
+		Descriptor string             `json:"descriptor"`
+		Balance    map[string]int64   `json:"balance"`
+		Scripts    []DescriptorScript `json:"scripts"`
+		Utxos      []DescriptorUtxo   `json:"utxos"`
+	}
+	decode(t, do(h, "GET", "/descriptor?descriptor="+desc, nil, nil), &reply)
+	if len(reply.Scripts) != 1 || reply.Scripts[0].Script != script || reply.Balance["confirmed"] != 50 || reply.Balance["unconfirmed"] != 5 {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+	if len(reply.Utxos) != 1 || reply.Utxos[0].Script != script || reply.Utxos[0].Vout != 2 {
+		t.Errorf("Unexpected utxos %+v", reply.Utxos)
+	}
+	if !strings.HasPrefix(reply.Descriptor, desc+"#") || len(reply.Descriptor) != len(desc)+9 {
+		t.Errorf("Descriptor returned as %s, want it with its checksum", reply.Descriptor)
+	}
+
+	// A ranged descriptor covers 20 indexes unless told otherwise
+	const xpub = "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB"
+	decode(t, do(h, "GET", "/descriptor?descriptor=wpkh("+xpub+"/0/*)", nil, nil), &reply)
+	if len(reply.Scripts) != 20 || reply.Scripts[0].Index != 0 || reply.Scripts[19].Index != 19 {
+		t.Errorf("Ranged descriptor expanded to %d scripts", len(reply.Scripts))
+	}
+	decode(t, do(h, "GET", "/descriptor?descriptor=wpkh("+xpub+"/0/*)&from=5&to=7", nil, nil), &reply)
+	if len(reply.Scripts) != 3 || reply.Scripts[0].Index != 5 {
+		t.Errorf("Range 5-7 expanded to %+v", reply.Scripts)
+	}
+
+	for _, q := range []string{
+		"descriptor=" + desc + "%23aaaaaaaa",
+		"descriptor=pk(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)",
+		"descriptor=wpkh(" + xpub + "/0/*)&from=7&to=5",
+		"descriptor=wpkh(" + xpub + "/0/*)&from=0&to=1000",
+		"descriptor=wpkh(" + xpub + "/0/*)&from=x",
+	} {
+		if rec := do(h, "GET", "/descriptor?"+q, nil, nil); rec.Code != 400 {
+			t.Errorf("%s gave status %d", q, rec.Code)
+		}
+	}
+}
+
+func TestXpub(t *testing.T) {
+	// BIP-84 account key of the mnemonic "abandon abandon ... about", and
+	// its scripts at 0/1 and 1/1
+	const zpub = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	const receive = "00149c90f934ea51fa0f6504177043e0908da6929983"
+	const change = "00144227d834f1aae95273f0c87495f4ff0cb3665452"
+	s := newFakeStore()
+	s.balances[receive] = store.Balance{Confirmed: 70}
+	s.balances[change] = store.Balance{Confirmed: 30, Unconfirmed: 1}
+	s.utxos[receive] = []store.Utxo{{TxHash: chainhash.Hash{5}, Vout: 0, Value: 70}}
+	s.utxos[change] = []store.Utxo{{TxHash: chainhash.Hash{6}, Vout: 1, Value: 30}}
+	h := newTestServer(t, s, "")
+
+	var reply struct {
+		Type             string           `json:"type"`
+		Balance          map[string]int64 `json:"balance"`
+		Utxos            []XpubUtxo       `json:"utxos"`
+		NextReceiveIndex uint32           `json:"nextReceiveIndex"`
+		NextChangeIndex  uint32           `json:"nextChangeIndex"`
+	}
+	decode(t, do(h, "GET", "/xpub/"+zpub, nil, nil), &reply)
+	if reply.Type != "p2wpkh" || reply.NextReceiveIndex != 2 || reply.NextChangeIndex != 2 {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+	if reply.Balance["confirmed"] != 100 || reply.Balance["unconfirmed"] != 1 {
+		t.Errorf("Unexpected balance %v", reply.Balance)
+	}
+	paths := map[string]string{}
+	for _, u := range reply.Utxos {
+		paths[u.Script] = u.Path
+	}
+	if len(reply.Utxos) != 2 || paths[receive] != "0/1" || paths[change] != "1/1" {
+		t.Errorf("Unexpected utxos %+v", reply.Utxos)
+	}
+
+	// With a gap of 1 the scan stops at the unused scripts at index 0
+	decode(t, do(h, "GET", "/xpub/"+zpub+"?gap=1", nil, nil), &reply)
+	if reply.NextReceiveIndex != 0 || reply.NextChangeIndex != 0 || len(reply.Utxos) != 0 {
+		t.Errorf("Gap of 1 found %+v", reply)
+	}
+
+	for _, path := range []string{"/xpub/notakey", "/xpub/" + zpub + "?gap=0", "/xpub/" + zpub + "?gap=201"} {
+		if rec := do(h, "GET", path, nil, nil); rec.Code != 400 {
+			t.Errorf("%s gave status %d", path, rec.Code)
+		}
+	}
+}
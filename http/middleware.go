@@ -0,0 +1,101 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/metrics"
+	"github.com/gorilla/mux"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestMiddleware gives every request an id and a logger that carries
+// it, and records the latency of the request under its route template, so
+// /balance/{script} is one series rather than one per script. The /ws and
+// /events streams are left out of the latencies, their duration is that of
+// the connection.
+func (h *HttpServer) requestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		log := h.log.With("requestId", id, "method", r.Method, "route", route)
+		r = r.WithContext(logging.NewContext(r.Context(), log))
+
+		if route == "/ws" || route == "/events" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(sw, r)
+		elapsed := time.Since(start)
+		metrics.RequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Observe(elapsed.Seconds())
+		log.Debug("Handled request", "path", r.URL.Path, "status", sw.status, "durationUs", elapsed.Microseconds())
+	})
+}
+
+// requestLog returns the logger of a request, which carries its id
+func requestLog(r *http.Request) *logging.Logger {
+	return logging.FromContext(r.Context())
+}
+
+// validRequestID accepts the ids set by proxies in front of the backend,
+// as long as they are short and printable
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusWriter remembers the status code written to the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/logging"
+)
+
+const (
+	// sseBufferSize is the number of recent events kept for clients that
+	// resume with Last-Event-ID
+	sseBufferSize     = 1024
+	sseKeepAlive      = 15 * time.Second
+	sseRetryMillis    = 5000
+	sseSubscribeDepth = 256
+)
+
+var sseEventNames = map[events.Type]string{
+	events.TypeBlock: "block",
+	events.TypeReorg: "reorg",
+	events.TypeTx:    "tx-accepted",
+}
+
+type sseEvent struct {
+	seq  uint64
+	name string
+	data []byte
+}
+
+// eventRing holds the most recent events. Event IDs are the start time of
+// the server and a sequence number, so IDs from before a restart are
+// recognized and those clients get everything that is retained.
+type eventRing struct {
+	mtx     sync.Mutex
+	epoch   int64
+	events  []sseEvent
+	nextSeq uint64
+	changed chan struct{}
+	log     *logging.Logger
+}
+
+func newEventRing(log *logging.Logger) *eventRing {
+	return &eventRing{
+		epoch:   time.Now().Unix(),
+		events:  make([]sseEvent, 0, sseBufferSize),
+		nextSeq: 1,
+		changed: make(chan struct{}),
+		log:     log,
+	}
+}
+
+func (r *eventRing) run(sub *events.Subscription) {
+	for e := range sub.C {
+		if n := sub.Dropped(); n > 0 {
+			r.log.Warn("Event stream missed events", "count", n)
+		}
+		name, ok := sseEventNames[e.Type]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			r.log.Error("Unable to encode event", "type", e.Type, "err", err)
+			continue
+		}
+		r.add(name, data)
+	}
+}
+
+func (r *eventRing) add(name string, data []byte) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.events) == sseBufferSize {
+		copy(r.events, r.events[1:])
+		r.events = r.events[:len(r.events)-1]
+	}
+	r.events = append(r.events, sseEvent{seq: r.nextSeq, name: name, data: data})
+	r.nextSeq++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// since returns the events after seq, and a channel that is closed when
+// the next event is added. missed is true when events after seq were
+// already evicted.
+func (r *eventRing) since(seq uint64) (evs []sseEvent, missed bool, changed chan struct{}) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	oldest := r.nextSeq
+	if len(r.events) > 0 {
+		oldest = r.events[0].seq
+	}
+	evs = make([]sseEvent, 0)
+	for _, e := range r.events {
+		if e.seq > seq {
+			evs = append(evs, e)
+		}
+	}
+	return evs, seq+1 < oldest, r.changed
+}
+
+// current returns the sequence number of the last event
+func (r *eventRing) current() uint64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.nextSeq - 1
+}
+
+func (r *eventRing) id(seq uint64) string {
+	return fmt.Sprintf("%d-%d", r.epoch, seq)
+}
+
+// parseID returns the sequence number to resume after. ok is false for IDs
+// of an earlier run and unparseable ones, whose resume point is unknown.
+func (r *eventRing) parseID(id string) (seq uint64, ok bool) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || epoch != r.epoch {
+		return 0, false
+	}
+	seq, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || seq > r.current() {
+		return 0, false
+	}
+	return seq, true
+}
+
+// eventsHandler streams block, reorg and tx-accepted events. Clients that
+// reconnect with Last-Event-ID get the events they missed. When those are
+// no longer retained, or the ID is of an earlier run, a reset event comes
+// first, followed by the events that are retained.
+func (h *HttpServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+	// The stream outlives the write timeout of the server
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		// For EventSource polyfills that can't set headers
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	seq := h.sse.current()
+	reset := false
+	if lastID != "" {
+		var ok bool
+		seq, ok = h.sse.parseID(lastID)
+		reset = !ok
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		evs, missed, changed := h.sse.since(seq)
+		if reset || missed {
+			// The client has to reload its state, the events it missed
+			// are gone
+			_, err := fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+			if err != nil {
+				return
+			}
+			reset = false
+		}
+		for _, e := range evs {
+			_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", h.sse.id(e.seq), e.name, e.data)
+			if err != nil {
+				return
+			}
+			seq = e.seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
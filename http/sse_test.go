@@ -0,0 +1,157 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/logging"
+)
+
+func seqs(evs []sseEvent) []uint64 {
+	result := make([]uint64, 0, len(evs))
+	for _, e := range evs {
+		result = append(result, e.seq)
+	}
+	return result
+}
+
+func TestEventRingEvictsOldest(t *testing.T) {
+	r := newEventRing(logging.Named("http"))
+	evs, missed, _ := r.since(0)
+	if len(evs) != 0 || missed {
+		t.Fatalf("Empty ring returned %v, missed=%v", seqs(evs), missed)
+	}
+
+	for i := 0; i < sseBufferSize+10; i++ {
+		r.add("block", []byte(fmt.Sprintf(`{"height":%d}`, i)))
+	}
+	if r.current() != sseBufferSize+10 {
+		t.Errorf("Current is %d, want %d", r.current(), sseBufferSize+10)
+	}
+	evs, missed, _ = r.since(0)
+	if len(evs) != sseBufferSize || evs[0].seq != 11 || evs[len(evs)-1].seq != sseBufferSize+10 || !missed {
+		t.Errorf("Since 0 returned %d events from %d, missed=%v, want %d from 11 and missed", len(evs), evs[0].seq, missed, sseBufferSize)
+	}
+	if string(evs[0].data) != `{"height":10}` {
+		t.Errorf("Oldest event is %s", evs[0].data)
+	}
+
+	for _, c := range []struct {
+		seq    uint64
+		count  int
+		missed bool
+	}{
+		// Event 10 was evicted, the client needs 10 too
+		{9, sseBufferSize, true},
+		// The oldest evicted event is the last one the client has
+		{10, sseBufferSize, false},
+		{sseBufferSize + 5, 5, false},
+		{sseBufferSize + 10, 0, false},
+	} {
+		evs, missed, _ := r.since(c.seq)
+		if len(evs) != c.count || missed != c.missed {
+			t.Errorf("Since %d returned %d events, missed=%v, want %d, missed=%v", c.seq, len(evs), missed, c.count, c.missed)
+		}
+	}
+}
+
+func TestEventRingChanged(t *testing.T) {
+	r := newEventRing(logging.Named("http"))
+	_, _, changed := r.since(0)
+	select {
+	case <-changed:
+		t.Fatal("Changed before an event was added")
+	default:
+	}
+	r.add("block", []byte("{}"))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("Not changed after an event was added")
+	}
+}
+
+func TestEventRingParseID(t *testing.T) {
+	r := newEventRing(logging.Named("http"))
+	for i := 0; i < 3; i++ {
+		r.add("block", []byte("{}"))
+	}
+	for _, c := range []struct {
+		id  string
+		seq uint64
+		ok  bool
+	}{
+		{r.id(2), 2, true},
+		{r.id(3), 3, true},
+		{r.id(0), 0, true},
+		// Not handed out yet
+		{r.id(4), 0, false},
+		// An earlier run
+		{fmt.Sprintf("%d-2", r.epoch-60), 0, false},
+		{"2", 0, false},
+		{"abc-2", 0, false},
+		{fmt.Sprintf("%d-x", r.epoch), 0, false},
+		{"", 0, false},
+	} {
+		seq, ok := r.parseID(c.id)
+		if seq != c.seq || ok != c.ok {
+			t.Errorf("%q parsed as %d, ok=%v, want %d, ok=%v", c.id, seq, ok, c.seq, c.ok)
+		}
+	}
+}
+
+// stream returns what the event stream sends to a client resuming after
+// lastID within a short time
+func stream(h *HttpServer, lastID string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	rec := httptest.NewRecorder()
+	h.srv.Handler.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestEventsResetWhenResumePointIsGone(t *testing.T) {
+	h := newTestServer(t, newFakeStore(), "")
+	for i := 0; i < sseBufferSize+1; i++ {
+		h.sse.add("block", []byte(fmt.Sprintf(`{"height":%d}`, i)))
+	}
+	last := fmt.Sprintf("id: %s\nevent: block\ndata: {\"height\":%d}\n\n", h.sse.id(sseBufferSize+1), sseBufferSize)
+
+	for _, c := range []struct {
+		lastID string
+		reset  bool
+		first  string
+	}{
+		// New clients only get what happens from now on
+		{"", false, ""},
+		{h.sse.id(sseBufferSize), false, last},
+		// Event 1 was evicted
+		{h.sse.id(0), true, h.sse.id(2)},
+		{fmt.Sprintf("%d-5", h.sse.epoch-60), true, h.sse.id(2)},
+	} {
+		body := stream(h, c.lastID)
+		if !strings.HasPrefix(body, "retry: ") {
+			t.Errorf("%q: stream starts with %q", c.lastID, body)
+		}
+		if strings.Contains(body, "event: reset\n") != c.reset {
+			t.Errorf("%q: stream is %q, want reset=%v", c.lastID, body, c.reset)
+		}
+		if c.reset && strings.Index(body, "event: reset") > strings.Index(body, "id: ") {
+			t.Errorf("%q: reset does not come before the events", c.lastID)
+		}
+		if c.first == "" && strings.Contains(body, "id: ") {
+			t.Errorf("%q: stream is %q, want no events", c.lastID, body)
+		}
+		if c.first != "" && !strings.Contains(body, c.first) {
+			t.Errorf("%q: stream is %q, want it to contain %q", c.lastID, body, c.first)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+const maxStatsBuckets = 2000
+
+// maxStatsBlocks caps the number of blocks a series reads from the store.
+// Ranges by time are capped at the time those blocks take to mine.
+const (
+	maxStatsBlocks  = 100000
+	maxStatsSeconds = maxStatsBlocks * 150
+)
+
+// statsRange is the range of blocks a series covers, either by height or
+// by time. The bucket size is in blocks or seconds accordingly.
+type statsRange struct {
+	byHeight bool
+	from     int64
+	to       int64
+	bucket   int64
+}
+
+type DifficultyPoint struct {
+	Start      int64   `json:"start"`
+	Height     int64   `json:"height"`
+	Time       int64   `json:"time"`
+	Difficulty float64 `json:"difficulty"`
+}
+
+type HashratePoint struct {
+	Start    int64   `json:"start"`
+	Height   int64   `json:"height"`
+	Time     int64   `json:"time"`
+	Hashrate float64 `json:"hashrate"`
+}
+
+func (h *HttpServer) difficultyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseStatsRange(r, h.proc.TipHeight)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	stats, err := h.queryBlockStats(rng, 0)
+	if err != nil {
+		requestLog(r).Error("Error querying block stats", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	result := make([]DifficultyPoint, 0)
+	for _, b := range rng.buckets(stats) {
+		sum := float64(0)
+		for _, st := range b {
+			sum += st.Difficulty
+		}
+		last := b[len(b)-1]
+		result = append(result, DifficultyPoint{
+			Start:      rng.bucketStart(last),
+			Height:     last.Height,
+			Time:       last.Time.Unix(),
+			Difficulty: sum / float64(len(b)),
+		})
+	}
+
+	writeJson(w, result)
+}
+
+// hashrateStatsHandler estimates the network hashrate at the end of each
+// bucket from the work done in the preceding window of blocks and the time
+// it took to mine them.
+func (h *HttpServer) hashrateStatsHandler(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseStatsRange(r, h.proc.TipHeight)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	window := int64(120)
+	windowStr := r.URL.Query().Get("window")
+	if windowStr != "" {
+		window, err = strconv.ParseInt(windowStr, 10, 64)
+		if err != nil || window < 1 || window > 10000 {
+			http.Error(w, "Invalid window, must be between 1 and 10000 blocks", 400)
+			return
+		}
+	}
+
+	stats, err := h.queryBlockStats(rng, window)
+	if err != nil {
+		requestLog(r).Error("Error querying block stats", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	// work[i] is the total work up to and including stats[i]
+	work := make([]float64, len(stats))
+	index := map[int64]int{}
+	for i, st := range stats {
+		work[i] = st.Difficulty * math.Pow(2, 32)
+		if i > 0 {
+			work[i] += work[i-1]
+		}
+		index[st.Height] = i
+	}
+
+	result := make([]HashratePoint, 0)
+	for _, b := range rng.buckets(stats) {
+		last := b[len(b)-1]
+		i := index[last.Height]
+		j := i - int(window)
+		if j < 0 {
+			j = 0
+		}
+		elapsed := last.Time.Sub(stats[j].Time).Seconds()
+		if i == j || elapsed <= 0 {
+			continue
+		}
+		result = append(result, HashratePoint{
+			Start:    rng.bucketStart(last),
+			Height:   last.Height,
+			Time:     last.Time.Unix(),
+			Hashrate: (work[i] - work[j]) / elapsed,
+		})
+	}
+
+	writeJson(w, result)
+}
+
+// queryBlockStats returns the stats of the blocks in the range, preceded
+// by the stats of up to extra blocks before it.
+func (h *HttpServer) queryBlockStats(rng statsRange, extra int64) ([]store.BlockStat, error) {
+	var stats []store.BlockStat
+	var err error
+	if rng.byHeight {
+		stats, err = h.store.BlockStatsByHeight(rng.from-extra, rng.to)
+	} else {
+		stats, err = h.store.BlockStatsByTime(time.Unix(rng.from, 0), time.Unix(rng.to, 0))
+		if err == nil && extra > 0 && len(stats) > 0 {
+			var before []store.BlockStat
+			before, err = h.store.BlockStatsByHeight(stats[0].Height-extra, stats[0].Height-1)
+			stats = append(before, stats...)
+		}
+	}
+	return stats, err
+}
+
+// parseStatsRange reads the range from the query string: either fromHeight
+// and toHeight, or from and to as unix timestamps, and the bucket size in
+// blocks or seconds respectively. Without a range, it covers the last week.
+// A range can't span more than maxStatsBlocks blocks, or the time they take.
+func parseStatsRange(r *http.Request, tipHeight int64) (statsRange, error) {
+	q := r.URL.Query()
+	rng := statsRange{}
+
+	parse := func(name string, def int64) (int64, error) {
+		v := q.Get(name)
+		if v == "" {
+			return def, nil
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, errors.New("Invalid " + name)
+		}
+		return i, nil
+	}
+
+	var err error
+	if q.Get("fromHeight") != "" || q.Get("toHeight") != "" {
+		rng.byHeight = true
+		rng.to, err = parse("toHeight", tipHeight)
+		if err != nil {
+			return rng, err
+		}
+		defaultFrom := rng.to - maxStatsBlocks + 1
+		if defaultFrom < 0 {
+			defaultFrom = 0
+		}
+		rng.from, err = parse("fromHeight", defaultFrom)
+		if err != nil {
+			return rng, err
+		}
+		rng.bucket, err = parse("bucket", (rng.to-rng.from)/maxStatsBuckets+1)
+		if err != nil {
+			return rng, err
+		}
+	} else {
+		rng.to, err = parse("to", time.Now().Unix())
+		if err != nil {
+			return rng, err
+		}
+		rng.from, err = parse("from", rng.to-7*24*60*60)
+		if err != nil {
+			return rng, err
+		}
+		rng.bucket, err = parse("bucket", 60*60)
+		if err != nil {
+			return rng, err
+		}
+	}
+
+	if rng.from > rng.to {
+		return rng, errors.New("Invalid range, start is after end")
+	}
+	if rng.byHeight && rng.to-rng.from >= maxStatsBlocks {
+		return rng, fmt.Errorf("Range too large, it can span at most %d blocks", maxStatsBlocks)
+	}
+	if !rng.byHeight && rng.to-rng.from > maxStatsSeconds {
+		return rng, fmt.Errorf("Range too large, it can span at most %d days", maxStatsSeconds/(24*60*60))
+	}
+	if rng.bucket < 1 {
+		return rng, errors.New("Invalid bucket size")
+	}
+	if (rng.to-rng.from)/rng.bucket >= maxStatsBuckets {
+		return rng, errors.New("Too many buckets, increase the bucket size or reduce the range")
+	}
+	return rng, nil
+}
+
+func (rng statsRange) bucketStart(st store.BlockStat) int64 {
+	v := st.Time.Unix()
+	if rng.byHeight {
+		v = st.Height
+	}
+	return rng.from + ((v-rng.from)/rng.bucket)*rng.bucket
+}
+
+// buckets groups the stats that fall inside the range into buckets, in
+// order. Empty buckets are left out.
+func (rng statsRange) buckets(stats []store.BlockStat) [][]store.BlockStat {
+	grouped := map[int64][]store.BlockStat{}
+	starts := make([]int64, 0)
+	for _, st := range stats {
+		v := st.Time.Unix()
+		if rng.byHeight {
+			v = st.Height
+		}
+		if v < rng.from || v > rng.to {
+			continue
+		}
+		bs := rng.bucketStart(st)
+		if _, ok := grouped[bs]; !ok {
+			starts = append(starts, bs)
+		}
+		grouped[bs] = append(grouped[bs], st)
+	}
+
+	// Block times aren't strictly increasing, so buckets by time can come
+	// out of order
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	result := make([][]store.BlockStat, 0, len(starts))
+	for _, bs := range starts {
+		result = append(result, grouped[bs])
+	}
+	return result
+}
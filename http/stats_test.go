@@ -0,0 +1,159 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+func TestParseStatsRangeCapsSpan(t *testing.T) {
+	const tip = 1500000
+	for _, c := range []struct {
+		query string
+		ok    bool
+		from  int64
+		to    int64
+	}{
+		// Without a start, a height range spans as many blocks as allowed
+		{"?toHeight=1500000", true, tip - maxStatsBlocks + 1, tip},
+		{"?fromHeight=100&toHeight=200", true, 100, 200},
+		{"?fromHeight=0&toHeight=1000000&bucket=1000", false, 0, 0},
+		{"?fromHeight=1000&toHeight=100", false, 0, 0},
+		{"?from=1600000000&to=1600604800", true, 1600000000, 1600604800},
+		{"?from=1000000000&to=1600000000&bucket=1000000", false, 0, 0},
+		{"?fromHeight=0&toHeight=10000&bucket=1", false, 0, 0},
+	} {
+		rng, err := parseStatsRange(httptest.NewRequest("GET", "/stats/difficulty"+c.query, nil), tip)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: got error %v, want ok=%v", c.query, err, c.ok)
+			continue
+		}
+		if c.ok && (rng.from != c.from || rng.to != c.to) {
+			t.Errorf("%s: range is %d-%d, want %d-%d", c.query, rng.from, rng.to, c.from, c.to)
+		}
+	}
+}
+
+const statsBaseTime = 1600000000
+
+// Blocks 0 to 5 have statsBitsA, 6 to 9 statsBitsB. Block 7 and later take
+// twice as long.
+const (
+	statsBitsA = 0x1b0404cb
+	statsBitsB = 0x1a05db8b
+)
+
+var statsTimes = []int64{0, 100, 200, 300, 400, 500, 600, 800, 1000, 1200}
+
+func newStatsServer(t *testing.T) *HttpServer {
+	s := newFakeStore()
+	for height, offset := range statsTimes {
+		bits := uint32(statsBitsA)
+		if height >= 6 {
+			bits = statsBitsB
+		}
+		blk := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, bits, uint32(height)))
+		blk.Header.Timestamp = time.Unix(statsBaseTime+offset, 0)
+		err := s.InsertBlock(int64(height), blk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newTestServer(t, s, "")
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Abs(b)
+}
+
+func TestDifficultyStatsBuckets(t *testing.T) {
+	h := newStatsServer(t)
+	dA := vertcoin.BitsToDifficulty(statsBitsA)
+	dB := vertcoin.BitsToDifficulty(statsBitsB)
+
+	for _, c := range []struct {
+		query string
+		want  []DifficultyPoint
+	}{
+		// Buckets of 3 blocks from height 2, the last one only partly
+		// filled
+		{"?fromHeight=2&toHeight=9&bucket=3", []DifficultyPoint{
+			{Start: 2, Height: 4, Time: statsBaseTime + 400, Difficulty: dA},
+			{Start: 5, Height: 7, Time: statsBaseTime + 800, Difficulty: (dA + 2*dB) / 3},
+			{Start: 8, Height: 9, Time: statsBaseTime + 1200, Difficulty: dB},
+		}},
+		// Buckets of 5 minutes, where the one starting at 900 only has
+		// block 8 at 1000
+		{fmt.Sprintf("?from=%d&to=%d&bucket=300", statsBaseTime, statsBaseTime+1200), []DifficultyPoint{
+			{Start: statsBaseTime, Height: 2, Time: statsBaseTime + 200, Difficulty: dA},
+			{Start: statsBaseTime + 300, Height: 5, Time: statsBaseTime + 500, Difficulty: dA},
+			{Start: statsBaseTime + 600, Height: 7, Time: statsBaseTime + 800, Difficulty: dB},
+			{Start: statsBaseTime + 900, Height: 8, Time: statsBaseTime + 1000, Difficulty: dB},
+			{Start: statsBaseTime + 1200, Height: 9, Time: statsBaseTime + 1200, Difficulty: dB},
+		}},
+	} {
+		var points []DifficultyPoint
+		decode(t, do(h, "GET", "/stats/difficulty"+c.query, nil, nil), &points)
+		if len(points) != len(c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.query, points, c.want)
+			continue
+		}
+		for i, p := range points {
+			w := c.want[i]
+			if p.Start != w.Start || p.Height != w.Height || p.Time != w.Time || !closeTo(p.Difficulty, w.Difficulty) {
+				t.Errorf("%s: point %d is %+v, want %+v", c.query, i, p, w)
+			}
+		}
+	}
+}
+
+func TestHashrateStats(t *testing.T) {
+	h := newStatsServer(t)
+	dA := vertcoin.BitsToDifficulty(statsBitsA)
+	dB := vertcoin.BitsToDifficulty(statsBitsB)
+	const work = 1 << 32
+
+	for _, c := range []struct {
+		query string
+		want  []HashratePoint
+	}{
+		// The window of 3 blocks reaches back before the range: block 7
+		// is compared to block 4, so blocks 5 to 7 were mined in 400
+		// seconds
+		{"?fromHeight=6&toHeight=9&bucket=2&window=3", []HashratePoint{
+			{Start: 6, Height: 7, Time: statsBaseTime + 800, Hashrate: (dA + 2*dB) * work / 400},
+			{Start: 8, Height: 9, Time: statsBaseTime + 1200, Hashrate: 3 * dB * work / 600},
+		}},
+		// The window is cut off at genesis
+		{"?fromHeight=0&toHeight=3&bucket=2&window=5", []HashratePoint{
+			{Start: 0, Height: 1, Time: statsBaseTime + 100, Hashrate: dA * work / 100},
+			{Start: 2, Height: 3, Time: statsBaseTime + 300, Hashrate: 3 * dA * work / 300},
+		}},
+		// Genesis has nothing to compare to
+		{"?fromHeight=0&toHeight=0&bucket=1", []HashratePoint{}},
+	} {
+		var points []HashratePoint
+		decode(t, do(h, "GET", "/stats/hashrate"+c.query, nil, nil), &points)
+		if len(points) != len(c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.query, points, c.want)
+			continue
+		}
+		for i, p := range points {
+			w := c.want[i]
+			if p.Start != w.Start || p.Height != w.Height || p.Time != w.Time || !closeTo(p.Hashrate, w.Hashrate) {
+				t.Errorf("%s: point %d is %+v, want %+v", c.query, i, p, w)
+			}
+		}
+	}
+
+	rec := do(h, "GET", "/stats/hashrate?fromHeight=0&toHeight=9&window=0", nil, nil)
+	if rec.Code != 400 {
+		t.Errorf("Window of 0 returned %d, want 400", rec.Code)
+	}
+}
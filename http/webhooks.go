@@ -0,0 +1,181 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+	"github.com/gorilla/mux"
+)
+
+type webhookRequest struct {
+	Script  string `json:"script"`
+	Address string `json:"address"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret"`
+}
+
+type WebhookEntry struct {
+	ID      int64  `json:"id"`
+	Script  string `json:"script"`
+	URL     string `json:"url"`
+	Created int64  `json:"created"`
+	// Secret is only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type DeadLetterEntry struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhookId"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError"`
+	Created   int64           `json:"created"`
+	Failed    int64           `json:"failed"`
+}
+
+func (h *HttpServer) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.store.Webhooks()
+	if err != nil {
+		requestLog(r).Error("Error querying webhooks", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	result := make([]WebhookEntry, 0, len(hooks))
+	for _, wh := range hooks {
+		result = append(result, WebhookEntry{
+			ID:      wh.ID,
+			Script:  hex.EncodeToString(wh.Script),
+			URL:     wh.URL,
+			Created: wh.Created.Unix(),
+		})
+	}
+	writeJson(w, result)
+}
+
+// addWebhookHandler watches a script, given as hex or as an address. When
+// no secret is given to sign the deliveries with, one is generated and
+// returned in the response. It can't be retrieved afterwards.
+func (h *HttpServer) addWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", 400)
+		return
+	}
+
+	var script []byte
+	switch {
+	case req.Script != "" && req.Address != "":
+		http.Error(w, "Give either a script or an address, not both", 400)
+		return
+	case req.Address != "":
+		script, err = vertcoin.AddressToScript(req.Address)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid address: %v", err), 400)
+			return
+		}
+	default:
+		script, err = hex.DecodeString(req.Script)
+		if err != nil || len(script) == 0 {
+			http.Error(w, "Invalid script", 400)
+			return
+		}
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "Invalid url, must be an absolute http or https URL", 400)
+		return
+	}
+
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			requestLog(r).Error("Error generating webhook secret", "err", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	wh, err := h.store.AddWebhook(script, req.URL, req.Secret)
+	if err != nil {
+		requestLog(r).Error("Error adding webhook", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	writeJson(w, WebhookEntry{
+		ID:      wh.ID,
+		Script:  hex.EncodeToString(wh.Script),
+		URL:     wh.URL,
+		Created: wh.Created.Unix(),
+		Secret:  wh.Secret,
+	})
+}
+
+func (h *HttpServer) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook id", 400)
+		return
+	}
+
+	err = h.store.DeleteWebhook(id)
+	if err == store.ErrNotFound {
+		http.Error(w, "Webhook not found", 404)
+		return
+	}
+	if err != nil {
+		requestLog(r).Error("Error deleting webhook", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (h *HttpServer) webhookDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 1000 {
+			http.Error(w, "Invalid limit, must be between 1 and 1000", 400)
+			return
+		}
+	}
+
+	letters, err := h.store.WebhookDeadLetters(limit)
+	if err != nil {
+		requestLog(r).Error("Error querying webhook dead letters", "err", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	result := make([]DeadLetterEntry, 0, len(letters))
+	for _, d := range letters {
+		result = append(result, DeadLetterEntry{
+			ID:        d.ID,
+			WebhookID: d.WebhookID,
+			URL:       d.URL,
+			Payload:   json.RawMessage(d.Payload),
+			Attempts:  d.Attempts,
+			LastError: d.LastError,
+			Created:   d.Created.Unix(),
+			Failed:    d.Failed.Unix(),
+		})
+	}
+	writeJson(w, result)
+}
@@ -0,0 +1,401 @@
+package http
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsSendBuffer is the number of messages queued for a client. Clients
+	// that fall further behind are disconnected.
+	wsSendBuffer = 64
+	// wsEventBuffer is the number of bus events buffered while an update
+	// is sent. Events that arrive meanwhile are coalesced anyway.
+	wsEventBuffer = 100
+	// wsLookupSize is the number of watched scripts whose balances are
+	// looked up in the store at once
+	wsLookupSize = 500
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = 50 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// The API is public and has no cookies to protect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type wsRequest struct {
+	Method string       `json:"method"`
+	Params batchRequest `json:"params"`
+	Tip    bool         `json:"tip"`
+}
+
+type WsBalance struct {
+	batchItem
+	Confirmed   int64            `json:"confirmed"`
+	Maturing    int64            `json:"maturing"`
+	Unconfirmed int64            `json:"unconfirmed"`
+	Delta       map[string]int64 `json:"delta,omitempty"`
+}
+
+// wsSubscription is a script a client watches, with the balance it was
+// last sent
+type wsSubscription struct {
+	item    batchItem
+	script  []byte
+	balance store.Balance
+}
+
+type wsSession struct {
+	conn    *websocket.Conn
+	send    chan interface{}
+	closed  chan struct{}
+	once    sync.Once
+	mtx     sync.Mutex
+	subs    map[string]*wsSubscription
+	tip     bool
+	maxSubs int
+	log     *logging.Logger
+}
+
+// wsHub keeps track of the connected clients and pushes updates to them
+// when events come in from the processor or the API
+type wsHub struct {
+	h           *HttpServer
+	sessions    map[*wsSession]bool
+	sessionsMtx sync.Mutex
+}
+
+func newWsHub(h *HttpServer) *wsHub {
+	return &wsHub{h: h, sessions: map[*wsSession]bool{}}
+}
+
+func (h *HttpServer) wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already wrote the error response
+		requestLog(r).Debug("Unable to upgrade websocket connection", "err", err)
+		return
+	}
+
+	sess := &wsSession{
+		conn:    conn,
+		send:    make(chan interface{}, wsSendBuffer),
+		closed:  make(chan struct{}),
+		subs:    map[string]*wsSubscription{},
+		maxSubs: h.maxBatch,
+		log:     requestLog(r).With("remote", r.RemoteAddr),
+	}
+	h.ws.sessionsMtx.Lock()
+	h.ws.sessions[sess] = true
+	h.ws.sessionsMtx.Unlock()
+
+	go sess.writeLoop()
+	h.ws.readLoop(sess)
+
+	h.ws.sessionsMtx.Lock()
+	delete(h.ws.sessions, sess)
+	h.ws.sessionsMtx.Unlock()
+	sess.close()
+}
+
+// queue sends a message to the client without blocking. A client whose
+// queue is full can't keep up and is disconnected.
+func (sess *wsSession) queue(msg interface{}) {
+	select {
+	case <-sess.closed:
+	case sess.send <- msg:
+	default:
+		sess.log.Debug("Disconnecting slow websocket client")
+		sess.close()
+	}
+}
+
+func (sess *wsSession) close() {
+	sess.once.Do(func() {
+		close(sess.closed)
+		sess.conn.Close()
+	})
+}
+
+func (sess *wsSession) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.closed:
+			return
+		case msg := <-sess.send:
+			sess.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := sess.conn.WriteJSON(msg)
+			if err != nil {
+				sess.close()
+				return
+			}
+		case <-ticker.C:
+			sess.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := sess.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				sess.close()
+				return
+			}
+		}
+	}
+}
+
+func wsError(msg string) map[string]interface{} {
+	return map[string]interface{}{"type": "error", "message": msg}
+}
+
+func (hub *wsHub) readLoop(sess *wsSession) {
+	sess.conn.SetReadLimit(1024 * 1024)
+	sess.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	sess.conn.SetPongHandler(func(string) error {
+		sess.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	for {
+		_, msg, err := sess.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		err = json.Unmarshal(msg, &req)
+		if err != nil {
+			sess.queue(wsError("Invalid message"))
+			continue
+		}
+
+		switch req.Method {
+		case "subscribe":
+			hub.subscribe(sess, req)
+		case "unsubscribe":
+			hub.unsubscribe(sess, req)
+		default:
+			sess.queue(wsError(fmt.Sprintf("Unknown method %s", req.Method)))
+		}
+	}
+}
+
+// parseWsItems resolves the scripts and addresses of a request
+func parseWsItems(req batchRequest) ([]batchItem, [][]byte, error) {
+	items := make([]batchItem, 0)
+	scripts := make([][]byte, 0)
+	for _, s := range req.Scripts {
+		script, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid script %s", s)
+		}
+		items = append(items, batchItem{Script: s})
+		scripts = append(scripts, script)
+	}
+	for _, a := range req.Addresses {
+		script, err := vertcoin.AddressToScript(a)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid address %s: %v", a, err)
+		}
+		items = append(items, batchItem{Script: hex.EncodeToString(script), Address: a})
+		scripts = append(scripts, script)
+	}
+	return items, scripts, nil
+}
+
+// subscribe adds scripts and/or the tip to the subscriptions of a client,
+// and sends the current balances and tip
+func (hub *wsHub) subscribe(sess *wsSession, req wsRequest) {
+	items, scripts, err := parseWsItems(req.Params)
+	if err != nil {
+		sess.queue(wsError(err.Error()))
+		return
+	}
+
+	sess.mtx.Lock()
+	count := len(sess.subs)
+	for _, item := range items {
+		if _, ok := sess.subs[item.Script]; !ok {
+			count++
+		}
+	}
+	sess.mtx.Unlock()
+	if count > sess.maxSubs {
+		sess.queue(wsError(fmt.Sprintf("Too many scripts, at most %d are allowed per connection", sess.maxSubs)))
+		return
+	}
+
+	balances, err := hub.h.store.Balances(scripts)
+	if err != nil {
+		sess.log.Error("Error querying balances", "err", err)
+		sess.queue(wsError("Internal server error"))
+		return
+	}
+
+	result := make([]WsBalance, 0, len(items))
+	sess.mtx.Lock()
+	for i, item := range items {
+		sess.subs[item.Script] = &wsSubscription{item: item, script: scripts[i], balance: balances[i]}
+		result = append(result, wsBalance(item, balances[i], nil))
+	}
+	if req.Tip {
+		sess.tip = true
+	}
+	sess.mtx.Unlock()
+
+	sess.queue(map[string]interface{}{"type": "subscribed", "balances": result})
+	if req.Tip {
+		sess.queue(hub.tipMessage(nil))
+	}
+}
+
+func (hub *wsHub) unsubscribe(sess *wsSession, req wsRequest) {
+	items, _, err := parseWsItems(req.Params)
+	if err != nil {
+		sess.queue(wsError(err.Error()))
+		return
+	}
+	sess.mtx.Lock()
+	for _, item := range items {
+		delete(sess.subs, item.Script)
+	}
+	if req.Tip {
+		sess.tip = false
+	}
+	sess.mtx.Unlock()
+	sess.queue(map[string]interface{}{"type": "unsubscribed"})
+}
+
+func wsBalance(item batchItem, b store.Balance, delta map[string]int64) WsBalance {
+	return WsBalance{
+		batchItem:   item,
+		Confirmed:   b.Confirmed,
+		Maturing:    b.Maturing,
+		Unconfirmed: b.Unconfirmed,
+		Delta:       delta,
+	}
+}
+
+func (hub *wsHub) tipMessage(e *events.Event) map[string]interface{} {
+	msg := map[string]interface{}{
+		"type":   "tip",
+		"height": hub.h.proc.TipHeight,
+	}
+	if e != nil {
+		msg["height"] = e.Height
+		msg["reorg"] = e.Type == events.TypeReorg
+	}
+	hash, err := hub.h.store.BlockHash(msg["height"].(int64))
+	if err == nil {
+		msg["hash"] = hash.String()
+	}
+	return msg
+}
+
+// run pushes updates for the events from the bus. Events that arrive while
+// an update is being sent are coalesced, since every update recomputes all
+// subscribed balances anyway.
+func (hub *wsHub) run(sub *events.Subscription) {
+	sub.Coalesce(func(batch []events.Event, dropped uint64) {
+		var tip *events.Event
+		for i := range batch {
+			if batch[i].ChangesTip() {
+				tip = &batch[i]
+			}
+		}
+		if dropped > 0 && tip == nil {
+			// Not knowing what was lost, announce the current tip
+			tip = &events.Event{Type: events.TypeBlock, Height: hub.h.proc.TipHeight}
+		}
+		hub.update(tip)
+	})
+}
+
+func (hub *wsHub) update(tip *events.Event) {
+	hub.sessionsMtx.Lock()
+	sessions := make([]*wsSession, 0, len(hub.sessions))
+	for sess := range hub.sessions {
+		sessions = append(sessions, sess)
+	}
+	hub.sessionsMtx.Unlock()
+
+	if tip != nil {
+		msg := hub.tipMessage(tip)
+		for _, sess := range sessions {
+			sess.mtx.Lock()
+			subscribed := sess.tip
+			sess.mtx.Unlock()
+			if subscribed {
+				sess.queue(msg)
+			}
+		}
+	}
+
+	// Look up every watched script once, however many clients watch it
+	scripts := make([][]byte, 0)
+	index := map[string]int{}
+	for _, sess := range sessions {
+		sess.mtx.Lock()
+		for key, s := range sess.subs {
+			if _, ok := index[key]; !ok {
+				index[key] = len(scripts)
+				scripts = append(scripts, s.script)
+			}
+		}
+		sess.mtx.Unlock()
+	}
+	if len(scripts) == 0 {
+		return
+	}
+	balances := make([]store.Balance, 0, len(scripts))
+	for i := 0; i < len(scripts); i += wsLookupSize {
+		end := i + wsLookupSize
+		if end > len(scripts) {
+			end = len(scripts)
+		}
+		chunk, err := hub.h.store.Balances(scripts[i:end])
+		if err != nil {
+			hub.h.log.Error("Error querying balances for websocket clients", "err", err)
+			return
+		}
+		balances = append(balances, chunk...)
+	}
+
+	for _, sess := range sessions {
+		changed := make([]WsBalance, 0)
+		sess.mtx.Lock()
+		for key, s := range sess.subs {
+			i, ok := index[key]
+			if !ok {
+				// Subscribed after we collected the scripts
+				continue
+			}
+			b := balances[i]
+			if b == s.balance {
+				continue
+			}
+			delta := map[string]int64{
+				"confirmed":   b.Confirmed - s.balance.Confirmed,
+				"maturing":    b.Maturing - s.balance.Maturing,
+				"unconfirmed": b.Unconfirmed - s.balance.Unconfirmed,
+			}
+			s.balance = b
+			changed = append(changed, wsBalance(s.item, b, delta))
+		}
+		sess.mtx.Unlock()
+		for _, c := range changed {
+			sess.queue(map[string]interface{}{"type": "balance", "balance": c})
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gorilla/websocket"
+)
+
+type wsMessage struct {
+	Type     string
+	Message  string
+	Height   int64
+	Balance  WsBalance
+	Balances []WsBalance
+}
+
+// dialWs connects a websocket client to the /ws endpoint of h
+func dialWs(t *testing.T, h *HttpServer) *websocket.Conn {
+	srv := httptest.NewServer(h.srv.Handler)
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readWs(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	err := conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestWsBalanceUpdates(t *testing.T) {
+	s := newFakeStore()
+	s.balances[testScript] = store.Balance{Confirmed: 100}
+	h := newTestServer(t, s, "")
+	conn := dialWs(t, h)
+
+	conn.WriteJSON(map[string]interface{}{"method": "subscribe", "params": map[string]interface{}{"addresses": []string{testAddress}}, "tip": true})
+	msg := readWs(t, conn)
+	if msg.Type != "subscribed" || len(msg.Balances) != 1 || msg.Balances[0].Address != testAddress || msg.Balances[0].Confirmed != 100 {
+		t.Fatalf("Subscribing returned %+v", msg)
+	}
+	if msg := readWs(t, conn); msg.Type != "tip" {
+		t.Fatalf("Got %+v, want the tip", msg)
+	}
+
+	s.balances[testScript] = store.Balance{Confirmed: 150, Unconfirmed: -20}
+	h.proc.Events.Publish(events.Event{Type: events.TypeBlock, Height: 7})
+	if msg := readWs(t, conn); msg.Type != "tip" || msg.Height != 7 {
+		t.Fatalf("Got %+v, want the tip at height 7", msg)
+	}
+	msg = readWs(t, conn)
+	if msg.Type != "balance" || msg.Balance.Script != testScript || msg.Balance.Confirmed != 150 {
+		t.Fatalf("Got %+v, want the new balance", msg)
+	}
+	if msg.Balance.Delta["confirmed"] != 50 || msg.Balance.Delta["unconfirmed"] != -20 || msg.Balance.Delta["maturing"] != 0 {
+		t.Errorf("Delta is %v, want 50 confirmed and -20 unconfirmed", msg.Balance.Delta)
+	}
+}
+
+func TestWsMaxSubscriptions(t *testing.T) {
+	h := newTestServer(t, newFakeStore(), "")
+	h.maxBatch = 2
+	conn := dialWs(t, h)
+
+	conn.WriteJSON(map[string]interface{}{"method": "subscribe", "params": map[string]interface{}{"scripts": []string{"51", "52"}}})
+	if msg := readWs(t, conn); msg.Type != "subscribed" || len(msg.Balances) != 2 {
+		t.Fatalf("Subscribing returned %+v", msg)
+	}
+	// Scripts that are already watched don't count twice
+	conn.WriteJSON(map[string]interface{}{"method": "subscribe", "params": map[string]interface{}{"scripts": []string{"52"}}})
+	if msg := readWs(t, conn); msg.Type != "subscribed" {
+		t.Fatalf("Subscribing again returned %+v", msg)
+	}
+	conn.WriteJSON(map[string]interface{}{"method": "subscribe", "params": map[string]interface{}{"scripts": []string{"53"}}})
+	if msg := readWs(t, conn); msg.Type != "error" || !strings.Contains(msg.Message, "at most 2") {
+		t.Errorf("Subscribing a third script returned %+v, want an error", msg)
+	}
+}
+
+func TestWsSlowClientDisconnected(t *testing.T) {
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	defer srv.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Without a write loop draining the queue, the client falls behind as
+	// soon as the queue is full
+	sess := &wsSession{
+		conn:   <-conns,
+		send:   make(chan interface{}, 2),
+		closed: make(chan struct{}),
+		log:    logging.Named("http"),
+	}
+	sess.queue(wsError("one"))
+	sess.queue(wsError("two"))
+	select {
+	case <-sess.closed:
+		t.Fatal("Client was disconnected before its queue was full")
+	default:
+	}
+	sess.queue(wsError("three"))
+	select {
+	case <-sess.closed:
+	default:
+		t.Fatal("Client was not disconnected when its queue overflowed")
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = client.ReadMessage()
+	if err == nil {
+		t.Error("Connection of the slow client is still open")
+	}
+}
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gertjaap/ocm-backend/vertcoin"
+	"github.com/gorilla/mux"
+)
+
+// xpubLookupSize is the number of scripts looked up in the store at once
+const xpubLookupSize = 500
+
+type XpubUtxo struct {
+	Utxo
+	Path   string `json:"path"`
+	Script string `json:"script"`
+}
+
+// derivedScript is a script of a wallet together with its derivation path
+// below the account key
+type derivedScript struct {
+	chain  uint32
+	index  uint32
+	script []byte
+}
+
+func (d derivedScript) path() string {
+	return fmt.Sprintf("%d/%d", d.chain, d.index)
+}
+
+// xpubHandler scans the receive (0) and change (1) chains of an extended
+// public key until it finds gap consecutive addresses that never received
+// anything, and returns the balance and utxos of the addresses before that.
+func (h *HttpServer) xpubHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	key, err := vertcoin.ParseExtendedKey(vars["key"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid extended public key: %v", err), 400)
+		return
+	}
+
+	gap := 20
+	gapStr := r.URL.Query().Get("gap")
+	if gapStr != "" {
+		gap, err = strconv.Atoi(gapStr)
+		if err != nil || gap < 1 || gap > 200 {
+			http.Error(w, "Invalid gap, must be between 1 and 200", 400)
+			return
+		}
+	}
+
+	used := make([]derivedScript, 0)
+	next := make([]uint32, 2)
+	for chain := uint32(0); chain < 2; chain++ {
+		scripts, nextIndex, err := h.scanChain(key, chain, uint32(gap))
+		if err != nil {
+			requestLog(r).Error("Error scanning extended key", "err", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		used = append(used, scripts...)
+		next[chain] = nextIndex
+	}
+
+	total := map[string]int64{"confirmed": 0, "maturing": 0, "unconfirmed": 0}
+	utxos := make([]XpubUtxo, 0)
+	for i := 0; i < len(used); i += xpubLookupSize {
+		end := i + xpubLookupSize
+		if end > len(used) {
+			end = len(used)
+		}
+		chunk := used[i:end]
+		scripts := make([][]byte, 0, len(chunk))
+		for _, d := range chunk {
+			scripts = append(scripts, d.script)
+		}
+
+		balances, err := h.store.Balances(scripts)
+		if err != nil {
+			requestLog(r).Error("Error querying balances", "err", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		for _, b := range balances {
+			total["confirmed"] += b.Confirmed
+			total["maturing"] += b.Maturing
+			total["unconfirmed"] += b.Unconfirmed
+		}
+
+		scriptUtxos, err := h.store.UtxosForScripts(scripts)
+		if err != nil {
+			requestLog(r).Error("Error querying utxos", "err", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		for j, us := range scriptUtxos {
+			for _, u := range us {
+				utxos = append(utxos, XpubUtxo{
+					Utxo: Utxo{
+						Vout:   u.Vout,
+						Amount: u.Value,
+						TxID:   u.TxHash.String(),
+					},
+					Path:   chunk[j].path(),
+					Script: hex.EncodeToString(chunk[j].script),
+				})
+			}
+		}
+	}
+
+	writeJson(w, map[string]interface{}{
+		"type":             key.ScriptType.String(),
+		"balance":          total,
+		"utxos":            utxos,
+		"nextReceiveIndex": next[0],
+		"nextChangeIndex":  next[1],
+	})
+}
+
+// scanChain derives the scripts of a chain in batches of gap until gap
+// scripts in a row are unused. It returns the used scripts and the index
+// following the last used one.
+func (h *HttpServer) scanChain(key *vertcoin.ExtendedKey, chain, gap uint32) ([]derivedScript, uint32, error) {
+	used := make([]derivedScript, 0)
+	next := uint32(0)
+	for index := uint32(0); index-next < gap; index += gap {
+		batch := make([]derivedScript, 0, gap)
+		scripts := make([][]byte, 0, gap)
+		for i := index; i < index+gap; i++ {
+			script, err := key.Script(chain, i)
+			if err != nil {
+				// Happens for about 1 in 2^127 indexes, BIP32 says to skip it
+				h.log.Warn("Unable to derive script", "chain", chain, "index", i, "err", err)
+				continue
+			}
+			batch = append(batch, derivedScript{chain: chain, index: i, script: script})
+			scripts = append(scripts, script)
+		}
+
+		known, err := h.store.KnownScripts(scripts)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i, d := range batch {
+			if known[i] {
+				used = append(used, d)
+				next = d.index + 1
+			}
+		}
+	}
+	return used, next, nil
+}
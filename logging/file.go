@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig describes the log file and when it is rotated. Rotated files
+// are named after the file with the time of rotation inserted, like
+// ocm-2021-02-24T23-05-26.000.log.
+type FileConfig struct {
+	Path string
+	// MaxSizeMB rotates the file when it grows beyond this size
+	MaxSizeMB int
+	// RotateEvery also rotates the file at this interval when set
+	RotateEvery time.Duration
+	// MaxAgeDays removes rotated files older than this, when set
+	MaxAgeDays int
+	// MaxBackups removes the oldest rotated files beyond this number, when
+	// set
+	MaxBackups int
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// LogFile is a log file that rotates itself
+type LogFile struct {
+	lj *lumberjack.Logger
+}
+
+// OpenLogFile starts writing the log to a file next to stdout. The file is
+// created on the first write.
+func OpenLogFile(cfg FileConfig) *LogFile {
+	f := &LogFile{lj: &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxAge:     cfg.MaxAgeDays,
+		MaxBackups: cfg.MaxBackups,
+		LocalTime:  true,
+		Compress:   cfg.Compress,
+	}}
+	SetLogFile(f.lj)
+
+	if cfg.RotateEvery > 0 {
+		go func() {
+			for range time.Tick(cfg.RotateEvery) {
+				err := f.lj.Rotate()
+				if err != nil {
+					Errorf("Unable to rotate log file: %v", err)
+				}
+			}
+		}()
+	}
+	return f
+}
+
+// Reopen closes the file, so the next line is written to a new file at the
+// same path. This lets an external logrotate move the file away.
+func (f *LogFile) Reopen() error {
+	outputMtx.Lock()
+	defer outputMtx.Unlock()
+	return f.lj.Close()
+}
+
+// ReopenOnSIGHUP reopens the file whenever the process receives SIGHUP
+func (f *LogFile) ReopenOnSIGHUP() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			err := f.Reopen()
+			if err != nil {
+				Errorf("Unable to reopen log file: %v", err)
+				continue
+			}
+			Infof("Reopened log file")
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFileReopen(t *testing.T) {
+	captureLog(t, FormatText)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ocm.log")
+	f := OpenLogFile(FileConfig{Path: path, MaxSizeMB: 1})
+	t.Cleanup(func() { f.lj.Close() })
+	// Leave stdout out of it
+	outputMtx.Lock()
+	output = f.lj
+	outputMtx.Unlock()
+
+	Infof("Before rotation")
+	// logrotate moves the file away and tells us to reopen it
+	rotated := filepath.Join(dir, "ocm.log.1")
+	err := os.Rename(path, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Infof("Still in the moved file")
+	err = f.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Infof("After rotation")
+
+	for file, want := range map[string][]string{
+		rotated: {"Before rotation", "Still in the moved file"},
+		path:    {"After rotation"},
+	} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		if len(lines) != len(want) {
+			t.Errorf("%s has %q, want %q", file, lines, want)
+			continue
+		}
+		for i, line := range lines {
+			if !strings.HasSuffix(line, "[INFO] "+want[i]) {
+				t.Errorf("%s line %d is %q, want %q", file, i, line, want[i])
+			}
+		}
+	}
+}
@@ -16,8 +16,9 @@ package logging
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
 type LogLevel int
@@ -29,110 +30,111 @@ const (
 	LogLevelDebug   LogLevel = 3
 )
 
-var logLevel = LogLevelError // the default
+var logLevel = int32(LogLevelError) // the default
 
 func SetLogLevel(newLevel int) {
-	logLevel = LogLevel(newLevel)
+	atomic.StoreInt32(&logLevel, int32(newLevel))
 }
 
-func SetLogFile(logFile io.Writer) {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
-	logOutput := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(logOutput)
+// GetLogLevel returns the global level
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
 }
 
-func getPrefix(level string) string {
-	return fmt.Sprintf("[%s]", level)
+func SetLogFile(logFile io.Writer) {
+	outputMtx.Lock()
+	defer outputMtx.Unlock()
+	timeFormat = "2006/01/02 15:04:05.000000"
+	output = io.MultiWriter(os.Stdout, logFile)
 }
 
 func Fatalln(args ...interface{}) {
-	log.Fatalln(args...)
+	write("FATAL", sprintln(args...), nil)
+	os.Exit(1)
 }
 
 func Fatalf(format string, args ...interface{}) {
-	log.Fatalf(format, args...)
+	write("FATAL", fmt.Sprintf(format, args...), nil)
+	os.Exit(1)
 }
 
 func Fatal(args ...interface{}) {
-	log.Fatal(args...)
+	write("FATAL", fmt.Sprint(args...), nil)
+	os.Exit(1)
 }
 
 func Debugf(format string, args ...interface{}) {
-	if logLevel >= LogLevelDebug {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("DEBUG"), format), args...)
+	if GetLogLevel() >= LogLevelDebug {
+		write(levelNames[LogLevelDebug], fmt.Sprintf(format, args...), nil)
 	}
 }
 
 func Infof(format string, args ...interface{}) {
-	if logLevel >= LogLevelInfo {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("INFO"), format), args...)
+	if GetLogLevel() >= LogLevelInfo {
+		write(levelNames[LogLevelInfo], fmt.Sprintf(format, args...), nil)
 	}
 }
 
 func Warnf(format string, args ...interface{}) {
-	if logLevel >= LogLevelWarning {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("WARN"), format), args...)
+	if GetLogLevel() >= LogLevelWarning {
+		write(levelNames[LogLevelWarning], fmt.Sprintf(format, args...), nil)
 	}
 }
 
 func Errorf(format string, args ...interface{}) {
-	if logLevel >= LogLevelError {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("ERROR"), format), args...)
+	if GetLogLevel() >= LogLevelError {
+		write(levelNames[LogLevelError], fmt.Sprintf(format, args...), nil)
 	}
 }
 
 func Debugln(args ...interface{}) {
-	if logLevel >= LogLevelDebug {
-		args = append([]interface{}{getPrefix("DEBUG")}, args...)
-		log.Println(args...)
+	if GetLogLevel() >= LogLevelDebug {
+		write(levelNames[LogLevelDebug], sprintln(args...), nil)
 	}
 }
 
 func Infoln(args ...interface{}) {
-	if logLevel >= LogLevelInfo {
-		args = append([]interface{}{getPrefix("INFO")}, args...)
-		log.Println(args...)
+	if GetLogLevel() >= LogLevelInfo {
+		write(levelNames[LogLevelInfo], sprintln(args...), nil)
 	}
 }
 
 func Warnln(args ...interface{}) {
-	if logLevel >= LogLevelWarning {
-		args = append([]interface{}{getPrefix("WARN")}, args...)
-		log.Println(args...)
+	if GetLogLevel() >= LogLevelWarning {
+		write(levelNames[LogLevelWarning], sprintln(args...), nil)
 	}
 }
 
 func Errorln(args ...interface{}) {
-	if logLevel >= LogLevelError {
-		args = append([]interface{}{getPrefix("ERROR")}, args...)
-		log.Println(args...)
+	if GetLogLevel() >= LogLevelError {
+		write(levelNames[LogLevelError], sprintln(args...), nil)
 	}
 }
 
 func Debug(args ...interface{}) {
-	if logLevel >= LogLevelDebug {
-		args = append([]interface{}{getPrefix("DEBUG")}, args...)
-		log.Print(args...)
+	if GetLogLevel() >= LogLevelDebug {
+		write(levelNames[LogLevelDebug], fmt.Sprint(args...), nil)
 	}
 }
 
 func Info(args ...interface{}) {
-	if logLevel >= LogLevelInfo {
-		args = append([]interface{}{getPrefix("INFO")}, args...)
-		log.Print(args...)
+	if GetLogLevel() >= LogLevelInfo {
+		write(levelNames[LogLevelInfo], fmt.Sprint(args...), nil)
 	}
 }
 
 func Warn(args ...interface{}) {
-	if logLevel >= LogLevelWarning {
-		args = append([]interface{}{getPrefix("WARN")}, args...)
-		log.Print(args...)
+	if GetLogLevel() >= LogLevelWarning {
+		write(levelNames[LogLevelWarning], fmt.Sprint(args...), nil)
 	}
 }
 
 func Error(args ...interface{}) {
-	if logLevel >= LogLevelError {
-		args = append([]interface{}{getPrefix("ERROR")}, args...)
-		log.Print(args...)
+	if GetLogLevel() >= LogLevelError {
+		write(levelNames[LogLevelError], fmt.Sprint(args...), nil)
 	}
 }
+
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// inheritLevel marks a subsystem without a level of its own
+const inheritLevel = -1
+
+var (
+	namedMtx sync.Mutex
+	named    = map[string]*int32{}
+)
+
+// Named returns the logger of a subsystem, such as "processor" or "http".
+// It adds the name as the subsystem field to every line, and logs at the
+// level set for the subsystem through SetNamedLevel, or at the global
+// level when there is none.
+func Named(name string) *Logger {
+	return &Logger{fields: []interface{}{"subsystem", name}, level: namedLevel(name)}
+}
+
+func namedLevel(name string) *int32 {
+	namedMtx.Lock()
+	defer namedMtx.Unlock()
+	lvl, ok := named[name]
+	if !ok {
+		lvl = new(int32)
+		*lvl = inheritLevel
+		named[name] = lvl
+	}
+	return lvl
+}
+
+// SetNamedLevel sets the level of a subsystem. It can be called before the
+// subsystem creates its logger, and takes effect immediately afterwards.
+func SetNamedLevel(name string, level LogLevel) {
+	atomic.StoreInt32(namedLevel(name), int32(level))
+}
+
+// ResetNamedLevel makes a subsystem log at the global level again
+func ResetNamedLevel(name string) {
+	atomic.StoreInt32(namedLevel(name), inheritLevel)
+}
+
+// SubsystemLevel is the level a subsystem logs at. Inherited is true when
+// it has no level of its own and follows the global level.
+type SubsystemLevel struct {
+	Level     LogLevel
+	Inherited bool
+}
+
+// NamedLevels returns the level of every subsystem that has a logger or a
+// level
+func NamedLevels() map[string]SubsystemLevel {
+	namedMtx.Lock()
+	defer namedMtx.Unlock()
+	result := map[string]SubsystemLevel{}
+	for name, lvl := range named {
+		result[name] = SubsystemLevel{
+			Level:     (&Logger{level: lvl}).Level(),
+			Inherited: atomic.LoadInt32(lvl) == inheritLevel,
+		}
+	}
+	return result
+}
+
+// SetLevels applies a level specification like "info,http=debug,db=warn":
+// an entry without a name sets the global level, the others set the level
+// of a subsystem.
+func SetLevels(spec string) error {
+	global := LogLevel(inheritLevel)
+	levels := map[string]LogLevel{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 1 {
+			lvl, err := ParseLevel(parts[0])
+			if err != nil {
+				return err
+			}
+			global = lvl
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if name == "" {
+			return fmt.Errorf("Missing subsystem name in %s", entry)
+		}
+		lvl, err := ParseLevel(parts[1])
+		if err != nil {
+			return err
+		}
+		levels[name] = lvl
+	}
+
+	if global != inheritLevel {
+		SetLogLevel(int(global))
+	}
+	for name, lvl := range levels {
+		SetNamedLevel(name, lvl)
+	}
+	return nil
+}
+
+var levelByName = map[string]LogLevel{
+	"error":   LogLevelError,
+	"warn":    LogLevelWarning,
+	"warning": LogLevelWarning,
+	"info":    LogLevelInfo,
+	"debug":   LogLevelDebug,
+}
+
+// ParseLevel parses error, warn, info or debug
+func ParseLevel(s string) (LogLevel, error) {
+	lvl, ok := levelByName[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return LogLevelError, fmt.Errorf("Unknown log level %s", s)
+	}
+	return lvl, nil
+}
+
+func (l LogLevel) String() string {
+	if name, ok := levelNames[l]; ok {
+		return strings.ToLower(name)
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
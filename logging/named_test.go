@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+// resetNamed gives the subsystems their global level again when the test
+// ends
+func resetNamed(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		for _, name := range names {
+			ResetNamedLevel(name)
+		}
+	})
+}
+
+func TestSetLevels(t *testing.T) {
+	captureLog(t, FormatText)
+	resetNamed(t, "levels.http", "levels.db", "levels.rpc")
+	// A logger created before its level is set follows the change
+	httpLog := Named("levels.http")
+
+	err := SetLevels(" warn , levels.http=debug,levels.db = ERROR,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetLogLevel() != LogLevelWarning {
+		t.Errorf("Global level is %s, want warn", GetLogLevel())
+	}
+	levels := NamedLevels()
+	for name, want := range map[string]SubsystemLevel{
+		"levels.http": {LogLevelDebug, false},
+		"levels.db":   {LogLevelError, false},
+	} {
+		if levels[name] != want {
+			t.Errorf("Level of %s is %+v, want %+v", name, levels[name], want)
+		}
+	}
+	if !httpLog.Enabled(LogLevelDebug) {
+		t.Error("Logger created before SetLevels doesn't log at debug")
+	}
+	rpcLog := Named("levels.rpc")
+	if rpcLog.Level() != LogLevelWarning || !NamedLevels()["levels.rpc"].Inherited {
+		t.Errorf("Subsystem without a level is at %s, want the global warn", rpcLog.Level())
+	}
+
+	// Subsystem levels don't change the global level
+	err = SetLevels("levels.rpc=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetLogLevel() != LogLevelWarning || rpcLog.Level() != LogLevelInfo {
+		t.Errorf("Global level is %s and rpc %s, want warn and info", GetLogLevel(), rpcLog.Level())
+	}
+
+	ResetNamedLevel("levels.rpc")
+	if rpcLog.Level() != LogLevelWarning {
+		t.Errorf("Reset subsystem is at %s, want the global warn", rpcLog.Level())
+	}
+}
+
+func TestSetLevelsInvalid(t *testing.T) {
+	captureLog(t, FormatText)
+	resetNamed(t, "invalid.http")
+	Named("invalid.http")
+
+	for _, c := range []struct {
+		spec string
+		err  string
+	}{
+		{"loud", "Unknown log level loud"},
+		{"debug,invalid.http=loud", "Unknown log level loud"},
+		{"=debug", "Missing subsystem name"},
+		{"debug, =info", "Missing subsystem name"},
+	} {
+		err := SetLevels(c.spec)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: got %v, want %q", c.spec, err, c.err)
+		}
+		// Nothing is applied when part of the specification is invalid
+		if GetLogLevel() != LogLevelInfo {
+			t.Errorf("%q: global level changed to %s", c.spec, GetLogLevel())
+		}
+		if !NamedLevels()["invalid.http"].Inherited {
+			t.Errorf("%q: level of invalid.http changed", c.spec)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]LogLevel{
+		"error":   LogLevelError,
+		"WARN":    LogLevelWarning,
+		"warning": LogLevelWarning,
+		" Info ":  LogLevelInfo,
+		"debug":   LogLevelDebug,
+	} {
+		lvl, err := ParseLevel(s)
+		if err != nil || lvl != want {
+			t.Errorf("%q parsed as %s (%v), want %s", s, lvl, err, want)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Error("trace was accepted")
+	}
+}
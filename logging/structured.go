@@ -0,0 +1,233 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Format is how log lines are written
+type Format int
+
+const (
+	// FormatText writes lines like
+	//   2021/02/24 23:05:26 [INFO] Processed block height=1300000 txs=12
+	FormatText Format = 0
+	// FormatJSON writes one JSON object per line, with the time, level and
+	// message under "time", "level" and "msg" next to the fields
+	FormatJSON Format = 1
+)
+
+var (
+	outputMtx  sync.Mutex
+	output     io.Writer = os.Stderr
+	format               = FormatText
+	timeFormat           = "2006/01/02 15:04:05"
+)
+
+// ParseFormat parses "text" or "json"
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatText, fmt.Errorf("Unknown log format %s", s)
+}
+
+func SetFormat(f Format) {
+	outputMtx.Lock()
+	format = f
+	outputMtx.Unlock()
+}
+
+// Logger writes log lines with key/value fields. The fields given to With
+// are added to every line, so a logger can carry the context of a
+// subsystem, request or block. The zero value has no fields and logs at
+// the global level.
+type Logger struct {
+	fields []interface{}
+	// level is shared by all loggers derived from a named logger, so
+	// changing the level of a subsystem applies everywhere at once
+	level *int32
+}
+
+var root = &Logger{}
+
+// With returns a logger that adds the given key/value pairs to every line
+func With(kv ...interface{}) *Logger {
+	return root.With(kv...)
+}
+
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{fields: fields, level: l.level}
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LogLevelDebug, msg, kv)
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LogLevelInfo, msg, kv)
+}
+
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LogLevelWarning, msg, kv)
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LogLevelError, msg, kv)
+}
+
+// Enabled returns whether lines at level are written, to skip building
+// expensive fields
+func (l *Logger) Enabled(level LogLevel) bool {
+	return l.Level() >= level
+}
+
+// Level returns the level of the logger: the level of its subsystem, or
+// the global level when the subsystem has none
+func (l *Logger) Level() LogLevel {
+	if l.level != nil {
+		if lvl := atomic.LoadInt32(l.level); lvl != inheritLevel {
+			return LogLevel(lvl)
+		}
+	}
+	return GetLogLevel()
+}
+
+func (l *Logger) log(level LogLevel, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := l.fields
+	if len(kv) > 0 {
+		fields = make([]interface{}, 0, len(l.fields)+len(kv))
+		fields = append(fields, l.fields...)
+		fields = append(fields, kv...)
+	}
+	write(levelNames[level], msg, fields)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries l
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or a logger without
+// fields when there is none
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return root
+}
+
+var levelNames = map[LogLevel]string{
+	LogLevelError:   "ERROR",
+	LogLevelWarning: "WARN",
+	LogLevelInfo:    "INFO",
+	LogLevelDebug:   "DEBUG",
+}
+
+// write formats a line and writes it to the output. The printf style
+// functions go through here as well, so all lines share one format.
+func write(level string, msg string, fields []interface{}) {
+	now := time.Now()
+	var buf bytes.Buffer
+
+	outputMtx.Lock()
+	defer outputMtx.Unlock()
+
+	if format == FormatJSON {
+		buf.WriteString(`{"time":`)
+		writeJSONValue(&buf, now.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSONValue(&buf, strings.ToLower(level))
+		buf.WriteString(`,"msg":`)
+		writeJSONValue(&buf, msg)
+		eachField(fields, func(k string, v interface{}) {
+			buf.WriteByte(',')
+			writeJSONValue(&buf, k)
+			buf.WriteByte(':')
+			writeJSONValue(&buf, v)
+		})
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString(now.Format(timeFormat))
+		buf.WriteString(" [")
+		buf.WriteString(level)
+		buf.WriteString("] ")
+		buf.WriteString(msg)
+		eachField(fields, func(k string, v interface{}) {
+			buf.WriteByte(' ')
+			buf.WriteString(k)
+			buf.WriteByte('=')
+			buf.WriteString(textValue(v))
+		})
+		if buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	output.Write(buf.Bytes())
+}
+
+// eachField calls fn for the key/value pairs in fields. A value without a
+// key is reported under "!BADKEY".
+func eachField(fields []interface{}, fn func(k string, v interface{})) {
+	for i := 0; i < len(fields); i += 2 {
+		k, ok := fields[i].(string)
+		if !ok || i+1 == len(fields) {
+			fn("!BADKEY", fields[i])
+			i--
+			continue
+		}
+		fn(k, fields[i+1])
+	}
+}
+
+// plainValue turns errors and Stringers such as hashes into their text,
+// and leaves everything else as is
+func plainValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(plainValue(v))
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(plainValue(v))
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
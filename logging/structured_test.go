@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog makes the log go to a buffer in format f at the info level,
+// until the test ends
+func captureLog(t *testing.T, f Format) *bytes.Buffer {
+	var buf bytes.Buffer
+	outputMtx.Lock()
+	oldOutput, oldFormat, oldTimeFormat := output, format, timeFormat
+	output, format = &buf, f
+	outputMtx.Unlock()
+	oldLevel := GetLogLevel()
+	SetLogLevel(int(LogLevelInfo))
+
+	t.Cleanup(func() {
+		outputMtx.Lock()
+		output, format, timeFormat = oldOutput, oldFormat, oldTimeFormat
+		outputMtx.Unlock()
+		SetLogLevel(int(oldLevel))
+	})
+	return &buf
+}
+
+type testHash string
+
+func (h testHash) String() string { return "hash:" + string(h) }
+
+func TestTextFields(t *testing.T) {
+	buf := captureLog(t, FormatText)
+	l := With("subsystem", "test").With("height", 5)
+	l.Info("Processed block", "txs", 12, "hash", testHash("ab"), "err", errors.New("bad thing"), "empty", "", "took", 1500*time.Millisecond, "odd")
+
+	line := regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d \[INFO\] (.*)\n$`).FindStringSubmatch(buf.String())
+	if line == nil {
+		t.Fatalf("Line %q is not in the text format", buf.String())
+	}
+	want := `Processed block subsystem=test height=5 txs=12 hash=hash:ab err="bad thing" empty="" took=1.5s !BADKEY=odd`
+	if line[1] != want {
+		t.Errorf("Line is %q, want %q", line[1], want)
+	}
+
+	// Values that would be ambiguous are quoted
+	buf.Reset()
+	l.Warn("Odd values", "eq", "a=b", "quote", `say "hi"`, "newline", "a\nb")
+	if !strings.HasSuffix(buf.String(), `[WARN] Odd values subsystem=test height=5 eq="a=b" quote="say \"hi\"" newline="a\nb"`+"\n") {
+		t.Errorf("Line is %q", buf.String())
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	buf := captureLog(t, FormatJSON)
+	With("subsystem", "test").Warn(`Block "x" failed`, "height", 5, "hash", testHash("ab"), "err", errors.New("bad thing"), "took", 1500*time.Millisecond, "ok", true, "ch", make(chan int), 7)
+
+	if strings.Count(buf.String(), "\n") != 1 || !strings.HasSuffix(buf.String(), "}\n") {
+		t.Fatalf("Output %q is not one line", buf.String())
+	}
+	var line map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("Line %q is not JSON: %v", buf.String(), err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, line["time"].(string)); err != nil {
+		t.Errorf("Time %v is not RFC 3339: %v", line["time"], err)
+	}
+	for k, want := range map[string]interface{}{
+		"level":     "warn",
+		"msg":       `Block "x" failed`,
+		"subsystem": "test",
+		"height":    float64(5),
+		"hash":      "hash:ab",
+		"err":       "bad thing",
+		"took":      "1.5s",
+		"ok":        true,
+		"!BADKEY":   float64(7),
+	} {
+		if line[k] != want {
+			t.Errorf("%s is %#v, want %#v", k, line[k], want)
+		}
+	}
+	// Values JSON can't encode are written as text
+	if s, ok := line["ch"].(string); !ok || !strings.HasPrefix(s, "0x") {
+		t.Errorf("ch is %#v, want the text of the channel", line["ch"])
+	}
+}
+
+func TestLevelFiltersLines(t *testing.T) {
+	buf := captureLog(t, FormatText)
+	Debugf("Not written")
+	With().Debug("Not written either")
+	Infof("Block %d", 5)
+	if strings.Contains(buf.String(), "Not written") || !strings.Contains(buf.String(), "[INFO] Block 5\n") {
+		t.Errorf("Output is %q, want only the info line", buf.String())
+	}
+}
@@ -1,52 +1,352 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/electrum"
 	"github.com/gertjaap/ocm-backend/http"
 	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/mempool"
 	"github.com/gertjaap/ocm-backend/processor"
-	_ "github.com/lib/pq"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/webhook"
+	"github.com/gertjaap/ocm-backend/zmq"
 )
 
+var (
+	mainLog = logging.Named("main")
+	zmqLog  = logging.Named("zmq")
+)
+
+// command is a subcommand. setup registers the flags of the command and
+// returns the function that runs it once the configuration is loaded. rpc
+// tells whether it connects to vertcoind.
+type command struct {
+	name  string
+	usage string
+	rpc   bool
+	setup func(fs *flag.FlagSet) func(cfg *config.Config) error
+}
+
+var commands = []command{
+	{"all", "Index the blockchain and serve the API (the default)", true, func(fs *flag.FlagSet) func(cfg *config.Config) error { return runAll }},
+	{"serve", "Serve the API from a database indexed by another process", true, func(fs *flag.FlagSet) func(cfg *config.Config) error { return runServe }},
+	{"index", "Index the blockchain without serving the API, only /health and /metrics", true, func(fs *flag.FlagSet) func(cfg *config.Config) error { return runIndex }},
+	{"migrate", "Create or upgrade the database schema and exit, which every other command requires first", false, func(fs *flag.FlagSet) func(cfg *config.Config) error { return runMigrate }},
+	{"reindex", "Revert the blocks from -from on, index them again up to the tip of vertcoind and exit", true, setupReindex},
+	{"verify", "Check the consistency of the database and exit, with status 1 when problems were found", true, setupVerify},
+}
+
 func main() {
-	if os.Getenv("DEBUG") == "1" {
-		logging.SetLogLevel(int(logging.LogLevelDebug))
-	} else {
-		logging.SetLogLevel(int(logging.LogLevelInfo))
+	name := "all"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	} else if os.Getenv("OCM_BACKEND_APIONLY") == "1" {
+		// Running all instead would start a second indexer on the database
+		fmt.Fprintln(os.Stderr, "OCM_BACKEND_APIONLY was replaced by the serve command")
+		os.Exit(2)
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		printUsage()
+		fmt.Fprintf(os.Stderr, "\nFlags of %s:\n", name)
+		fs.PrintDefaults()
+	}
+	printConfig := fs.Bool("print-config", false, "Print the effective configuration and exit")
+	run := cmd.setup(fs)
+	cfg, err := config.Load(fs, args)
+	if err == nil && cmd.rpc {
+		err = cfg.RequireRPC()
 	}
-	rpc, err := initRPC()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		cfg.Print(os.Stdout)
+		return
 	}
 
-	connStr := os.Getenv("PGSQL_CONNECTION")
-	db, err := sql.Open("postgres", connStr)
+	err = initLogging(cfg.Log)
 	if err != nil {
 		panic(err)
 	}
+	for _, l := range cfg.Lines() {
+		mainLog.Info("Config", "setting", l)
+	}
 
-	p, err := processor.NewProcessor(rpc, db)
+	err = run(cfg)
 	if err != nil {
-		panic(err)
+		mainLog.Error("Command failed", "command", name, "err", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+// backend holds what every command that talks to vertcoind needs
+type backend struct {
+	rpc   *rpcclient.Client
+	store store.Store
+	proc  *processor.Processor
+}
+
+func openBackend(cfg *config.Config) (*backend, error) {
+	rpc, err := initRPC(cfg.RPC)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := store.Open(cfg.Database.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := processor.NewProcessor(rpc, s, cfg.Indexer)
+	if err != nil {
+		return nil, err
+	}
+	return &backend{rpc: rpc, store: s, proc: p}, nil
+}
+
+func runAll(cfg *config.Config) error {
+	b, err := openBackend(cfg)
+	if err != nil {
+		return err
+	}
+
+	tracker := b.startIndexer(cfg)
+	b.startZMQ(cfg.ZMQ, tracker)
+	h, err := b.startAPI(cfg)
+	if err != nil {
+		return err
+	}
+
+	go b.proc.ProcessLoop()
+	return h.Run()
+}
+
+func runServe(cfg *config.Config) error {
+	b, err := openBackend(cfg)
+	if err != nil {
+		return err
 	}
 
-	h := http.NewHttpServer(rpc, db, p)
+	// The tip comes from the database, so there's nothing to learn from
+	// ZMQ notifications
+	h, err := b.startAPI(cfg)
+	if err != nil {
+		return err
+	}
+
+	go b.proc.FollowLoop()
+	return h.Run()
+}
+
+func runIndex(cfg *config.Config) error {
+	b, err := openBackend(cfg)
+	if err != nil {
+		return err
+	}
+
+	tracker := b.startIndexer(cfg)
+	b.startZMQ(cfg.ZMQ, tracker)
+	go func() {
+		err := http.NewMonitoringServer(b.proc, cfg.HTTP).Run()
+		if err != nil {
+			mainLog.Error("Monitoring server stopped", "err", err)
+		}
+	}()
+
+	b.proc.ProcessLoop()
+	return nil
+}
+
+func runMigrate(cfg *config.Config) error {
+	err := store.Migrate(cfg.Database.Connection)
+	if err != nil {
+		return err
+	}
+	mainLog.Info("Database schema is up to date")
+	return nil
+}
+
+func setupReindex(fs *flag.FlagSet) func(cfg *config.Config) error {
+	from := fs.Int64("from", -1, "Height of the first block to index again")
+	return func(cfg *config.Config) error {
+		if *from < 0 {
+			return errors.New("Missing -from")
+		}
+		b, err := openBackend(cfg)
+		if err != nil {
+			return err
+		}
+		defer b.store.Close()
+
+		_, err = b.proc.RevertFrom(*from)
+		if err != nil {
+			return err
+		}
+		b.proc.CatchUp()
+		mainLog.Info("Reindexed blocks", "from", *from, "to", b.proc.TipHeight)
+		return nil
+	}
+}
+
+func setupVerify(fs *flag.FlagSet) func(cfg *config.Config) error {
+	depth := fs.Int64("depth", 100, "Number of blocks below the tip to compare with vertcoind")
+	return func(cfg *config.Config) error {
+		b, err := openBackend(cfg)
+		if err != nil {
+			return err
+		}
+		defer b.store.Close()
+
+		problems, err := b.store.Verify()
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		chainProblems, err := b.proc.VerifyChain(*depth)
+		if err != nil {
+			return err
+		}
+		for _, p := range chainProblems {
+			fmt.Println(p)
+		}
+
+		problems = append(problems, chainProblems...)
+		if len(problems) > 0 {
+			return fmt.Errorf("Found %d problems", len(problems))
+		}
+		mainLog.Info("No problems found")
+		return nil
+	}
+}
 
-	go p.ProcessLoop()
-	h.Run()
+// startIndexer starts what runs next to the indexer: the mempool janitor
+// and tracker and the webhook dispatcher. It returns the tracker, or nil
+// when the mempool isn't followed.
+func (b *backend) startIndexer(cfg *config.Config) *mempool.Tracker {
+	janitor := mempool.NewJanitor(b.rpc, b.store, cfg.Mempool.UnconfirmedMaxAge)
+	janitor.Events = b.proc.Events
+	go janitor.Run()
+
+	var tracker *mempool.Tracker
+	if cfg.Mempool.Enabled {
+		tracker = mempool.NewTracker(b.rpc, b.store, cfg.Mempool.Interval)
+		tracker.Events = b.proc.Events
+		go tracker.Run()
+	}
+
+	// Subscribe before the processor starts, so no block is missed
+	go webhook.NewDispatcher(b.store, cfg.Webhooks).Run(b.proc.Events.Subscribe(1000))
+	return tracker
+}
+
+func (b *backend) startZMQ(cfg config.ZMQConfig, tracker *mempool.Tracker) {
+	if cfg.Endpoint == "" {
+		return
+	}
+	p := b.proc
+	sub := zmq.NewSubscriber(cfg.Endpoint)
+	sub.Handle(zmq.TopicHashBlock, func(n zmq.Notification) {
+		p.NotifyBlock()
+	})
+	sub.Handle(zmq.TopicRawBlock, func(n zmq.Notification) {
+		p.NotifyBlock()
+	})
+	sub.Handle(zmq.TopicRawTx, func(n zmq.Notification) {
+		zmqLog.Debug("Received raw transaction", "bytes", len(n.Body), "seq", n.Sequence)
+		if tracker != nil {
+			tracker.AddRawTransaction(n.Body)
+		}
+	})
+	p.UseNotifications()
+	go sub.Run()
+}
+
+// startAPI starts the Electrum server when it is enabled, and returns the
+// HTTP server for the caller to run
+func (b *backend) startAPI(cfg *config.Config) (*http.HttpServer, error) {
+	if cfg.Electrum.Listen != "" {
+		e := electrum.NewServer(b.rpc, b.store, b.proc, cfg.Electrum.Listen)
+		go func() {
+			err := e.Run()
+			if err != nil {
+				mainLog.Error("Electrum server stopped", "err", err)
+			}
+		}()
+	}
+
+	return http.NewHttpServer(b.rpc, b.store, b.proc, cfg.HTTP)
+}
+
+func initLogging(cfg config.LogConfig) error {
+	if cfg.Debug {
+		logging.SetLogLevel(int(logging.LogLevelDebug))
+	} else {
+		logging.SetLogLevel(int(logging.LogLevelInfo))
+	}
+	err := logging.SetLevels(cfg.Levels)
+	if err != nil {
+		return err
+	}
+	logFormat, err := logging.ParseFormat(cfg.Format)
+	if err != nil {
+		return err
+	}
+	logging.SetFormat(logFormat)
+
+	if cfg.File != "" {
+		logging.OpenLogFile(logging.FileConfig{
+			Path:        cfg.File,
+			MaxSizeMB:   cfg.MaxSizeMB,
+			RotateEvery: cfg.Rotate,
+			MaxAgeDays:  cfg.MaxAgeDays,
+			MaxBackups:  cfg.MaxFiles,
+			Compress:    cfg.Compress,
+		}).ReopenOnSIGHUP()
+	}
+	return nil
 }
 
-func initRPC() (*rpcclient.Client, error) {
+func initRPC(cfg config.RPCConfig) (*rpcclient.Client, error) {
 	connCfg := &rpcclient.ConnConfig{
-		Host:         os.Getenv("RPCHOST"),
-		User:         os.Getenv("RPCUSER"),
-		Pass:         os.Getenv("RPCPASS"),
+		Host:         cfg.Host,
+		User:         cfg.User,
+		Pass:         cfg.Password,
 		HTTPPostMode: true,
 		DisableTLS:   true,
 	}
-	logging.Debugf("RPC Server: %s", connCfg.Host)
+	logging.Named("rpc").Debug("Connecting to RPC server", "host", connCfg.Host)
 	return rpcclient.New(connCfg, nil)
 }
@@ -0,0 +1,112 @@
+package mempool
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// Janitor rolls back unconfirmed transactions, such as the ones broadcast
+// through the API, that vertcoind no longer knows about because they were
+// evicted, double spent or expired. Otherwise the outputs they spend would
+// stay spent forever.
+type Janitor struct {
+	// Events, when set, gets a TypeMempool event whenever transactions
+	// were rolled back
+	Events *events.Bus
+
+	rpc    *rpcclient.Client
+	store  store.Store
+	maxAge time.Duration
+}
+
+func NewJanitor(rpc *rpcclient.Client, s store.Store, maxAge time.Duration) *Janitor {
+	return &Janitor{rpc: rpc, store: s, maxAge: maxAge}
+}
+
+func (j *Janitor) Run() {
+	for {
+		err := j.cleanup()
+		if err != nil {
+			mempoolLog.Warn("Unable to clean up stale transactions", "err", err)
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+func (j *Janitor) cleanup() error {
+	candidates, err := j.store.UnconfirmedTransactionsReceivedBefore(time.Now().Add(-j.maxAge))
+	if err != nil {
+		return err
+	}
+
+	stale := make([]*chainhash.Hash, 0)
+	for _, h := range candidates {
+		gone, err := j.isGone(h)
+		if err != nil {
+			mempoolLog.Warn("Unable to check status of transaction", "txid", h, "err", err)
+			continue
+		}
+		if gone {
+			stale = append(stale, h)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	// Without -txindex vertcoind doesn't find confirmed transactions, so
+	// one mined in a block that isn't indexed yet looks gone too. Once the
+	// indexer has caught up it is attached to its block, and removing it
+	// leaves it alone.
+	caughtUp, err := indexerCaughtUp(j.rpc, j.store)
+	if err != nil {
+		return err
+	}
+	if !caughtUp {
+		mempoolLog.Debug("Not rolling back transactions until the indexer has caught up", "count", len(stale))
+		return nil
+	}
+
+	err = j.store.RemoveUnconfirmedTransactions(stale)
+	if err != nil {
+		return err
+	}
+	publishMempool(j.Events, j.store, 0, len(stale))
+	for _, h := range stale {
+		mempoolLog.Info("Rolled back transaction, not confirmed in time and no longer known by vertcoind", "txid", h, "maxAge", j.maxAge.String())
+	}
+	return nil
+}
+
+// isGone returns true when vertcoind has the transaction neither in its
+// mempool nor in a block. Finding it in a block takes -txindex; without it
+// isGone also returns true for confirmed transactions, which cleanup
+// handles by waiting for the indexer.
+func (j *Janitor) isGone(h *chainhash.Hash) (bool, error) {
+	_, err := j.rpc.GetMempoolEntry(h.String())
+	if err == nil {
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, err
+	}
+
+	_, err = j.rpc.GetRawTransactionVerbose(h)
+	if err == nil {
+		// Confirmed, the processor will attach it to its block
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func isNotFound(err error) bool {
+	rpcErr, ok := err.(*btcjson.RPCError)
+	return ok && rpcErr.Code == btcjson.ErrRPCNoTxInfo
+}
@@ -0,0 +1,77 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJanitorWaitsForIndexer(t *testing.T) {
+	n, s := newTestNode(t)
+	// Every unconfirmed transaction is old enough to be checked
+	janitor := NewJanitor(n.Client(), s, -time.Minute)
+
+	tx := spend(n.Block(0).Transactions[0], 0, 25e8)
+	err := s.AddUnconfirmedTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without -txindex, vertcoind doesn't know the mined transaction
+	n.Mine(tx)
+	err = janitor.cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unconfirmed(t, s)[tx.TxHash()] {
+		t.Fatal("Mined transaction was rolled back before its block was indexed")
+	}
+
+	err = s.InsertBlock(1, n.Block(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = janitor.cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Balance(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Confirmed != 25e8 {
+		t.Errorf("Balance is %+v, want 25 coins confirmed", b)
+	}
+}
+
+func TestJanitorRollsBackUnknownTransactions(t *testing.T) {
+	n, s := newTestNode(t)
+	janitor := NewJanitor(n.Client(), s, -time.Minute)
+
+	kept := spend(n.Block(0).Transactions[0], 0, 25e8)
+	n.AddToMempool(kept)
+	err := s.AddUnconfirmedTransaction(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone := spend(kept, 0, 20e8)
+	err = s.AddUnconfirmedTransaction(gone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = janitor.cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := unconfirmed(t, s)
+	if !txs[kept.TxHash()] || txs[gone.TxHash()] {
+		t.Errorf("Unconfirmed transactions are %v, want only %s", txs, kept.TxHash())
+	}
+	b, err := s.Balance(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Unconfirmed != 25e8 {
+		t.Errorf("Balance is %+v, want the 25 unconfirmed coins of the kept transaction", b)
+	}
+}
@@ -0,0 +1,178 @@
+package mempool
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+var mempoolLog = logging.Named("mempool")
+
+// Tracker follows the mempool of vertcoind and records its transactions
+// as unconfirmed in the store, so their outputs and spends show up in
+// balances before they are confirmed. Transactions that leave the mempool
+// without being confirmed are removed again, once the indexer has caught up
+// with vertcoind.
+type Tracker struct {
+	// Events, when set, gets a TypeMempool event whenever unconfirmed
+	// transactions were added or removed
+	Events *events.Bus
+
+	rpc      *rpcclient.Client
+	store    store.Store
+	interval time.Duration
+}
+
+func NewTracker(rpc *rpcclient.Client, s store.Store, interval time.Duration) *Tracker {
+	return &Tracker{rpc: rpc, store: s, interval: interval}
+}
+
+func (t *Tracker) Run() {
+	for {
+		err := t.sync()
+		if err != nil {
+			mempoolLog.Warn("Unable to synchronize mempool", "err", err)
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+// AddRawTransaction records a serialized transaction, as published by
+// vertcoind over ZMQ, as unconfirmed.
+func (t *Tracker) AddRawTransaction(b []byte) {
+	tx := wire.NewMsgTx(2)
+	err := tx.Deserialize(bytes.NewReader(b))
+	if err != nil {
+		mempoolLog.Warn("Received invalid transaction", "err", err)
+		return
+	}
+	err = t.store.AddUnconfirmedTransaction(tx)
+	if err != nil {
+		mempoolLog.Warn("Unable to add mempool transaction", "txid", tx.TxHash(), "err", err)
+		return
+	}
+	publishMempool(t.Events, t.store, 1, 0)
+}
+
+func (t *Tracker) sync() error {
+	start := time.Now()
+	// Query what we know before asking vertcoind, so transactions added
+	// over ZMQ in between aren't mistaken for evicted ones
+	known, err := t.store.UnconfirmedTransactions()
+	if err != nil {
+		return err
+	}
+	mempool, err := t.rpc.GetRawMempool()
+	if err != nil {
+		return err
+	}
+
+	inMempool := map[chainhash.Hash]bool{}
+	for _, h := range mempool {
+		inMempool[*h] = true
+	}
+	isKnown := map[chainhash.Hash]bool{}
+	for _, h := range known {
+		isKnown[*h] = true
+	}
+
+	// Transactions can spend outputs of other mempool transactions, so
+	// fetch all new ones first and add parents before their children
+	added := map[chainhash.Hash]*wire.MsgTx{}
+	for _, h := range mempool {
+		if isKnown[*h] {
+			continue
+		}
+		tx, err := t.rpc.GetRawTransaction(h)
+		if err != nil {
+			// Most likely confirmed or evicted since we asked for the mempool
+			mempoolLog.Debug("Unable to get mempool transaction", "txid", h, "err", err)
+			continue
+		}
+		added[*h] = tx.MsgTx()
+	}
+	done := map[chainhash.Hash]bool{}
+	for h := range added {
+		t.addWithParents(h, added, done)
+	}
+
+	// A transaction that left the mempool may have been mined in a block
+	// that isn't indexed yet. Only evict once the indexer has caught up,
+	// by then the mined ones are attached to their block and stay.
+	caughtUp, err := indexerCaughtUp(t.rpc, t.store)
+	if err != nil {
+		return err
+	}
+	evicted := make([]*chainhash.Hash, 0)
+	for _, h := range known {
+		if !inMempool[*h] && caughtUp {
+			evicted = append(evicted, h)
+		}
+	}
+	if len(evicted) > 0 {
+		err = t.store.RemoveUnconfirmedTransactions(evicted)
+		if err != nil {
+			return err
+		}
+	}
+
+	publishMempool(t.Events, t.store, len(added), len(evicted))
+	mempoolLog.Debug("Mempool synchronized", "durationMs", time.Since(start).Milliseconds(), "txs", len(mempool), "added", len(added), "removed", len(evicted))
+	return nil
+}
+
+func (t *Tracker) addWithParents(h chainhash.Hash, added map[chainhash.Hash]*wire.MsgTx, done map[chainhash.Hash]bool) {
+	if done[h] {
+		return
+	}
+	done[h] = true
+	tx := added[h]
+	for _, in := range tx.TxIn {
+		if _, ok := added[in.PreviousOutPoint.Hash]; ok {
+			t.addWithParents(in.PreviousOutPoint.Hash, added, done)
+		}
+	}
+	err := t.store.AddUnconfirmedTransaction(tx)
+	if err != nil {
+		mempoolLog.Warn("Unable to add mempool transaction", "txid", h, "err", err)
+	}
+}
+
+// indexerCaughtUp reports whether the store has indexed every block
+// vertcoind had when it was called. Call it after querying the mempool, so
+// that a transaction missing from it was either evicted or mined in a block
+// the store has.
+func indexerCaughtUp(rpc *rpcclient.Client, s store.ChainStore) (bool, error) {
+	count, err := rpc.GetBlockCount()
+	if err != nil {
+		return false, err
+	}
+	tip, err := s.TipHeight()
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return tip >= count, nil
+}
+
+// publishMempool announces that unconfirmed transactions were added or
+// removed, if there is a bus and anything changed
+func publishMempool(bus *events.Bus, s store.ChainStore, added, removed int) {
+	if bus == nil || added+removed == 0 {
+		return
+	}
+	tip, _ := s.TipHeight()
+	bus.Publish(events.Event{
+		Type:   events.TypeMempool,
+		Height: tip,
+		Data:   map[string]int{"added": added, "removed": removed},
+	})
+}
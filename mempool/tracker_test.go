@@ -0,0 +1,135 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/nodetest"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/store/storetest"
+)
+
+var testScript = []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+
+// newTestNode starts a node and a store that has indexed its genesis block
+func newTestNode(t *testing.T) (*nodetest.Node, store.Store) {
+	n := nodetest.NewNode()
+	t.Cleanup(n.Close)
+	s := storetest.NewSQLite(t)
+	err := s.InsertBlock(0, n.Block(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, s
+}
+
+func spend(tx *wire.MsgTx, vout uint32, value int64) *wire.MsgTx {
+	h := tx.TxHash()
+	spend := wire.NewMsgTx(2)
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&h, vout), nil, nil))
+	spend.AddTxOut(wire.NewTxOut(value, testScript))
+	return spend
+}
+
+func unconfirmed(t *testing.T, s store.Store) map[chainhash.Hash]bool {
+	t.Helper()
+	hashes, err := s.UnconfirmedTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[chainhash.Hash]bool{}
+	for _, h := range hashes {
+		result[*h] = true
+	}
+	return result
+}
+
+func assertMempoolEvent(t *testing.T, sub *events.Subscription, added, removed int) {
+	t.Helper()
+	select {
+	case e := <-sub.C:
+		want := map[string]int{"added": added, "removed": removed}
+		data, _ := e.Data.(map[string]int)
+		if e.Type != events.TypeMempool || data["added"] != added || data["removed"] != removed {
+			t.Errorf("Event is %+v, want a mempool event with %v", e, want)
+		}
+	default:
+		t.Errorf("No mempool event published")
+	}
+}
+
+func TestTrackerKeepsMinedTransactionsUntilIndexed(t *testing.T) {
+	n, s := newTestNode(t)
+	tracker := NewTracker(n.Client(), s, time.Second)
+
+	tx := spend(n.Block(0).Transactions[0], 0, 25e8)
+	n.AddToMempool(tx)
+	err := tracker.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unconfirmed(t, s)[tx.TxHash()] {
+		t.Fatal("Mempool transaction was not added")
+	}
+
+	// Mined, but the block isn't indexed yet
+	n.Mine(tx)
+	err = tracker.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unconfirmed(t, s)[tx.TxHash()] {
+		t.Fatal("Mined transaction was evicted before its block was indexed")
+	}
+
+	err = s.InsertBlock(1, n.Block(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tracker.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Balance(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Confirmed != 25e8 || b.Unconfirmed != 0 {
+		t.Errorf("Balance is %+v, want 25 coins confirmed", b)
+	}
+}
+
+func TestTrackerEvictsDroppedTransactions(t *testing.T) {
+	n, s := newTestNode(t)
+	tracker := NewTracker(n.Client(), s, time.Second)
+	tracker.Events = events.NewBus()
+	sub := tracker.Events.Subscribe(10)
+
+	tx := spend(n.Block(0).Transactions[0], 0, 25e8)
+	n.AddToMempool(tx)
+	err := tracker.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMempoolEvent(t, sub, 1, 0)
+
+	n.RemoveFromMempool(tx.TxHash())
+	err = tracker.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMempoolEvent(t, sub, 0, 1)
+	if unconfirmed(t, s)[tx.TxHash()] {
+		t.Error("Dropped transaction was not evicted")
+	}
+	b, err := s.Balance(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != (store.Balance{}) {
+		t.Errorf("Balance is %+v after eviction, want none", b)
+	}
+}
@@ -0,0 +1,88 @@
+// Package metrics holds the Prometheus metrics of the backend. They are
+// registered with the default registry, which also collects the Go runtime
+// and process metrics, and served on /metrics by the HTTP server.
+package metrics
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "ocm"
+
+var (
+	// RequestDuration is the latency of HTTP requests by route template,
+	// method and status code
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	IndexerHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "indexer",
+		Name:      "height",
+		Help:      "Height of the last indexed block.",
+	})
+
+	NodeHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "node",
+		Name:      "height",
+		Help:      "Height of the best block known by vertcoind.",
+	})
+
+	IndexerLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "indexer",
+		Name:      "lag_blocks",
+		Help:      "Number of blocks the indexer is behind vertcoind.",
+	})
+
+	// BlockPhaseDuration is the time spent on each phase of processing a
+	// block: fetching it over RPC, resolving transaction and script ids,
+	// processing the transactions and committing, and the total time
+	// spent storing it.
+	BlockPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "indexer",
+		Name:      "block_phase_duration_seconds",
+		Help:      "Time spent per phase of processing a block.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"phase"})
+
+	Reorgs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "indexer",
+		Name:      "reorgs_total",
+		Help:      "Number of reorgs handled.",
+	})
+
+	ReorgedBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "indexer",
+		Name:      "reorged_blocks_total",
+		Help:      "Number of blocks reverted because of reorgs.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(RequestDuration, IndexerHeight, NodeHeight, IndexerLag, BlockPhaseDuration, Reorgs, ReorgedBlocks)
+}
+
+// ObservePhase records the time since start for a block processing phase
+func ObservePhase(phase string, start time.Time) {
+	BlockPhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// RegisterDB exports the connection pool stats of db. Only the first pool
+// registered under a name is exported.
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,280 @@
+// Package nodetest runs a fake vertcoind JSON-RPC server with a chain and
+// mempool that tests control, so the packages that talk to vertcoind can
+// be tested without a node.
+package nodetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Node is a fake vertcoind. It answers the RPC calls the backend makes
+// from its own chain and mempool.
+type Node struct {
+	// TxIndex makes getrawtransaction find confirmed transactions, like
+	// vertcoind started with -txindex
+	TxIndex bool
+
+	mtx      sync.Mutex
+	chain    []*wire.MsgBlock
+	mempool  map[chainhash.Hash]*wire.MsgTx
+	feeRates map[int]float64
+	mined    int
+	srv      *httptest.Server
+}
+
+// NewNode starts a node with only a genesis block
+func NewNode() *Node {
+	n := &Node{mempool: map[chainhash.Hash]*wire.MsgTx{}, feeRates: map[int]float64{}}
+	n.srv = httptest.NewServer(http.HandlerFunc(n.serve))
+	n.Mine()
+	return n
+}
+
+func (n *Node) Close() {
+	n.srv.Close()
+}
+
+// Client returns an RPC client connected to the node
+func (n *Node) Client() *rpcclient.Client {
+	c, err := rpcclient.New(&rpcclient.ConnConfig{
+		Host:         strings.TrimPrefix(n.srv.URL, "http://"),
+		User:         "test",
+		Pass:         "test",
+		HTTPPostMode: true,
+		DisableTLS:   true,
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Mine adds a block to the tip with a coinbase and txs, and removes txs
+// from the mempool
+func (n *Node) Mine(txs ...*wire.MsgTx) *wire.MsgBlock {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	var prev chainhash.Hash
+	if len(n.chain) > 0 {
+		prev = n.chain[len(n.chain)-1].BlockHash()
+	}
+	// The coinbase is unique, so blocks on a fork differ from the ones
+	// they replace
+	n.mined++
+	extra := make([]byte, 8)
+	binary.LittleEndian.PutUint64(extra, uint64(n.mined))
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: 0xffffffff},
+		SignatureScript:  extra,
+		Sequence:         0xffffffff,
+	})
+	coinbase.AddTxOut(&wire.TxOut{Value: 25e8, PkScript: []byte{0x51}})
+
+	blk := &wire.MsgBlock{Header: wire.BlockHeader{
+		Version:   2,
+		PrevBlock: prev,
+		Timestamp: time.Unix(1600000000+int64(len(n.chain))*150, 0),
+		Bits:      0x1e0ffff0,
+		Nonce:     uint32(n.mined),
+	}}
+	blk.AddTransaction(coinbase)
+	for _, tx := range txs {
+		blk.AddTransaction(tx)
+		delete(n.mempool, tx.TxHash())
+	}
+	merkle := blk.Transactions[0].TxHash()
+	blk.Header.MerkleRoot = merkle
+	n.chain = append(n.chain, blk)
+	return blk
+}
+
+// Rewind removes the blocks above height, so the next blocks mined form a
+// fork
+func (n *Node) Rewind(height int64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.chain = n.chain[:height+1]
+}
+
+// Height returns the height of the tip
+func (n *Node) Height() int64 {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return int64(len(n.chain) - 1)
+}
+
+// Block returns the block at height in the active chain
+func (n *Node) Block(height int64) *wire.MsgBlock {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.chain[height]
+}
+
+// AddToMempool and RemoveFromMempool change the mempool
+func (n *Node) AddToMempool(tx *wire.MsgTx) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.mempool[tx.TxHash()] = tx
+}
+
+func (n *Node) RemoveFromMempool(hash chainhash.Hash) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	delete(n.mempool, hash)
+}
+
+// SetFeeRate makes estimatesmartfee return rate, in coins per kB, for a
+// confirmation target. Targets without a rate have insufficient data.
+func (n *Node) SetFeeRate(target int, rate float64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.feeRates[target] = rate
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (n *Node) serve(w http.ResponseWriter, r *http.Request) {
+	var req request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	n.mtx.Lock()
+	result, rpcErr := n.call(req)
+	n.mtx.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     req.ID,
+		"result": result,
+		"error":  rpcErr,
+	})
+}
+
+func (n *Node) call(req request) (interface{}, *btcjson.RPCError) {
+	switch req.Method {
+	case "getblockcount":
+		return len(n.chain) - 1, nil
+	case "getblockhash":
+		var height int
+		json.Unmarshal(req.Params[0], &height)
+		if height < 0 || height >= len(n.chain) {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Block height out of range")
+		}
+		return n.chain[height].BlockHash().String(), nil
+	case "getblock", "getblockheader":
+		blk := n.blockByHash(stringParam(req, 0))
+		if blk == nil {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+		}
+		var buf bytes.Buffer
+		if req.Method == "getblock" {
+			blk.Serialize(&buf)
+		} else {
+			blk.Header.Serialize(&buf)
+		}
+		return hex.EncodeToString(buf.Bytes()), nil
+	case "getrawmempool":
+		hashes := make([]string, 0, len(n.mempool))
+		for h := range n.mempool {
+			hashes = append(hashes, h.String())
+		}
+		return hashes, nil
+	case "getmempoolentry":
+		h, _ := chainhash.NewHashFromStr(stringParam(req, 0))
+		if h == nil || n.mempool[*h] == nil {
+			return nil, noTxInfo()
+		}
+		return map[string]interface{}{}, nil
+	case "estimatesmartfee":
+		var target int
+		json.Unmarshal(req.Params[0], &target)
+		rate, ok := n.feeRates[target]
+		if !ok {
+			return map[string]interface{}{"errors": []string{"Insufficient data or no feerate found"}, "blocks": 0}, nil
+		}
+		return map[string]interface{}{"feerate": rate, "blocks": target}, nil
+	case "getrawtransaction":
+		h, _ := chainhash.NewHashFromStr(stringParam(req, 0))
+		if h == nil {
+			return nil, noTxInfo()
+		}
+		tx := n.transaction(*h)
+		if tx == nil {
+			return nil, noTxInfo()
+		}
+		var buf bytes.Buffer
+		tx.Serialize(&buf)
+		raw := hex.EncodeToString(buf.Bytes())
+		var verbose int
+		if len(req.Params) > 1 {
+			json.Unmarshal(req.Params[1], &verbose)
+		}
+		if verbose != 0 {
+			return map[string]interface{}{"hex": raw, "txid": h.String()}, nil
+		}
+		return raw, nil
+	}
+	return nil, btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found")
+}
+
+func (n *Node) blockByHash(s string) *wire.MsgBlock {
+	for _, b := range n.chain {
+		if b.BlockHash().String() == s {
+			return b
+		}
+	}
+	return nil
+}
+
+// transaction finds a transaction in the mempool, or in the chain when
+// the node has a transaction index
+func (n *Node) transaction(h chainhash.Hash) *wire.MsgTx {
+	if tx, ok := n.mempool[h]; ok {
+		return tx
+	}
+	if !n.TxIndex {
+		return nil
+	}
+	for _, b := range n.chain {
+		for _, tx := range b.Transactions {
+			if tx.TxHash() == h {
+				return tx
+			}
+		}
+	}
+	return nil
+}
+
+func stringParam(req request, i int) string {
+	if len(req.Params) <= i {
+		return ""
+	}
+	var s string
+	json.Unmarshal(req.Params[i], &s)
+	return s
+}
+
+func noTxInfo() *btcjson.RPCError {
+	return btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No such mempool or blockchain transaction")
+}
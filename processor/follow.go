@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// followInterval is how often FollowLoop checks the store for new blocks
+const followInterval = time.Second
+
+// follower tracks the tip of a store that is indexed by another process.
+// It remembers the hashes of the blocks it saw down to maxReorgDepth below
+// the tip, to find the fork point when the indexer reverted blocks.
+type follower struct {
+	p      *Processor
+	height int64
+	seen   map[int64]chainhash.Hash
+}
+
+// FollowLoop follows the tip of the store, for API instances that serve a
+// database indexed by another process. It publishes the same events as the
+// indexer would: a TypeBlock event for every block that was added and a
+// TypeReorg event when blocks were replaced. It never returns.
+func (p *Processor) FollowLoop() {
+	f := &follower{p: p, height: -1}
+	for {
+		err := f.poll()
+		if err != nil {
+			p.log.Warn("Unable to follow the indexed tip", "err", err)
+		}
+		tip, err := p.rpc.GetBlockCount()
+		if err == nil {
+			p.BackendTipHeight = tip
+		}
+		p.updateHeightMetrics()
+		time.Sleep(followInterval)
+	}
+}
+
+// poll compares the tip of the store with the one it saw last, and
+// publishes events for the blocks that changed in between
+func (f *follower) poll() error {
+	p := f.p
+	tip, err := p.store.TipHeight()
+	if err == store.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tipHash, err := p.store.BlockHash(tip)
+	if err != nil {
+		return err
+	}
+	if f.seen == nil {
+		// Nothing to announce about the blocks that were there already
+		f.seen = map[int64]chainhash.Hash{}
+		f.remember(tip, tipHash)
+		return f.setTip(tip)
+	}
+	if tip == f.height && *tipHash == f.seen[tip] {
+		return nil
+	}
+
+	// Walk back to the last block that is still the one we saw
+	fork := f.height
+	if tip < fork {
+		fork = tip
+	}
+	for ; fork >= 0; fork-- {
+		known, ok := f.seen[fork]
+		if !ok {
+			// Below what we remember, assume it didn't change
+			break
+		}
+		h, err := p.store.BlockHash(fork)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if *h == known {
+			break
+		}
+	}
+
+	if fork < f.height {
+		forkPoint := BlockRef{Height: fork, Hash: hashString(f.seen, fork)}
+		ev := ReorgEvent{
+			Depth:     f.height - fork,
+			OldTip:    BlockRef{Height: f.height, Hash: hashString(f.seen, f.height)},
+			NewTip:    forkPoint,
+			ForkPoint: forkPoint,
+			Time:      time.Now(),
+		}
+		p.log.Info("Indexed chain was reorganized", "depth", ev.Depth, "oldTipHeight", ev.OldTip.Height, "tipHeight", tip, "forkHeight", fork)
+		for h := range f.seen {
+			if h > fork {
+				delete(f.seen, h)
+			}
+		}
+		f.height = fork
+		p.Events.Publish(events.Event{Type: events.TypeReorg, Height: fork, Data: ev})
+	}
+
+	for h := f.height + 1; h <= tip; h++ {
+		hash, err := p.store.BlockHash(h)
+		if err != nil {
+			return err
+		}
+		f.remember(h, hash)
+		p.blockRate.Incr(1)
+		p.TipHeight = h
+		p.Events.Publish(events.Event{
+			Type:   events.TypeBlock,
+			Height: h,
+			Data:   BlockRef{Height: h, Hash: hash.String()},
+		})
+	}
+	return f.setTip(tip)
+}
+
+func (f *follower) remember(height int64, hash *chainhash.Hash) {
+	f.seen[height] = *hash
+	f.height = height
+	delete(f.seen, height-f.p.maxReorgDepth-1)
+}
+
+// setTip updates the tip and difficulty of the processor from the block
+// at height
+func (f *follower) setTip(height int64) error {
+	blk, err := f.p.store.BlockByHeight(height)
+	if err != nil {
+		return err
+	}
+	if blk.Bits != 0 {
+		f.p.Difficulty = f.p.BitsToDiff(blk.Bits)
+	}
+	f.p.TipHeight = height
+	return nil
+}
+
+func hashString(seen map[int64]chainhash.Hash, height int64) string {
+	h, ok := seen[height]
+	if !ok {
+		return ""
+	}
+	return h.String()
+}
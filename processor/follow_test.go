@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/nodetest"
+)
+
+func TestFollowStore(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	for i := 0; i < 5; i++ {
+		n.Mine()
+	}
+	indexer, s := newTestProcessor(t, n, -1)
+	indexer.CatchUp()
+
+	// An API instance on the same store
+	cfg := config.Default().Indexer
+	cfg.StartHeight = -1
+	api, err := NewProcessor(n.Client(), s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := api.Events.Subscribe(100)
+	f := &follower{p: api, height: -1}
+	err = f.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.TipHeight != 5 || api.Difficulty == 0 {
+		t.Errorf("TipHeight is %d and difficulty %f, want 5 and the difficulty of block 5", api.TipHeight, api.Difficulty)
+	}
+	if evs := drain(sub); len(evs) != 0 {
+		t.Errorf("Blocks that were indexed before were announced: %+v", evs)
+	}
+
+	// Not indexed yet
+	n.Mine()
+	n.Mine()
+	err = f.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evs := drain(sub); len(evs) != 0 || api.TipHeight != 5 {
+		t.Errorf("Tip moved to %d before the store did, events %+v", api.TipHeight, evs)
+	}
+
+	indexer.CatchUp()
+	err = f.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs := drain(sub)
+	if len(evs) != 2 || api.TipHeight != 7 {
+		t.Fatalf("Got %d events and tip %d, want 2 block events and tip 7", len(evs), api.TipHeight)
+	}
+	for i, e := range evs {
+		ref, _ := e.Data.(BlockRef)
+		if e.Type != events.TypeBlock || e.Height != int64(6+i) || ref.Hash != n.Block(int64(6+i)).BlockHash().String() {
+			t.Errorf("Event %d is %s at %d (%+v)", i, e.Type, e.Height, e.Data)
+		}
+	}
+
+	// The indexer replaces blocks 5 to 7 with 5 to 8
+	n.Rewind(4)
+	for i := 0; i < 4; i++ {
+		n.Mine()
+	}
+	indexer.CatchUp()
+	err = f.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs = drain(sub)
+	if len(evs) != 5 || evs[0].Type != events.TypeReorg {
+		t.Fatalf("Got events %+v, want a reorg and 4 blocks", evs)
+	}
+	reorg := evs[0].Data.(ReorgEvent)
+	if reorg.ForkPoint.Height != 4 || reorg.Depth != 3 || reorg.OldTip.Height != 7 || reorg.NewTip != reorg.ForkPoint || evs[0].Height != 4 {
+		t.Errorf("Unexpected reorg event %s", reorg)
+	}
+	for i, e := range evs[1:] {
+		if e.Type != events.TypeBlock || e.Height != int64(5+i) {
+			t.Errorf("Event %d is %s at %d", i+1, e.Type, e.Height)
+		}
+	}
+	if api.TipHeight != 8 {
+		t.Errorf("TipHeight is %d, want 8", api.TipHeight)
+	}
+
+	// Nothing changed
+	err = f.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evs := drain(sub); len(evs) != 0 {
+		t.Errorf("Unexpected events %+v", evs)
+	}
+}
@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/metrics"
+)
+
+type fetchedBlock struct {
+	height int64
+	hash   *chainhash.Hash
+	header *wire.BlockHeader
+	block  *wire.MsgBlock
+	err    error
+}
+
+type fetchJob struct {
+	height int64
+	result chan fetchedBlock
+}
+
+// prefetcher fetches the blocks in a range of heights with a number of
+// concurrent RPC workers, and hands them out in order of height. At most
+// queueDepth blocks are fetched ahead of the one that is being processed.
+type prefetcher struct {
+	ordered chan chan fetchedBlock
+	jobs    chan fetchJob
+	quit    chan struct{}
+	stopped bool
+}
+
+func (p *Processor) startPrefetch(from, to int64) *prefetcher {
+	return newPrefetcher(from, to, p.fetchWorkers, p.fetchQueueDepth, p.fetchBlock)
+}
+
+// newPrefetcher starts fetching the blocks from to to with fetch, which is
+// called by workers concurrently
+func newPrefetcher(from, to int64, workers, queueDepth int, fetch func(height int64) fetchedBlock) *prefetcher {
+	f := &prefetcher{
+		ordered: make(chan chan fetchedBlock, queueDepth),
+		jobs:    make(chan fetchJob),
+		quit:    make(chan struct{}),
+	}
+
+	if to-from+1 < int64(workers) {
+		workers = int(to - from + 1)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range f.jobs {
+				j.result <- fetch(j.height)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(f.jobs)
+		defer close(f.ordered)
+		for h := from; h <= to; h++ {
+			res := make(chan fetchedBlock, 1)
+			select {
+			case f.ordered <- res:
+			case <-f.quit:
+				return
+			}
+			select {
+			case f.jobs <- fetchJob{height: h, result: res}:
+			case <-f.quit:
+				return
+			}
+		}
+	}()
+
+	return f
+}
+
+// Next returns the next block in the range, or false when the whole range
+// has been handed out.
+func (f *prefetcher) Next() (fetchedBlock, bool) {
+	res, ok := <-f.ordered
+	if !ok {
+		return fetchedBlock{}, false
+	}
+	return <-res, true
+}
+
+func (f *prefetcher) Stop() {
+	if !f.stopped {
+		close(f.quit)
+		f.stopped = true
+	}
+}
+
+func (p *Processor) fetchBlock(height int64) fetchedBlock {
+	fb := fetchedBlock{height: height}
+
+	defer metrics.ObservePhase("fetch", time.Now())
+	start := time.Now()
+	fb.hash, fb.err = p.rpc.GetBlockHash(height)
+	p.rpcLog.Debug("Fetched block hash", "height", height, "durationUs", time.Since(start).Microseconds())
+	if fb.err != nil {
+		return fb
+	}
+
+	start = time.Now()
+	fb.block, fb.err = p.rpc.GetBlock(fb.hash)
+	p.rpcLog.Debug("Fetched block", "height", height, "hash", fb.hash, "durationUs", time.Since(start).Microseconds())
+	if fb.err == nil {
+		fb.header = &fb.block.Header
+	}
+	return fb
+}
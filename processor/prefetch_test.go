@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeFetcher finishes fetches of lower heights last, so they complete out
+// of order, and fails the heights in fail
+type fakeFetcher struct {
+	fail map[int64]bool
+	// waiting is the height the consumer waits for
+	waiting int64
+	mtx     sync.Mutex
+	// ahead is the furthest a fetch started ahead of waiting
+	ahead int64
+}
+
+func (f *fakeFetcher) fetch(height int64) fetchedBlock {
+	f.mtx.Lock()
+	if a := height - atomic.LoadInt64(&f.waiting); a > f.ahead {
+		f.ahead = a
+	}
+	f.mtx.Unlock()
+
+	time.Sleep(time.Duration(4-height%4) * time.Millisecond)
+	if f.fail[height] {
+		return fetchedBlock{height: height, err: fmt.Errorf("Block %d not found", height)}
+	}
+	return fetchedBlock{height: height}
+}
+
+func TestPrefetchInOrder(t *testing.T) {
+	const depth = 3
+	f := &fakeFetcher{fail: map[int64]bool{12: true, 13: true, 30: true}, waiting: 10}
+	pf := newPrefetcher(10, 40, 4, depth, f.fetch)
+	defer pf.Stop()
+
+	for h := int64(10); h <= 40; h++ {
+		atomic.StoreInt64(&f.waiting, h)
+		fb, ok := pf.Next()
+		if !ok {
+			t.Fatalf("Range ended before %d", h)
+		}
+		if fb.height != h {
+			t.Fatalf("Got block %d, want %d", fb.height, h)
+		}
+		if (fb.err != nil) != f.fail[h] {
+			t.Errorf("Block %d has error %v, want failure=%v", h, fb.err, f.fail[h])
+		}
+	}
+	if fb, ok := pf.Next(); ok {
+		t.Errorf("Got block %d after the range", fb.height)
+	}
+	if f.ahead > depth {
+		t.Errorf("Fetched %d blocks ahead, want at most %d", f.ahead, depth)
+	}
+}
+
+func TestPrefetchStopLeaksNoWorkers(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	f := &fakeFetcher{fail: map[int64]bool{}}
+	pf := newPrefetcher(0, 1000, 8, 16, f.fetch)
+	for i := 0; i < 5; i++ {
+		pf.Next()
+	}
+	// Give the queue time to fill up, so the workers are idle and the
+	// blocks are waiting for the consumer
+	time.Sleep(100 * time.Millisecond)
+	pf.Stop()
+	// Stopping twice is fine
+	pf.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left after Stop, %d before:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPrefetchStopWhileFetchBlocks(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	release := make(chan struct{})
+	pf := newPrefetcher(0, 100, 4, 8, func(height int64) fetchedBlock {
+		<-release
+		return fetchedBlock{height: height, err: errors.New("Connection refused")}
+	})
+	// Every worker is stuck in a fetch when the consumer gives up
+	time.Sleep(20 * time.Millisecond)
+	pf.Stop()
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left after Stop, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
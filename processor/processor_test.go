@@ -0,0 +1,195 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/nodetest"
+	"github.com/gertjaap/ocm-backend/store/storetest"
+)
+
+func newTestProcessor(t *testing.T, n *nodetest.Node, startHeight int64) (*Processor, *storetest.Chain) {
+	cfg := config.Default().Indexer
+	cfg.StartHeight = startHeight
+	s := storetest.NewChain()
+	p, err := NewProcessor(n.Client(), s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, s
+}
+
+// assertSameChain checks that the store has exactly the blocks of the
+// active chain of the node above startHeight
+func assertSameChain(t *testing.T, n *nodetest.Node, s *storetest.Chain, startHeight int64) {
+	t.Helper()
+	tip, err := s.TipHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip != n.Height() {
+		t.Fatalf("Indexed tip is %d, node is at %d", tip, n.Height())
+	}
+	for h := startHeight + 1; h <= tip; h++ {
+		stored, err := s.BlockHash(h)
+		if err != nil {
+			t.Fatalf("Block %d: %v", h, err)
+		}
+		if want := n.Block(h).BlockHash(); !stored.IsEqual(&want) {
+			t.Fatalf("Block %d is %s, node has %s", h, stored, want)
+		}
+	}
+}
+
+func drain(sub *events.Subscription) []events.Event {
+	result := make([]events.Event, 0)
+	for {
+		select {
+		case e := <-sub.C:
+			result = append(result, e)
+		default:
+			return result
+		}
+	}
+}
+
+func TestCatchUpIndexesChain(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	for i := 0; i < 5; i++ {
+		n.Mine()
+	}
+
+	p, s := newTestProcessor(t, n, -1)
+	sub := p.Events.Subscribe(100)
+	p.CatchUp()
+
+	assertSameChain(t, n, s, -1)
+	if p.TipHeight != 5 {
+		t.Errorf("TipHeight is %d, expected 5", p.TipHeight)
+	}
+	evs := drain(sub)
+	if len(evs) != 6 {
+		t.Fatalf("Got %d events, expected one per block", len(evs))
+	}
+	for i, e := range evs {
+		if e.Type != events.TypeBlock || e.Height != int64(i) {
+			t.Errorf("Event %d is %s at %d", i, e.Type, e.Height)
+		}
+	}
+}
+
+func TestStartHeight(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	for i := 0; i < 5; i++ {
+		n.Mine()
+	}
+
+	p, s := newTestProcessor(t, n, 2)
+	p.CatchUp()
+
+	assertSameChain(t, n, s, 2)
+	if _, err := s.BlockHash(2); err == nil {
+		t.Error("Block at the start height was indexed")
+	}
+}
+
+func TestReorgRevertsToForkPoint(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	for i := 0; i < 5; i++ {
+		n.Mine()
+	}
+	p, s := newTestProcessor(t, n, -1)
+	p.CatchUp()
+
+	n.Rewind(3)
+	for i := 0; i < 3; i++ {
+		n.Mine()
+	}
+	sub := p.Events.Subscribe(100)
+	p.CatchUp()
+
+	assertSameChain(t, n, s, -1)
+	var reorg *ReorgEvent
+	for _, e := range drain(sub) {
+		if e.Type == events.TypeReorg {
+			ev := e.Data.(ReorgEvent)
+			reorg = &ev
+		}
+	}
+	if reorg == nil {
+		t.Fatal("No reorg event published")
+	}
+	if reorg.ForkPoint.Height != 3 || reorg.Depth != 2 || reorg.OldTip.Height != 5 || reorg.NewTip != reorg.ForkPoint {
+		t.Errorf("Unexpected reorg event %s", reorg)
+	}
+	forkHash, _ := n.Client().GetBlockHash(3)
+	if reorg.NewTip.Hash != forkHash.String() {
+		t.Errorf("New tip is %s, want the fork point %s", reorg.NewTip.Hash, forkHash)
+	}
+	blk, _ := s.BlockByHeight(6)
+	if p.TipHeight != 6 || p.Difficulty != p.BitsToDiff(blk.Bits) {
+		t.Errorf("Tip is %d with difficulty %f after the reorg", p.TipHeight, p.Difficulty)
+	}
+	// Blocks 0-5 and 4-6 of the new chain, not the one that didn't connect
+	if rate := p.blockRate.Rate(); rate != 9 {
+		t.Errorf("Counted %d processed blocks, want 9", rate)
+	}
+}
+
+func TestRevertFrom(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	for i := 0; i < 5; i++ {
+		n.Mine()
+	}
+	p, s := newTestProcessor(t, n, -1)
+	p.CatchUp()
+
+	_, err := p.RevertFrom(6)
+	if err == nil {
+		t.Error("Reverting above the tip succeeded")
+	}
+	height, err := p.RevertFrom(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip, _ := s.TipHeight(); height != 2 || tip != 2 {
+		t.Fatalf("Reverted to %d, tip is %d, expected 2", height, tip)
+	}
+
+	p.CatchUp()
+	assertSameChain(t, n, s, -1)
+}
+
+func TestVerifyChain(t *testing.T) {
+	n := nodetest.NewNode()
+	defer n.Close()
+	for i := 0; i < 5; i++ {
+		n.Mine()
+	}
+	p, _ := newTestProcessor(t, n, -1)
+	p.CatchUp()
+
+	problems, err := p.VerifyChain(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	n.Rewind(3)
+	n.Mine()
+	n.Mine()
+	problems, err = p.VerifyChain(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected the blocks at height 4 and 5 to differ, got %v", problems)
+	}
+}
@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/metrics"
+)
+
+type BlockRef struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// ReorgEvent describes blocks that were reverted from the index. NewTip is
+// the tip left in the store after the revert, which is the fork point; the
+// blocks of the new chain follow as TypeBlock events once they are indexed.
+type ReorgEvent struct {
+	Depth     int64     `json:"depth"`
+	OldTip    BlockRef  `json:"oldTip"`
+	NewTip    BlockRef  `json:"newTip"`
+	ForkPoint BlockRef  `json:"forkPoint"`
+	Time      time.Time `json:"time"`
+}
+
+func (e ReorgEvent) String() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf("depth=%d oldTip=%d newTip=%d", e.Depth, e.OldTip.Height, e.NewTip.Height)
+	}
+	return string(b)
+}
+
+// handleReorg finds the last block we share with vertcoind, below the
+// tip at height, and reverts everything above it in a single database
+// transaction. It returns the height to continue indexing from.
+func (p *Processor) handleReorg(height, minHeight int64) (int64, error) {
+	oldTipHash, err := p.store.BlockHash(height)
+	if err != nil {
+		return height, fmt.Errorf("Error querying tip hash at height %d: %v", height, err)
+	}
+
+	forkHeight, forkHash, err := p.findForkPoint(height, minHeight)
+	if err != nil {
+		return height, err
+	}
+	if forkHeight == height {
+		// Our tip is still part of the active chain, the block we got was
+		// fetched before vertcoind switched over and will be fetched again
+		p.log.Info("Block does not connect to our tip anymore, refetching", "height", height+1, "tipHeight", height)
+		return height, nil
+	}
+
+	p.log.Info("Reorg detected, reverting blocks", "forkHeight", forkHeight, "depth", height-forkHeight)
+	err = p.store.RevertBlocks(forkHeight)
+	if err != nil {
+		return height, err
+	}
+	metrics.Reorgs.Inc()
+	metrics.ReorgedBlocks.Add(float64(height - forkHeight))
+
+	ev := ReorgEvent{
+		Depth:     height - forkHeight,
+		OldTip:    BlockRef{Height: height, Hash: oldTipHash.String()},
+		ForkPoint: BlockRef{Height: forkHeight},
+		Time:      time.Now(),
+	}
+	if forkHash != nil {
+		ev.ForkPoint.Hash = forkHash.String()
+	}
+	ev.NewTip = ev.ForkPoint
+	p.log.Info("Reorg", "depth", ev.Depth, "oldTipHeight", ev.OldTip.Height, "oldTipHash", ev.OldTip.Hash, "newTipHeight", ev.NewTip.Height, "newTipHash", ev.NewTip.Hash, "forkHeight", ev.ForkPoint.Height, "forkHash", ev.ForkPoint.Hash)
+	p.Events.Publish(events.Event{Type: events.TypeReorg, Height: forkHeight, Data: ev})
+	return forkHeight, nil
+}
+
+// findForkPoint walks back from height through the stored block hashes
+// until it finds one that vertcoind also has in its active chain. If no
+// common block exists above minHeight, minHeight is returned with a nil
+// hash so everything we indexed is reverted.
+func (p *Processor) findForkPoint(height, minHeight int64) (int64, *chainhash.Hash, error) {
+	for h := height; h > minHeight; h-- {
+		if height-h > p.maxReorgDepth {
+			return 0, nil, fmt.Errorf("No common ancestor found within %d blocks of height %d", p.maxReorgDepth, height)
+		}
+
+		stored, err := p.store.BlockHash(h)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Error querying stored hash at height %d: %v", h, err)
+		}
+		node, err := p.rpc.GetBlockHash(h)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Error querying node hash at height %d: %v", h, err)
+		}
+		if stored.IsEqual(node) {
+			return h, stored, nil
+		}
+		p.log.Debug("Block is orphaned", "height", h, "hash", stored, "nodeHash", node)
+	}
+	return minHeight, nil, nil
+}
+
+// RevertFrom reverts the indexed blocks from height from on, so they are
+// indexed again on the next run. It returns the new tip height.
+func (p *Processor) RevertFrom(from int64) (int64, error) {
+	if from < 0 {
+		return 0, fmt.Errorf("Invalid height %d", from)
+	}
+	height, err := p.store.TipHeight()
+	if err != nil {
+		return 0, fmt.Errorf("Error getting last processed height: %v", err)
+	}
+	if from > height {
+		return 0, fmt.Errorf("Nothing to reindex, the last processed block is at height %d", height)
+	}
+
+	p.log.Info("Reverting blocks for reindex", "from", from, "tipHeight", height, "count", height-from+1)
+	err = p.store.RevertBlocks(from - 1)
+	if err != nil {
+		return 0, err
+	}
+	return from - 1, nil
+}
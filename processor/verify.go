@@ -0,0 +1,46 @@
+package processor
+
+import (
+	"fmt"
+
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// VerifyChain compares the hashes of the last depth indexed blocks with
+// the active chain of vertcoind. It returns a description of every block
+// that differs. A difference at the tip is normal while the indexer is
+// handling a reorg.
+func (p *Processor) VerifyChain(depth int64) ([]string, error) {
+	height, err := p.store.TipHeight()
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error getting last processed height: %v", err)
+	}
+
+	nodeHeight, err := p.rpc.GetBlockCount()
+	if err != nil {
+		return nil, fmt.Errorf("Error querying node height: %v", err)
+	}
+
+	problems := make([]string, 0)
+	if height > nodeHeight {
+		problems = append(problems, fmt.Sprintf("Indexed tip at height %d is above the tip of vertcoind at height %d", height, nodeHeight))
+		height = nodeHeight
+	}
+	for h := height; h > height-depth && h > p.startHeight; h-- {
+		stored, err := p.store.BlockHash(h)
+		if err != nil {
+			return nil, fmt.Errorf("Error querying stored hash at height %d: %v", h, err)
+		}
+		node, err := p.rpc.GetBlockHash(h)
+		if err != nil {
+			return nil, fmt.Errorf("Error querying node hash at height %d: %v", h, err)
+		}
+		if !stored.IsEqual(node) {
+			problems = append(problems, fmt.Sprintf("Block at height %d is %s, but vertcoind has %s", h, stored, node))
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// scriptIDs resolves the ids of all scripts in a single query. Scripts that
+// were never seen are missing from the result.
+func (s *sqlStore) scriptIDs(scripts [][]byte) (map[string]int64, error) {
+	result := map[string]int64{}
+	if len(scripts) == 0 {
+		return result, nil
+	}
+
+	var sqlParamBuf bytes.Buffer
+	sqlParams := make([]interface{}, 0, len(scripts))
+	for i, script := range scripts {
+		if i > 0 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		io.WriteString(&sqlParamBuf, fmt.Sprintf("$%d", i+1))
+		sqlParams = append(sqlParams, script)
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf("SELECT id, script FROM scripts WHERE script in (%s)", sqlParamBuf.String()), sqlParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var script []byte
+		err = rows.Scan(&id, &script)
+		if err != nil {
+			return nil, err
+		}
+		result[hex.EncodeToString(script)] = id
+	}
+	return result, rows.Err()
+}
+
+// queryScripts runs query with the ids of the known scripts as its
+// parameters, in place of %s. It returns nil rows when none of the scripts
+// are known.
+func (s *sqlStore) queryScripts(query string, ids map[string]int64) (*sql.Rows, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var sqlParamBuf bytes.Buffer
+	sqlParams := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if len(sqlParams) > 0 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		sqlParams = append(sqlParams, id)
+		io.WriteString(&sqlParamBuf, fmt.Sprintf("$%d", len(sqlParams)))
+	}
+	return s.db.Query(fmt.Sprintf(query, sqlParamBuf.String()), sqlParams...)
+}
+
+func (s *sqlStore) KnownScripts(scripts [][]byte) ([]bool, error) {
+	ids, err := s.scriptIDs(scripts)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying scripts: %v", err)
+	}
+	result := make([]bool, len(scripts))
+	for i, script := range scripts {
+		_, result[i] = ids[hex.EncodeToString(script)]
+	}
+	return result, nil
+}
+
+func (s *sqlStore) Balances(scripts [][]byte) ([]Balance, error) {
+	ids, err := s.scriptIDs(scripts)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying scripts: %v", err)
+	}
+
+	rows, err := s.queryScripts(`select o.script_id,
+		coalesce(sum(case when t.block_id IS NOT NULL AND (o.coinbase=false or b.height <= `+matureHeight+`) then o.value else 0 end),0),
+		coalesce(sum(case when t.block_id IS NOT NULL AND (o.coinbase=true and b.height > `+matureHeight+`) then o.value else 0 end),0),
+		coalesce(sum(case when t.block_id IS NULL then o.value else 0 end),0)
+		from outputs o inner join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id
+		where o.script_id in (%s) AND o.spent_in_tx IS NULL group by o.script_id`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying balances: %v", err)
+	}
+	byID := map[int64]Balance{}
+	if rows != nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var b Balance
+			err = rows.Scan(&id, &b.Confirmed, &b.Maturing, &b.Unconfirmed)
+			if err != nil {
+				return nil, fmt.Errorf("Error scanning balance row: %v", err)
+			}
+			byID[id] = b
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("Error querying balances: %v", err)
+		}
+	}
+
+	result := make([]Balance, len(scripts))
+	for i, script := range scripts {
+		if id, ok := ids[hex.EncodeToString(script)]; ok {
+			result[i] = byID[id]
+		}
+	}
+	return result, nil
+}
+
+func (s *sqlStore) UtxosForScripts(scripts [][]byte) ([][]Utxo, error) {
+	ids, err := s.scriptIDs(scripts)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying scripts: %v", err)
+	}
+
+	rows, err := s.queryScripts("select o.script_id, t.hash, o.vout, o.value, b.height from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where o.script_id in (%s) AND t.block_id IS NOT NULL AND (o.coinbase=false or b.height <= "+matureHeight+") AND o.spent_in_tx IS NULL", ids)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying utxos: %v", err)
+	}
+	byID := map[int64][]Utxo{}
+	if rows != nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var txid []byte
+			var u Utxo
+			err = rows.Scan(&id, &txid, &u.Vout, &u.Value, &u.Height)
+			if err != nil {
+				dbLog.Warn("Error scanning utxo row", "err", err)
+				continue
+			}
+			h, err := chainhash.NewHash(txid)
+			if err != nil {
+				dbLog.Warn("Utxo has invalid tx hash", "err", err)
+				continue
+			}
+			u.TxHash = *h
+			byID[id] = append(byID[id], u)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("Error querying utxos: %v", err)
+		}
+	}
+
+	result := make([][]Utxo, len(scripts))
+	for i, script := range scripts {
+		result[i] = make([]Utxo, 0)
+		if id, ok := ids[hex.EncodeToString(script)]; ok && byID[id] != nil {
+			result[i] = byID[id]
+		}
+	}
+	return result, nil
+}
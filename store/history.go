@@ -0,0 +1,100 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// unconfirmedHeight sorts unconfirmed transactions before all confirmed ones
+const unconfirmedHeight = int64(2147483647)
+
+// historyQuery sums the outputs a transaction created for a script and
+// subtracts the ones it spent. Pages are ordered by height, then by
+// transaction ID, which together form the cursor. Heights are compared as
+// bigint, as the cursor of the first page is above the range of the integer
+// height column in PostgreSQL.
+const historyQuery = `SELECT h.tx_id, h.sort_height, t.hash, b.hash, b.time, h.value FROM (
+	SELECT x.tx_id, COALESCE(CAST(b.height AS BIGINT), %d) AS sort_height, SUM(x.value) AS value FROM (
+		SELECT created_in_tx AS tx_id, value FROM outputs WHERE script_id=$1
+		UNION ALL
+		SELECT spent_in_tx AS tx_id, -value AS value FROM outputs WHERE script_id=$1 AND spent_in_tx IS NOT NULL
+	) x INNER JOIN transactions t ON t.id=x.tx_id LEFT JOIN blocks b ON b.id=t.block_id
+	GROUP BY x.tx_id, b.height
+) h INNER JOIN transactions t ON t.id=h.tx_id LEFT JOIN blocks b ON b.id=t.block_id
+WHERE h.sort_height < CAST($2 AS BIGINT) OR (h.sort_height = CAST($2 AS BIGINT) AND h.tx_id < $3)
+ORDER BY h.sort_height DESC, h.tx_id DESC LIMIT $4`
+
+func (s *sqlStore) History(script []byte, cursor string, limit int) ([]HistoryEntry, string, error) {
+	result := make([]HistoryEntry, 0)
+	afterHeight, afterID, err := parseHistoryCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scriptID, err := s.scriptID(script)
+	if err != nil {
+		if err == ErrNotFound {
+			return result, "", nil
+		}
+		return nil, "", fmt.Errorf("Error querying script: %v", err)
+	}
+
+	// Fetch one more than requested to know if there is a next page
+	rows, err := s.db.Query(fmt.Sprintf(historyQuery, unconfirmedHeight), scriptID, afterHeight, afterID, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error querying history: %v", err)
+	}
+	defer rows.Close()
+
+	var lastHeight, lastID int64
+	next := ""
+	for rows.Next() {
+		if len(result) == limit {
+			next = fmt.Sprintf("%d:%d", lastHeight, lastID)
+			break
+		}
+		var txID, height int64
+		var txHash, blockHash []byte
+		var blockTime sql.NullInt64
+		var e HistoryEntry
+		err = rows.Scan(&txID, &height, &txHash, &blockHash, &blockTime, &e.Value)
+		if err != nil {
+			return nil, "", err
+		}
+		h, err := chainhash.NewHash(txHash)
+		if err != nil {
+			return nil, "", err
+		}
+		e.TxHash = *h
+		if height != unconfirmedHeight {
+			e.Confirmed = true
+			e.Height = height
+			e.BlockHash, err = chainhash.NewHash(blockHash)
+			if err != nil {
+				return nil, "", err
+			}
+			if blockTime.Valid {
+				t := time.Unix(blockTime.Int64, 0)
+				e.BlockTime = &t
+			}
+		}
+		result = append(result, e)
+		lastHeight, lastID = height, txID
+	}
+	return result, next, rows.Err()
+}
+
+func parseHistoryCursor(cursor string) (int64, int64, error) {
+	if cursor == "" {
+		return unconfirmedHeight + 1, 0, nil
+	}
+	var height, id int64
+	_, err := fmt.Sscanf(cursor, "%d:%d", &height, &id)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return height, id, nil
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gertjaap/ocm-backend/metrics"
+	_ "github.com/lib/pq"
+)
+
+// postgresBaseTables is the schema of database.sql, the first migration
+const postgresBaseTables = `
+CREATE TABLE IF NOT EXISTS public.blocks (
+	id bigserial PRIMARY KEY,
+	height integer,
+	hash bytea
+);
+CREATE INDEX IF NOT EXISTS blocks_idx_hash ON public.blocks USING btree (hash);
+CREATE INDEX IF NOT EXISTS blocks_idx_height ON public.blocks USING btree (height DESC NULLS LAST);
+
+CREATE TABLE IF NOT EXISTS public.scripts (
+	id bigserial PRIMARY KEY,
+	script bytea
+);
+CREATE UNIQUE INDEX IF NOT EXISTS scripts_idx_script ON public.scripts USING btree (script);
+
+CREATE TABLE IF NOT EXISTS public.transactions (
+	id bigserial PRIMARY KEY,
+	block_id bigint CONSTRAINT fkey_transaction_block REFERENCES public.blocks(id),
+	hash bytea,
+	received timestamp without time zone
+);
+CREATE UNIQUE INDEX IF NOT EXISTS transaction_hash ON public.transactions USING btree (hash);
+
+CREATE TABLE IF NOT EXISTS public.outputs (
+	id bigserial PRIMARY KEY,
+	script_id bigint CONSTRAINT fkey_output_script REFERENCES public.scripts(id),
+	vout bigint,
+	value bigint,
+	created_in_tx bigint CONSTRAINT fkey_output_created_tx REFERENCES public.transactions(id),
+	spent_in_tx bigint CONSTRAINT fkey_output_spent_tx REFERENCES public.transactions(id),
+	coinbase boolean
+);
+CREATE UNIQUE INDEX IF NOT EXISTS outputs_idx_created_vout_unique ON public.outputs USING btree (created_in_tx, vout);
+CREATE INDEX IF NOT EXISTS outputs_idx_script ON public.outputs USING btree (script_id);
+CREATE INDEX IF NOT EXISTS outputs_idx_spent ON public.outputs USING btree (spent_in_tx);
+`
+
+// postgresMigrations bring a database created from database.sql, or by any
+// earlier version of the backend, up to date. Databases from before the
+// schema version was recorded get all of them, so each is safe to run
+// against a database that already has (part of) it.
+var postgresMigrations = []migration{
+	execMigration("base tables", postgresBaseTables),
+	execMigration("block header fields", `
+ALTER TABLE public.blocks ADD COLUMN IF NOT EXISTS version integer, ADD COLUMN IF NOT EXISTS prev_hash bytea, ADD COLUMN IF NOT EXISTS merkle_root bytea, ADD COLUMN IF NOT EXISTS "time" bigint, ADD COLUMN IF NOT EXISTS bits bigint, ADD COLUMN IF NOT EXISTS nonce bigint, ADD COLUMN IF NOT EXISTS tx_count integer;
+`),
+	execMigration("block difficulty", `
+ALTER TABLE public.blocks ADD COLUMN IF NOT EXISTS difficulty double precision;
+CREATE INDEX IF NOT EXISTS blocks_idx_time ON public.blocks USING btree ("time");
+`),
+	execMigration("script hashes", `
+ALTER TABLE public.scripts ADD COLUMN IF NOT EXISTS scripthash bytea;
+CREATE INDEX IF NOT EXISTS scripts_idx_scripthash ON public.scripts USING btree (scripthash);
+`),
+	execMigration("webhooks", `
+CREATE TABLE IF NOT EXISTS public.webhooks (
+	id bigserial PRIMARY KEY,
+	script bytea NOT NULL,
+	url text NOT NULL,
+	secret text NOT NULL,
+	created bigint NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhooks_idx_script ON public.webhooks USING btree (script);
+
+CREATE TABLE IF NOT EXISTS public.webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	webhook_id bigint NOT NULL REFERENCES public.webhooks(id) ON DELETE CASCADE,
+	payload bytea NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt bigint NOT NULL,
+	last_error text,
+	created bigint NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_idx_next_attempt ON public.webhook_deliveries USING btree (next_attempt);
+
+CREATE TABLE IF NOT EXISTS public.webhook_dead_letters (
+	id bigserial PRIMARY KEY,
+	webhook_id bigint NOT NULL,
+	url text NOT NULL,
+	payload bytea NOT NULL,
+	attempts integer NOT NULL,
+	last_error text,
+	created bigint NOT NULL,
+	failed bigint NOT NULL
+);
+`),
+}
+
+// postgresMigrationLock is the advisory lock that keeps processes from
+// migrating the database concurrently
+const postgresMigrationLock = 0x6f636d
+
+var postgresSchema = &schema{
+	migrations:         postgresMigrations,
+	versionTableExists: "SELECT to_regclass('public.schema_version') IS NOT NULL",
+	lock:               fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", postgresMigrationLock),
+}
+
+// NewPostgresStore connects to PostgreSQL. The database has to be migrated
+// to the schema of this version with MigratePostgres first.
+func NewPostgresStore(connStr string) (Store, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = postgresSchema.check(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	metrics.RegisterDB(db, "postgres")
+	return &sqlStore{db: db}, nil
+}
+
+// MigratePostgres creates the schema in a PostgreSQL database or upgrades
+// it to the one of this version
+func MigratePostgres(connStr string) error {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return postgresSchema.migrate(db)
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is a change to the schema of a backend. A database records the
+// number of migrations it has in the schema_version table.
+type migration struct {
+	name  string
+	apply func(tx *sql.Tx) error
+}
+
+// schema is the list of migrations of a backend, with the queries that
+// differ between backends
+type schema struct {
+	migrations []migration
+	// versionTableExists returns whether the schema_version table exists
+	versionTableExists string
+	// lock runs first in the migration transaction, to keep processes
+	// from migrating the same database concurrently. Empty when the
+	// database locks itself.
+	lock string
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// version returns the number of migrations applied to the database.
+// Databases that predate the schema_version table are at version 0, and
+// get every migration, which is why migrations have to be safe to apply
+// to a database that already has (part of) them.
+func (sc *schema) version(q queryRower) (int, error) {
+	var exists bool
+	err := q.QueryRow(sc.versionTableExists).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("Error querying schema version: %v", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	var v int
+	err = q.QueryRow("SELECT version FROM schema_version").Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error querying schema version: %v", err)
+	}
+	return v, nil
+}
+
+// check returns an error unless the database has exactly the migrations of
+// this version of the backend
+func (sc *schema) check(db *sql.DB) error {
+	v, err := sc.version(db)
+	if err != nil {
+		return err
+	}
+	if v < len(sc.migrations) {
+		return fmt.Errorf("Database schema is at version %d, this version of the backend needs version %d. Run ocm-backend migrate to upgrade it", v, len(sc.migrations))
+	}
+	if v > len(sc.migrations) {
+		return fmt.Errorf("Database schema is at version %d, which is newer than version %d this version of the backend supports", v, len(sc.migrations))
+	}
+	return nil
+}
+
+// migrate applies the migrations the database doesn't have yet, in one
+// transaction
+func (sc *schema) migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error migrating database: %v", err)
+	}
+	defer tx.Rollback()
+
+	if sc.lock != "" {
+		_, err = tx.Exec(sc.lock)
+		if err != nil {
+			return fmt.Errorf("Error locking database for migration: %v", err)
+		}
+	}
+	v, err := sc.version(tx)
+	if err != nil {
+		return err
+	}
+	if v > len(sc.migrations) {
+		return fmt.Errorf("Database schema is at version %d, which is newer than version %d this version of the backend supports", v, len(sc.migrations))
+	}
+	if v == len(sc.migrations) {
+		return nil
+	}
+
+	for i, m := range sc.migrations[v:] {
+		dbLog.Info("Migrating database", "version", v+i+1, "migration", m.name)
+		err = m.apply(tx)
+		if err != nil {
+			return fmt.Errorf("Error migrating %s: %v", m.name, err)
+		}
+	}
+	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version integer NOT NULL)")
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM schema_version")
+	}
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO schema_version (version) VALUES ($1)", len(sc.migrations))
+	}
+	if err != nil {
+		return fmt.Errorf("Error recording schema version: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Error migrating database: %v", err)
+	}
+	return nil
+}
+
+// execMigration is a migration that runs statements
+func execMigration(name, statements string) migration {
+	return migration{name: name, apply: func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+)
+
+func (s *sqlStore) ScriptByScriptHash(hash []byte) ([]byte, error) {
+	var script []byte
+	err := s.db.QueryRow("SELECT script FROM scripts WHERE scripthash=$1", hash).Scan(&script)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return script, err
+}
+
+func (s *sqlStore) IndexScriptHashes(limit int) (int64, error) {
+	rows, err := s.db.Query("SELECT id, script FROM scripts WHERE scripthash IS NULL LIMIT $1", limit)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]int64, 0)
+	hashes := make([][]byte, 0)
+	for rows.Next() {
+		var id int64
+		var script []byte
+		err = rows.Scan(&id, &script)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hash := sha256.Sum256(script)
+		ids = append(ids, id)
+		hashes = append(hashes, hash[:])
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	trx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		_, err = trx.Exec("UPDATE scripts SET scripthash=$1 WHERE id=$2", hashes[i], id)
+		if err != nil {
+			trx.Rollback()
+			return 0, err
+		}
+	}
+	err = trx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,676 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/metrics"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+// dbLog logs the queries run while indexing, which are too detailed for
+// the processor log
+var dbLog = logging.Named("db")
+
+// matureHeight selects the highest block height at which coinbase outputs
+// are spendable
+var matureHeight = fmt.Sprintf("(select height-%d from blocks order by height desc limit 1)", CoinbaseMaturity)
+
+// sqlStore implements Store on top of the schema in database.sql. The
+// queries are written so that they work on both PostgreSQL and SQLite.
+type sqlStore struct {
+	db *sql.DB
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) TipHeight() (int64, error) {
+	var height int64
+	err := s.db.QueryRow("SELECT height FROM blocks ORDER BY height DESC limit 1").Scan(&height)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return height, err
+}
+
+func (s *sqlStore) BlockHash(height int64) (*chainhash.Hash, error) {
+	var b []byte
+	err := s.db.QueryRow("SELECT hash FROM blocks WHERE height=$1", height).Scan(&b)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return chainhash.NewHash(b)
+}
+
+const blockColumns = "height, hash, version, prev_hash, merkle_root, time, bits, nonce, tx_count"
+
+func (s *sqlStore) BlockByHash(hash *chainhash.Hash) (*Block, error) {
+	return s.queryBlock("SELECT "+blockColumns+" FROM blocks WHERE hash=$1", hash.CloneBytes())
+}
+
+func (s *sqlStore) BlockByHeight(height int64) (*Block, error) {
+	return s.queryBlock("SELECT "+blockColumns+" FROM blocks WHERE height=$1", height)
+}
+
+func (s *sqlStore) TransactionBlock(hash *chainhash.Hash) (*Block, error) {
+	var blockID sql.NullInt64
+	err := s.db.QueryRow("SELECT block_id FROM transactions WHERE hash=$1", hash.CloneBytes()).Scan(&blockID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if !blockID.Valid {
+		return nil, nil
+	}
+	return s.queryBlock("SELECT "+blockColumns+" FROM blocks WHERE id=$1", blockID.Int64)
+}
+
+func (s *sqlStore) queryBlock(query string, args ...interface{}) (*Block, error) {
+	var b Block
+	var hash, prevHash, merkleRoot []byte
+	var version, blockTime, bits, nonce, txCount sql.NullInt64
+	err := s.db.QueryRow(query, args...).Scan(&b.Height, &hash, &version, &prevHash, &merkleRoot, &blockTime, &bits, &nonce, &txCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	h, err := chainhash.NewHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	b.Hash = *h
+	if prevHash != nil {
+		b.PrevHash, err = chainhash.NewHash(prevHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if merkleRoot != nil {
+		b.MerkleRoot, err = chainhash.NewHash(merkleRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if blockTime.Valid {
+		t := time.Unix(blockTime.Int64, 0)
+		b.Time = &t
+	}
+	b.Version = int32(version.Int64)
+	b.Bits = uint32(bits.Int64)
+	b.Nonce = uint32(nonce.Int64)
+	b.TxCount = txCount.Int64
+	return &b, nil
+}
+
+func (s *sqlStore) BlockStatsByHeight(from, to int64) ([]BlockStat, error) {
+	return s.queryBlockStats("SELECT height, time, bits, difficulty FROM blocks WHERE height >= $1 AND height <= $2 AND time IS NOT NULL ORDER BY height", from, to)
+}
+
+func (s *sqlStore) BlockStatsByTime(from, to time.Time) ([]BlockStat, error) {
+	return s.queryBlockStats("SELECT height, time, bits, difficulty FROM blocks WHERE time >= $1 AND time <= $2 ORDER BY height", from.Unix(), to.Unix())
+}
+
+func (s *sqlStore) queryBlockStats(query string, args ...interface{}) ([]BlockStat, error) {
+	result := make([]BlockStat, 0)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st BlockStat
+		var blockTime, bits int64
+		var difficulty sql.NullFloat64
+		err = rows.Scan(&st.Height, &blockTime, &bits, &difficulty)
+		if err != nil {
+			return nil, err
+		}
+		st.Time = time.Unix(blockTime, 0)
+		st.Difficulty = difficulty.Float64
+		if !difficulty.Valid {
+			st.Difficulty = vertcoin.BitsToDifficulty(uint32(bits))
+		}
+		result = append(result, st)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) InsertBlock(height int64, blk *wire.MsgBlock) error {
+	defer metrics.ObservePhase("total", time.Now())
+
+	// Start batch
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var blockID int64
+	bh := blk.BlockHash()
+
+	hdr := blk.Header
+	err = tx.QueryRow("INSERT INTO blocks(hash, height, version, prev_hash, merkle_root, time, bits, nonce, tx_count, difficulty) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id",
+		(&bh).CloneBytes(), height, hdr.Version, hdr.PrevBlock.CloneBytes(), hdr.MerkleRoot.CloneBytes(), hdr.Timestamp.Unix(), int64(hdr.Bits), int64(hdr.Nonce), len(blk.Transactions), vertcoin.BitsToDifficulty(hdr.Bits)).Scan(&blockID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to insert block: %v", err)
+	}
+
+	start := time.Now()
+	txIDs, err := s.getTransactionIDsForBlock(tx, blockID, blk)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to query txids for block: %v", err)
+	}
+	dbLog.Debug("Queried transaction ids for block", "height", height, "durationUs", time.Since(start).Microseconds())
+	metrics.ObservePhase("transaction_ids", start)
+
+	start = time.Now()
+	scriptIDs, err := s.getScriptIDs(tx, blk.Transactions)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to query script ids for block: %v", err)
+	}
+	dbLog.Debug("Queried script ids for block", "height", height, "durationUs", time.Since(start).Microseconds())
+	metrics.ObservePhase("script_ids", start)
+
+	txsStart := time.Now()
+	for i, t := range blk.Transactions {
+		start = time.Now()
+		err = s.processTransaction(tx, txIDs, scriptIDs, t)
+		dbLog.Debug("Processed transaction", "height", height, "index", i, "durationUs", time.Since(start).Microseconds())
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Unable to process transaction %v: %v", t.TxHash(), err)
+		}
+	}
+	metrics.ObservePhase("transactions", txsStart)
+
+	start = time.Now()
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Unable to commit block: %v", err)
+	}
+	metrics.ObservePhase("commit", start)
+	return nil
+}
+
+func (s *sqlStore) RevertBlocks(height int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE outputs SET spent_in_tx=NULL WHERE spent_in_tx IN (SELECT t.id FROM transactions t INNER JOIN blocks b ON b.id=t.block_id WHERE b.height > $1)", height)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error marking outputs unspent: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM outputs WHERE created_in_tx IN (SELECT t.id FROM transactions t INNER JOIN blocks b ON b.id=t.block_id WHERE b.height > $1)", height)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error removing outputs: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM transactions WHERE block_id IN (SELECT id FROM blocks WHERE height > $1)", height)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error removing transactions: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM blocks WHERE height > $1", height)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error removing blocks: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) AddUnconfirmedTransaction(tx *wire.MsgTx) error {
+	txHash := tx.TxHash()
+	trx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var transID int64
+	var blockID sql.NullInt64
+	err = trx.QueryRow("SELECT id, block_id FROM transactions WHERE hash=$1", txHash.CloneBytes()).Scan(&transID, &blockID)
+	if err == sql.ErrNoRows {
+		err = trx.QueryRow("INSERT INTO transactions(hash, received) VALUES ($1, CURRENT_TIMESTAMP) RETURNING id", txHash.CloneBytes()).Scan(&transID)
+		if err != nil {
+			trx.Rollback()
+			return fmt.Errorf("Error inserting transaction: %v", err)
+		}
+	} else if err != nil {
+		trx.Rollback()
+		return fmt.Errorf("Error querying transaction: %v", err)
+	} else if blockID.Valid {
+		// Already confirmed, nothing to do
+		trx.Rollback()
+		return nil
+	} else {
+		_, err = trx.Exec("UPDATE transactions SET received=CURRENT_TIMESTAMP WHERE id=$1 AND received IS NULL", transID)
+		if err != nil {
+			trx.Rollback()
+			return fmt.Errorf("Error updating transaction: %v", err)
+		}
+	}
+
+	transactionsSpent := make([]*chainhash.Hash, 0)
+	for _, i := range tx.TxIn {
+		transactionsSpent = append(transactionsSpent, &i.PreviousOutPoint.Hash)
+	}
+	err = s.ensureTransactionsInserted(trx, transactionsSpent)
+	if err != nil {
+		trx.Rollback()
+		return fmt.Errorf("Error inserting spent transactions: %v", err)
+	}
+	txIDs, err := s.queryTransactionIDs(trx, transactionsSpent)
+	if err != nil {
+		trx.Rollback()
+		return fmt.Errorf("Error getting spent transaction IDs: %v", err)
+	}
+	txIDs[hex.EncodeToString(txHash.CloneBytes())] = transID
+
+	scriptIDs, err := s.getScriptIDs(trx, []*wire.MsgTx{tx})
+	if err != nil {
+		trx.Rollback()
+		return fmt.Errorf("Error getting script IDs: %v", err)
+	}
+
+	err = s.processTransaction(trx, txIDs, scriptIDs, tx)
+	if err != nil {
+		trx.Rollback()
+		return err
+	}
+
+	return trx.Commit()
+}
+
+func (s *sqlStore) UnconfirmedTransactions() ([]*chainhash.Hash, error) {
+	return s.queryHashes("SELECT hash FROM transactions WHERE block_id IS NULL AND received IS NOT NULL")
+}
+
+func (s *sqlStore) UnconfirmedTransactionsReceivedBefore(t time.Time) ([]*chainhash.Hash, error) {
+	return s.queryHashes("SELECT hash FROM transactions WHERE block_id IS NULL AND received < $1", t.UTC())
+}
+
+func (s *sqlStore) queryHashes(query string, args ...interface{}) ([]*chainhash.Hash, error) {
+	result := make([]*chainhash.Hash, 0)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		err = rows.Scan(&b)
+		if err != nil {
+			return nil, err
+		}
+		h, err := chainhash.NewHash(b)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, h)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) RemoveUnconfirmedTransactions(hashes []*chainhash.Hash) error {
+	trx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hashes {
+		var transID int64
+		err = trx.QueryRow("SELECT id FROM transactions WHERE hash=$1 AND block_id IS NULL", h.CloneBytes()).Scan(&transID)
+		if err == sql.ErrNoRows {
+			// Confirmed in the meantime
+			continue
+		}
+		if err != nil {
+			trx.Rollback()
+			return fmt.Errorf("Error querying transaction %s: %v", h.String(), err)
+		}
+
+		_, err = trx.Exec("UPDATE outputs SET spent_in_tx=NULL WHERE spent_in_tx=$1", transID)
+		if err != nil {
+			trx.Rollback()
+			return fmt.Errorf("Error marking outputs unspent: %v", err)
+		}
+
+		_, err = trx.Exec("DELETE FROM outputs WHERE created_in_tx=$1", transID)
+		if err != nil {
+			trx.Rollback()
+			return fmt.Errorf("Error removing outputs: %v", err)
+		}
+
+		_, err = trx.Exec("DELETE FROM transactions WHERE id=$1", transID)
+		if err != nil {
+			trx.Rollback()
+			return fmt.Errorf("Error removing transaction: %v", err)
+		}
+	}
+
+	return trx.Commit()
+}
+
+func (s *sqlStore) scriptID(script []byte) (int64, error) {
+	var scriptID int64
+	err := s.db.QueryRow("select id from scripts where script=$1", script).Scan(&scriptID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return scriptID, err
+}
+
+func (s *sqlStore) Balance(script []byte) (Balance, error) {
+	var b Balance
+	scriptID, err := s.scriptID(script)
+	if err != nil {
+		if err == ErrNotFound {
+			return b, nil
+		}
+		return b, fmt.Errorf("Error querying script: %v", err)
+	}
+
+	err = s.db.QueryRow("select coalesce(sum(value),0) from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where script_id=$1 AND t.block_id IS NOT NULL AND (coinbase=true and b.height > "+matureHeight+") AND spent_in_tx IS NULL", scriptID).Scan(&b.Maturing)
+	if err != nil {
+		return b, fmt.Errorf("Error querying immature balance: %v", err)
+	}
+	err = s.db.QueryRow("select coalesce(sum(value),0) from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where script_id=$1 AND t.block_id IS NOT NULL AND (coinbase=false or b.height <= "+matureHeight+") AND spent_in_tx IS NULL", scriptID).Scan(&b.Confirmed)
+	if err != nil {
+		return b, fmt.Errorf("Error querying confirmed balance: %v", err)
+	}
+	err = s.db.QueryRow("select coalesce(sum(value),0) from outputs o inner join transactions t on t.id=o.created_in_tx where script_id=$1 AND t.block_id IS NULL AND spent_in_tx IS NULL", scriptID).Scan(&b.Unconfirmed)
+	if err != nil {
+		return b, fmt.Errorf("Error querying unconfirmed balance: %v", err)
+	}
+	return b, nil
+}
+
+func (s *sqlStore) Utxos(script []byte) ([]Utxo, error) {
+	result := make([]Utxo, 0)
+	scriptID, err := s.scriptID(script)
+	if err != nil {
+		if err == ErrNotFound {
+			return result, nil
+		}
+		return nil, fmt.Errorf("Error querying script: %v", err)
+	}
+
+	rows, err := s.db.Query("select t.hash, o.vout, o.value, b.height from outputs o left join transactions t on t.id=o.created_in_tx left join blocks b on b.id=t.block_id where script_id=$1 AND t.block_id IS NOT NULL AND (coinbase=false or b.height <= "+matureHeight+") AND spent_in_tx IS NULL", scriptID)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying utxos: %v", err)
+	}
+	return scanUtxos(rows, result)
+}
+
+func (s *sqlStore) UnconfirmedUtxos(script []byte) ([]Utxo, error) {
+	result := make([]Utxo, 0)
+	scriptID, err := s.scriptID(script)
+	if err != nil {
+		if err == ErrNotFound {
+			return result, nil
+		}
+		return nil, fmt.Errorf("Error querying script: %v", err)
+	}
+
+	rows, err := s.db.Query("select t.hash, o.vout, o.value, 0 from outputs o inner join transactions t on t.id=o.created_in_tx where script_id=$1 AND t.block_id IS NULL AND spent_in_tx IS NULL", scriptID)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying unconfirmed utxos: %v", err)
+	}
+	return scanUtxos(rows, result)
+}
+
+// scanUtxos appends the utxos in rows of hash, vout, value and height to
+// result, and closes rows
+func scanUtxos(rows *sql.Rows, result []Utxo) ([]Utxo, error) {
+	defer rows.Close()
+	for rows.Next() {
+		var txid []byte
+		var u Utxo
+		err := rows.Scan(&txid, &u.Vout, &u.Value, &u.Height)
+		if err != nil {
+			dbLog.Warn("Error scanning utxo row", "err", err)
+			continue
+		}
+		h, err := chainhash.NewHash(txid)
+		if err != nil {
+			dbLog.Warn("Utxo has invalid tx hash", "err", err)
+			continue
+		}
+		u.TxHash = *h
+		result = append(result, u)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) processTransaction(trx *sql.Tx, txIDs map[string]int64, scriptIDs map[string]int64, tx *wire.MsgTx) error {
+	txHash := tx.TxHash()
+	transID, ok := txIDs[hex.EncodeToString(txHash.CloneBytes())]
+	if !ok {
+		return errors.New("Transaction ID was not inserted")
+	}
+
+	err := s.markOutputsSpent(trx, transID, tx, txIDs)
+	if err != nil {
+		return err
+	}
+
+	return s.createOutputs(trx, transID, tx, scriptIDs)
+}
+
+func (s *sqlStore) createOutputs(trx *sql.Tx, transID int64, tx *wire.MsgTx, scriptIDs map[string]int64) error {
+	var sqlParamBuf bytes.Buffer
+	sqlParams := make([]interface{}, 0)
+	sql := "INSERT INTO outputs(script_id, created_in_tx, vout, value, coinbase) VALUES %s ON CONFLICT (created_in_tx, vout) DO NOTHING"
+	for idx, o := range tx.TxOut {
+		scriptID, ok := scriptIDs[hex.EncodeToString(o.PkScript)]
+		if !ok {
+			panic("Did not find script in built array - this should never happen")
+		}
+		if idx > 0 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		io.WriteString(&sqlParamBuf, "(")
+		for c := 1; c <= 5; c++ {
+			if c > 1 {
+				io.WriteString(&sqlParamBuf, ",")
+			}
+			io.WriteString(&sqlParamBuf, fmt.Sprintf("$%d", (idx*5)+c))
+		}
+		io.WriteString(&sqlParamBuf, ")")
+		sqlParams = append(sqlParams, scriptID, transID, idx, o.Value, isCoinbase(tx))
+	}
+	start := time.Now()
+	_, err := trx.Exec(fmt.Sprintf(sql, string(sqlParamBuf.Bytes())), sqlParams...)
+	if err != nil {
+		return fmt.Errorf("Error inserting outputs: %v", err)
+	}
+	dbLog.Debug("Inserted outputs", "count", len(tx.TxOut), "durationUs", time.Since(start).Microseconds())
+	return nil
+}
+
+func (s *sqlStore) markOutputsSpent(trx *sql.Tx, transID int64, tx *wire.MsgTx, txIDs map[string]int64) error {
+	var sqlParamBuf bytes.Buffer
+	sqlParams := []interface{}{transID}
+	sql := "UPDATE outputs SET spent_in_tx=$1 WHERE (created_in_tx,vout) IN (%s)"
+	idx := 2
+
+	for _, i := range tx.TxIn {
+		if idx > 2 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		io.WriteString(&sqlParamBuf, fmt.Sprintf("($%d,$%d)", idx, idx+1))
+		spentTx, ok := txIDs[hex.EncodeToString((&i.PreviousOutPoint.Hash).CloneBytes())]
+		if !ok {
+			return errors.New("Transaction ID was not found")
+		}
+		sqlParams = append(sqlParams, spentTx, i.PreviousOutPoint.Index)
+		idx += 2
+	}
+	sql = fmt.Sprintf(sql, string(sqlParamBuf.Bytes()))
+	start := time.Now()
+	_, err := trx.Exec(sql, sqlParams...)
+	if err != nil {
+		return fmt.Errorf("Error updating spent outputs: %v", err)
+	}
+	dbLog.Debug("Updated spent outputs", "count", len(tx.TxIn), "durationUs", time.Since(start).Microseconds())
+	return nil
+}
+
+func (s *sqlStore) getScriptIDs(trx *sql.Tx, txs []*wire.MsgTx) (map[string]int64, error) {
+	result := map[string]int64{}
+	var sqlParamBuf bytes.Buffer
+	var sqlParamBuf2 bytes.Buffer
+	sqlParams := make([]interface{}, 0)
+	sqlParams2 := make([]interface{}, 0)
+	sql := "INSERT INTO scripts(script, scripthash) VALUES %s ON CONFLICT(script) DO NOTHING"
+	sql2 := "SELECT id, script FROM scripts WHERE script in (%s)"
+	idx := 0
+	for _, tx := range txs {
+		for _, o := range tx.TxOut {
+			idx++
+			if idx > 1 {
+				io.WriteString(&sqlParamBuf, ",")
+				io.WriteString(&sqlParamBuf2, ",")
+			}
+			io.WriteString(&sqlParamBuf, fmt.Sprintf("($%d,$%d)", idx*2-1, idx*2))
+			io.WriteString(&sqlParamBuf2, fmt.Sprintf("$%d", idx))
+			scriptHash := sha256.Sum256(o.PkScript)
+			sqlParams = append(sqlParams, o.PkScript, scriptHash[:])
+			sqlParams2 = append(sqlParams2, o.PkScript)
+		}
+	}
+	sql = fmt.Sprintf(sql, string(sqlParamBuf.Bytes()))
+	sql2 = fmt.Sprintf(sql2, string(sqlParamBuf2.Bytes()))
+	_, err := trx.Exec(sql, sqlParams...)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := trx.Query(sql2, sqlParams2...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash []byte
+		var id int64
+		err := rows.Scan(&id, &hash)
+		if err == nil {
+			result[hex.EncodeToString(hash)] = id
+		}
+	}
+	return result, nil
+}
+
+func (s *sqlStore) getTransactionIDsForBlock(trx *sql.Tx, blockID int64, blk *wire.MsgBlock) (map[string]int64, error) {
+	transactionsCreatedInBlock := make([]*chainhash.Hash, 0)
+	transactionsSpentInBlock := make([]*chainhash.Hash, 0)
+	for _, tx := range blk.Transactions {
+		for _, i := range tx.TxIn {
+			transactionsSpentInBlock = append(transactionsSpentInBlock, &i.PreviousOutPoint.Hash)
+		}
+		txHash := tx.TxHash()
+		transactionsCreatedInBlock = append(transactionsCreatedInBlock, &txHash)
+	}
+	err := s.ensureTransactionsInserted(trx, append(transactionsCreatedInBlock, transactionsSpentInBlock...))
+	if err != nil {
+		return map[string]int64{}, fmt.Errorf("Error occured during EnsureTransactionsInserted: %v", err)
+	}
+	err = s.setBlockIDForTransactions(trx, blockID, transactionsCreatedInBlock)
+	if err != nil {
+		return map[string]int64{}, fmt.Errorf("Error occured during SetBlockIDForTransactions: %v", err)
+	}
+
+	results, err := s.queryTransactionIDs(trx, append(transactionsCreatedInBlock, transactionsSpentInBlock...))
+	if err != nil {
+		return map[string]int64{}, fmt.Errorf("Error occured during QueryTransactionIDs: %v", err)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) setBlockIDForTransactions(trx *sql.Tx, blockID int64, hashes []*chainhash.Hash) error {
+	var sqlParamBuf bytes.Buffer
+	sqlParams := []interface{}{blockID}
+	sql := "UPDATE transactions SET block_id=$1 WHERE hash in (%s)"
+	for idx, h := range hashes {
+		if idx > 0 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		io.WriteString(&sqlParamBuf, fmt.Sprintf("$%d", idx+2))
+		sqlParams = append(sqlParams, h.CloneBytes())
+	}
+	sql = fmt.Sprintf(sql, string(sqlParamBuf.Bytes()))
+	_, err := trx.Exec(sql, sqlParams...)
+	return err
+}
+
+func (s *sqlStore) ensureTransactionsInserted(trx *sql.Tx, hashes []*chainhash.Hash) error {
+	var sqlParamBuf bytes.Buffer
+	sqlParams := make([]interface{}, 0)
+	sql := "INSERT INTO transactions(hash) VALUES %s ON CONFLICT(hash) DO NOTHING"
+	for idx, h := range hashes {
+		if idx > 0 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		io.WriteString(&sqlParamBuf, fmt.Sprintf("($%d)", idx+1))
+		sqlParams = append(sqlParams, h.CloneBytes())
+	}
+	sql = fmt.Sprintf(sql, string(sqlParamBuf.Bytes()))
+	_, err := trx.Exec(sql, sqlParams...)
+	return err
+}
+
+func (s *sqlStore) queryTransactionIDs(trx *sql.Tx, hashes []*chainhash.Hash) (map[string]int64, error) {
+	result := map[string]int64{}
+	var sqlParamBuf bytes.Buffer
+	sqlParams := make([]interface{}, 0)
+	sql := "SELECT id, hash FROM transactions WHERE hash in (%s)"
+	for idx, h := range hashes {
+		if idx > 0 {
+			io.WriteString(&sqlParamBuf, ",")
+		}
+		io.WriteString(&sqlParamBuf, fmt.Sprintf("$%d", idx+1))
+		sqlParams = append(sqlParams, h.CloneBytes())
+	}
+	sql = fmt.Sprintf(sql, string(sqlParamBuf.Bytes()))
+	rows, err := trx.Query(sql, sqlParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash []byte
+		var id int64
+		err := rows.Scan(&id, &hash)
+		if err == nil {
+			result[hex.EncodeToString(hash)] = id
+		}
+	}
+	return result, nil
+}
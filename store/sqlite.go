@@ -0,0 +1,200 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/gertjaap/ocm-backend/metrics"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteTables is the equivalent of database.sql for SQLite, including
+// everything that was added to it later
+const sqliteTables = `
+CREATE TABLE IF NOT EXISTS blocks (
+	id INTEGER PRIMARY KEY,
+	height INTEGER,
+	hash BLOB,
+	version INTEGER,
+	prev_hash BLOB,
+	merkle_root BLOB,
+	time INTEGER,
+	bits INTEGER,
+	nonce INTEGER,
+	tx_count INTEGER,
+	difficulty REAL
+);
+CREATE INDEX IF NOT EXISTS blocks_idx_hash ON blocks (hash);
+CREATE INDEX IF NOT EXISTS blocks_idx_height ON blocks (height DESC);
+
+CREATE TABLE IF NOT EXISTS scripts (
+	id INTEGER PRIMARY KEY,
+	script BLOB,
+	scripthash BLOB
+);
+CREATE UNIQUE INDEX IF NOT EXISTS scripts_idx_script ON scripts (script);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id INTEGER PRIMARY KEY,
+	block_id INTEGER REFERENCES blocks(id),
+	hash BLOB,
+	received TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS transaction_hash ON transactions (hash);
+
+CREATE TABLE IF NOT EXISTS outputs (
+	id INTEGER PRIMARY KEY,
+	script_id INTEGER REFERENCES scripts(id),
+	vout INTEGER,
+	value INTEGER,
+	created_in_tx INTEGER REFERENCES transactions(id),
+	spent_in_tx INTEGER REFERENCES transactions(id),
+	coinbase BOOLEAN
+);
+CREATE UNIQUE INDEX IF NOT EXISTS outputs_idx_created_vout_unique ON outputs (created_in_tx, vout);
+CREATE INDEX IF NOT EXISTS outputs_idx_script ON outputs (script_id);
+CREATE INDEX IF NOT EXISTS outputs_idx_spent ON outputs (spent_in_tx);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY,
+	script BLOB NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	created INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhooks_idx_script ON webhooks (script);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	payload BLOB NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL,
+	last_error TEXT,
+	created INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_idx_next_attempt ON webhook_deliveries (next_attempt);
+
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+	id INTEGER PRIMARY KEY,
+	webhook_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	payload BLOB NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT,
+	created INTEGER NOT NULL,
+	failed INTEGER NOT NULL
+);
+`
+
+// sqliteMigrations bring a database created by any earlier version of the
+// backend up to date. The first creates everything a new database needs;
+// the others add what older databases miss.
+var sqliteMigrations = []migration{
+	execMigration("schema", sqliteTables),
+	{name: "block header fields", apply: func(tx *sql.Tx) error {
+		err := addSQLiteColumns(tx, "blocks", []string{"version INTEGER", "prev_hash BLOB", "merkle_root BLOB", "time INTEGER", "bits INTEGER", "nonce INTEGER", "tx_count INTEGER", "difficulty REAL"})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX IF NOT EXISTS blocks_idx_time ON blocks (time)")
+		return err
+	}},
+	{name: "script hashes", apply: func(tx *sql.Tx) error {
+		err := addSQLiteColumns(tx, "scripts", []string{"scripthash BLOB"})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX IF NOT EXISTS scripts_idx_scripthash ON scripts (scripthash)")
+		return err
+	}},
+}
+
+// sqliteSchema needs no lock, the migration transaction is the only writer
+// SQLite allows
+var sqliteSchema = &schema{
+	migrations:         sqliteMigrations,
+	versionTableExists: "SELECT count(*) > 0 FROM sqlite_master WHERE type='table' AND name='schema_version'",
+}
+
+// NewSQLiteStore opens the SQLite database at path, which has to be
+// migrated to the schema of this version with MigrateSQLite first. Options
+// for the driver can be appended as a query string.
+func NewSQLiteStore(path string) (Store, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	err = sqliteSchema.check(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	metrics.RegisterDB(db, "sqlite")
+	return &sqlStore{db: db}, nil
+}
+
+// MigrateSQLite creates the SQLite database at path if needed, and brings
+// its schema up to the one of this version
+func MigrateSQLite(path string) error {
+	db, err := openSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return sqliteSchema.migrate(db)
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path
+	if strings.Contains(path, "?") {
+		dsn += "&"
+	} else {
+		dsn += "?"
+	}
+	dsn += "_foreign_keys=on&_journal_mode=WAL&_busy_timeout=10000"
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time, so serialize access instead
+	// of having writers fail on a locked database
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// addSQLiteColumns adds the columns that were introduced after a database
+// was created. SQLite has no ADD COLUMN IF NOT EXISTS.
+func addSQLiteColumns(tx *sql.Tx, table string, columns []string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		err = rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, c := range columns {
+		name := strings.Fields(c)[0]
+		if existing[name] {
+			continue
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, c))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
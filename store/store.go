@@ -0,0 +1,285 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// CoinbaseMaturity is the number of confirmations a coinbase output needs
+// before it can be spent.
+const CoinbaseMaturity = 101
+
+var ErrNotFound = errors.New("Not found")
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+type Balance struct {
+	Confirmed   int64
+	Maturing    int64
+	Unconfirmed int64
+}
+
+type Utxo struct {
+	TxHash chainhash.Hash
+	Vout   int64
+	Value  int64
+	Height int64
+}
+
+// Block is an indexed block with its header fields. Time is nil and the
+// other header fields are zero for blocks that were indexed before header
+// fields were stored.
+type Block struct {
+	Height     int64
+	Hash       chainhash.Hash
+	Version    int32
+	PrevHash   *chainhash.Hash
+	MerkleRoot *chainhash.Hash
+	Time       *time.Time
+	Bits       uint32
+	Nonce      uint32
+	TxCount    int64
+}
+
+// Header returns the header of the block, or nil when it was indexed
+// before header fields were stored
+func (b *Block) Header() *wire.BlockHeader {
+	if b.Time == nil || b.PrevHash == nil || b.MerkleRoot == nil {
+		return nil
+	}
+	return &wire.BlockHeader{
+		Version:    b.Version,
+		PrevBlock:  *b.PrevHash,
+		MerkleRoot: *b.MerkleRoot,
+		Timestamp:  *b.Time,
+		Bits:       b.Bits,
+		Nonce:      b.Nonce,
+	}
+}
+
+// BlockStat is the time and difficulty of a block, used for charts
+type BlockStat struct {
+	Height     int64
+	Time       time.Time
+	Difficulty float64
+}
+
+// HistoryEntry is a transaction that created or spent outputs of a
+// script. Value is the net change of the balance of the script.
+type HistoryEntry struct {
+	TxHash    chainhash.Hash
+	Confirmed bool
+	Height    int64
+	BlockHash *chainhash.Hash
+	BlockTime *time.Time
+	Value     int64
+}
+
+// Webhook is a script that is watched, and the URL that is called when it
+// receives or spends funds
+type Webhook struct {
+	ID      int64
+	Script  []byte
+	URL     string
+	Secret  string
+	Created time.Time
+}
+
+// WebhookActivity is an output of a watched script that was created or
+// spent in a block. TxHash is the transaction that created or spent it.
+type WebhookActivity struct {
+	WebhookID    int64
+	Script       []byte
+	Spent        bool
+	TxHash       chainhash.Hash
+	OutputTxHash chainhash.Hash
+	Vout         int64
+	Value        int64
+}
+
+// WebhookDelivery is a payload waiting to be delivered to a webhook
+type WebhookDelivery struct {
+	ID          int64
+	WebhookID   int64
+	URL         string
+	Secret      string
+	Payload     []byte
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+}
+
+// WebhookDeadLetter is a delivery that was given up on
+type WebhookDeadLetter struct {
+	ID        int64
+	WebhookID int64
+	URL       string
+	Payload   []byte
+	Attempts  int
+	LastError string
+	Created   time.Time
+	Failed    time.Time
+}
+
+// Store is the storage used by the indexer and the API. Every method is
+// atomic: it either applies completely or not at all. It is made up of
+// smaller interfaces, so a consumer can depend on (and a test can fake)
+// only the part it uses.
+type Store interface {
+	ChainStore
+	MempoolStore
+	ScriptStore
+	WebhookStore
+
+	// Verify runs consistency checks on the indexed data and returns a
+	// description of every problem it found.
+	Verify() ([]string, error)
+
+	Close() error
+}
+
+// ChainStore holds the indexed blocks, as used by the processor
+type ChainStore interface {
+	// TipHeight returns the height of the last indexed block, or
+	// ErrNotFound when no block has been indexed yet.
+	TipHeight() (int64, error)
+	// BlockHash returns the hash of the indexed block at height, or
+	// ErrNotFound when there is none.
+	BlockHash(height int64) (*chainhash.Hash, error)
+	// BlockByHash and BlockByHeight return an indexed block, or
+	// ErrNotFound when there is none.
+	BlockByHash(hash *chainhash.Hash) (*Block, error)
+	BlockByHeight(height int64) (*Block, error)
+	// TransactionBlock returns the block a transaction was confirmed in,
+	// nil when it is unconfirmed, or ErrNotFound when the transaction is
+	// unknown.
+	TransactionBlock(hash *chainhash.Hash) (*Block, error)
+	// BlockStatsByHeight and BlockStatsByTime return the stats of the
+	// indexed blocks in a range (inclusive), ordered by height. Blocks that
+	// were indexed before their header was stored are left out.
+	BlockStatsByHeight(from, to int64) ([]BlockStat, error)
+	BlockStatsByTime(from, to time.Time) ([]BlockStat, error)
+	// InsertBlock indexes blk at height: it stores its header, ensures all its transactions
+	// and the transactions it spends from are known, creates its outputs
+	// and marks the outputs it spends.
+	InsertBlock(height int64, blk *wire.MsgBlock) error
+	// RevertBlocks removes all blocks above height, together with the
+	// transactions and outputs they created, and marks the outputs they
+	// spent as unspent again.
+	RevertBlocks(height int64) error
+}
+
+// MempoolStore holds the transactions that are not yet in a block
+type MempoolStore interface {
+	// AddUnconfirmedTransaction records a transaction that was accepted
+	// by vertcoind but is not yet in a block: it creates its outputs and
+	// marks the outputs it spends. When the transaction gets confirmed,
+	// InsertBlock attaches it to its block.
+	AddUnconfirmedTransaction(tx *wire.MsgTx) error
+	// UnconfirmedTransactions returns the hashes of all transactions
+	// added through AddUnconfirmedTransaction that are not yet confirmed.
+	UnconfirmedTransactions() ([]*chainhash.Hash, error)
+	// UnconfirmedTransactionsReceivedBefore returns the hashes of the
+	// unconfirmed transactions that were added before t.
+	UnconfirmedTransactionsReceivedBefore(t time.Time) ([]*chainhash.Hash, error)
+	// RemoveUnconfirmedTransactions undoes AddUnconfirmedTransaction for
+	// transactions that were dropped from the mempool. Transactions that
+	// got confirmed in the meantime are left alone.
+	RemoveUnconfirmedTransactions(hashes []*chainhash.Hash) error
+}
+
+// ScriptStore looks up the funds and history of scripts
+type ScriptStore interface {
+	Balance(script []byte) (Balance, error)
+	Utxos(script []byte) ([]Utxo, error)
+	// UnconfirmedUtxos returns the unspent outputs of a script created by
+	// unconfirmed transactions, which Utxos leaves out. Their height is 0.
+	UnconfirmedUtxos(script []byte) ([]Utxo, error)
+	// Balances and UtxosForScripts look up many scripts at once. The
+	// results are in the same order as scripts.
+	Balances(scripts [][]byte) ([]Balance, error)
+	UtxosForScripts(scripts [][]byte) ([][]Utxo, error)
+	// ScriptByScriptHash returns the script whose sha256 is hash, or
+	// ErrNotFound when no output ever paid to it.
+	ScriptByScriptHash(hash []byte) ([]byte, error)
+	// IndexScriptHashes stores the sha256 of scripts that were indexed
+	// before script hashes were stored, in batches of at most limit
+	// scripts. It returns the number of scripts it updated, which is zero
+	// once all scripts have their hash.
+	IndexScriptHashes(limit int) (int64, error)
+	// KnownScripts reports for each script whether it ever received an
+	// output, in the same order as scripts.
+	KnownScripts(scripts [][]byte) ([]bool, error)
+	// History returns the transactions of a script, newest first, starting
+	// after cursor (or at the newest when cursor is empty). It returns at
+	// most limit entries and the cursor for the next page, which is empty
+	// when there are no more entries.
+	History(script []byte, cursor string, limit int) ([]HistoryEntry, string, error)
+}
+
+// WebhookStore holds the webhooks and their deliveries
+type WebhookStore interface {
+	AddWebhook(script []byte, url, secret string) (*Webhook, error)
+	Webhooks() ([]Webhook, error)
+	// DeleteWebhook removes a webhook and its pending deliveries, or
+	// returns ErrNotFound when there is no webhook with the id.
+	DeleteWebhook(id int64) error
+	// WebhookActivity returns the outputs of watched scripts that were
+	// created or spent in the block at height.
+	WebhookActivity(height int64) ([]WebhookActivity, error)
+	// EnqueueWebhookDeliveries stores deliveries (only WebhookID and
+	// Payload are used) to be attempted right away.
+	EnqueueWebhookDeliveries(deliveries []WebhookDelivery) error
+	// DueWebhookDeliveries returns up to limit deliveries whose next
+	// attempt is due at now.
+	DueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	CompleteWebhookDelivery(id int64) error
+	// RetryWebhookDelivery records a failed attempt and when to try again
+	RetryWebhookDelivery(id int64, next time.Time, lastError string) error
+	// DeadLetterWebhookDelivery moves a delivery that failed for the last
+	// time to the dead letters.
+	DeadLetterWebhookDelivery(id int64, lastError string) error
+	// WebhookDeadLetters returns the most recent dead letters
+	WebhookDeadLetters(limit int) ([]WebhookDeadLetter, error)
+}
+
+// Open opens the store for a connection string. The scheme of the
+// connection string selects the backend. It fails when the schema of the
+// database is not the one of this version, which Migrate brings it to.
+func Open(connStr string) (Store, error) {
+	switch {
+	case strings.HasPrefix(connStr, "sqlite://"):
+		return NewSQLiteStore(strings.TrimPrefix(connStr, "sqlite://"))
+	case strings.HasPrefix(connStr, "postgres://"), strings.HasPrefix(connStr, "postgresql://"):
+		return NewPostgresStore(connStr)
+	default:
+		// Plain key=value connection strings are understood by lib/pq
+		return NewPostgresStore(connStr)
+	}
+}
+
+// Migrate creates the database for a connection string, or brings its
+// schema up to the one of this version. It is safe to run against a
+// database that is up to date.
+func Migrate(connStr string) error {
+	switch {
+	case strings.HasPrefix(connStr, "sqlite://"):
+		return MigrateSQLite(strings.TrimPrefix(connStr, "sqlite://"))
+	default:
+		return MigratePostgres(connStr)
+	}
+}
+
+func isCoinbase(tx *wire.MsgTx) bool {
+	if tx.TxIn[0].PreviousOutPoint.Index != 0xFFFFFFFF {
+		return false
+	}
+	if (&tx.TxIn[0].PreviousOutPoint.Hash).String() != "0000000000000000000000000000000000000000000000000000000000000000" {
+		return false
+	}
+	return true
+}
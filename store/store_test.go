@@ -0,0 +1,619 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// postgresTestEnv holds the connection string of the PostgreSQL database
+// the tests also run against. Every table in it is dropped first.
+const postgresTestEnv = "OCM_BACKEND_TEST_POSTGRES"
+
+const dropTables = `DROP TABLE IF EXISTS schema_version, webhook_dead_letters, webhook_deliveries, webhooks, outputs, transactions, scripts, blocks CASCADE`
+
+var (
+	scriptMiner = p2pkh(0x01)
+	scriptA     = p2pkh(0x02)
+	scriptB     = p2pkh(0x03)
+)
+
+// forEachStore runs test against an empty SQLite store, and against an
+// empty PostgreSQL store when postgresTestEnv is set
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		err := MigrateSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		test(t, s)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		connStr := os.Getenv(postgresTestEnv)
+		if connStr == "" {
+			t.Skip(postgresTestEnv + " is not set")
+		}
+		dropPostgresTables(t, connStr)
+		err := MigratePostgres(connStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewPostgresStore(connStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		test(t, s)
+	})
+}
+
+func dropPostgresTables(t *testing.T, connStr string) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(dropTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func p2pkh(b byte) []byte {
+	script := []byte{0x76, 0xa9, 0x14}
+	script = append(script, bytes.Repeat([]byte{b}, 20)...)
+	return append(script, 0x88, 0xac)
+}
+
+func coinbaseTx(height int64, script []byte, value int64) *wire.MsgTx {
+	tx := wire.NewMsgTx(1)
+	sigScript := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sigScript, uint64(height))
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0xFFFFFFFF), sigScript, nil))
+	tx.AddTxOut(wire.NewTxOut(value, script))
+	return tx
+}
+
+func spendTx(from []*wire.OutPoint, outs ...*wire.TxOut) *wire.MsgTx {
+	tx := wire.NewMsgTx(1)
+	for _, o := range from {
+		tx.AddTxIn(wire.NewTxIn(o, nil, nil))
+	}
+	for _, o := range outs {
+		tx.AddTxOut(o)
+	}
+	return tx
+}
+
+func outPoint(tx *wire.MsgTx, vout uint32) *wire.OutPoint {
+	h := tx.TxHash()
+	return wire.NewOutPoint(&h, vout)
+}
+
+// testChain builds blocks on top of each other and inserts them
+type testChain struct {
+	t      *testing.T
+	s      Store
+	blocks []*wire.MsgBlock
+}
+
+// mine inserts a block with a coinbase paying 50 coins to scriptMiner,
+// followed by txs
+func (c *testChain) mine(txs ...*wire.MsgTx) *wire.MsgBlock {
+	c.t.Helper()
+	height := int64(len(c.blocks))
+	prev := chainhash.Hash{}
+	if height > 0 {
+		prev = c.blocks[height-1].BlockHash()
+	}
+	blk := &wire.MsgBlock{Header: wire.BlockHeader{
+		Version:   2,
+		PrevBlock: prev,
+		Timestamp: time.Unix(1500000000+height*150, 0),
+		Bits:      0x1e0ffff0,
+		Nonce:     uint32(height),
+	}}
+	blk.AddTransaction(coinbaseTx(height, scriptMiner, 50e8))
+	for _, tx := range txs {
+		blk.AddTransaction(tx)
+	}
+	err := c.s.InsertBlock(height, blk)
+	if err != nil {
+		c.t.Fatalf("Inserting block %d: %v", height, err)
+	}
+	c.blocks = append(c.blocks, blk)
+	return blk
+}
+
+func (c *testChain) coinbase(height int64) *wire.MsgTx {
+	return c.blocks[height].Transactions[0]
+}
+
+func assertBalance(t *testing.T, s Store, script []byte, want Balance) {
+	t.Helper()
+	b, err := s.Balance(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != want {
+		t.Errorf("Balance of %x is %+v, want %+v", script, b, want)
+	}
+}
+
+func assertConsistent(t *testing.T, s Store) {
+	t.Helper()
+	problems, err := s.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
+
+func TestInsertBlock(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		_, err := s.TipHeight()
+		if err != ErrNotFound {
+			t.Fatalf("TipHeight of an empty store returned %v, want ErrNotFound", err)
+		}
+
+		c := &testChain{t: t, s: s}
+		c.mine()
+		tx := spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(30e8, scriptA), wire.NewTxOut(20e8, scriptB))
+		blk := c.mine(tx)
+
+		tip, err := s.TipHeight()
+		if err != nil || tip != 1 {
+			t.Fatalf("TipHeight is %d (%v), want 1", tip, err)
+		}
+		hash, err := s.BlockHash(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *hash != blk.BlockHash() {
+			t.Errorf("BlockHash(1) is %s, want %s", hash, blk.BlockHash())
+		}
+		_, err = s.BlockHash(2)
+		if err != ErrNotFound {
+			t.Errorf("BlockHash(2) returned %v, want ErrNotFound", err)
+		}
+
+		b, err := s.BlockByHeight(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.TxCount != 2 {
+			t.Errorf("Block has %d transactions, want 2", b.TxCount)
+		}
+		hdr := b.Header()
+		if hdr == nil || hdr.BlockHash() != blk.BlockHash() {
+			t.Errorf("Stored header %+v does not hash to the block hash", hdr)
+		}
+
+		txHash := tx.TxHash()
+		b, err = s.TransactionBlock(&txHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Height != 1 {
+			t.Errorf("Transaction is in block %d, want 1", b.Height)
+		}
+		_, err = s.TransactionBlock(&chainhash.Hash{0x01})
+		if err != ErrNotFound {
+			t.Errorf("TransactionBlock of an unknown transaction returned %v, want ErrNotFound", err)
+		}
+		assertConsistent(t, s)
+	})
+}
+
+func TestBalanceAndUtxos(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c := &testChain{t: t, s: s}
+		c.mine()
+		tx := spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(30e8, scriptA), wire.NewTxOut(20e8, scriptB))
+		c.mine(tx)
+
+		assertBalance(t, s, scriptA, Balance{Confirmed: 30e8})
+		assertBalance(t, s, scriptB, Balance{Confirmed: 20e8})
+		// The coinbase of block 0 is spent, the one of block 1 is immature
+		assertBalance(t, s, scriptMiner, Balance{Maturing: 50e8})
+		assertBalance(t, s, p2pkh(0xff), Balance{})
+
+		utxos, err := s.Utxos(scriptA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Utxo{TxHash: tx.TxHash(), Vout: 0, Value: 30e8, Height: 1}
+		if len(utxos) != 1 || utxos[0] != want {
+			t.Errorf("Utxos of A are %+v, want [%+v]", utxos, want)
+		}
+		utxos, err = s.Utxos(scriptMiner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != 0 {
+			t.Errorf("Immature coinbase outputs are listed as utxos: %+v", utxos)
+		}
+
+		// The coinbase of block 1 matures at CoinbaseMaturity confirmations.
+		// Every block mined meanwhile adds an immature coinbase of its own.
+		for i := 0; i < CoinbaseMaturity; i++ {
+			c.mine()
+		}
+		assertBalance(t, s, scriptMiner, Balance{Confirmed: 50e8, Maturing: CoinbaseMaturity * 50e8})
+		utxos, err = s.Utxos(scriptMiner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != 1 || utxos[0].Height != 1 {
+			t.Errorf("Utxos of the miner are %+v, want only the coinbase of block 1", utxos)
+		}
+	})
+}
+
+func TestUnconfirmedTransactionGetsConfirmed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c := &testChain{t: t, s: s}
+		c.mine()
+		tx := spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(30e8, scriptA), wire.NewTxOut(20e8, scriptB))
+		err := s.AddUnconfirmedTransaction(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBalance(t, s, scriptA, Balance{Unconfirmed: 30e8})
+		assertBalance(t, s, scriptMiner, Balance{})
+		unconfirmed, err := s.UnconfirmedTransactions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unconfirmed) != 1 || *unconfirmed[0] != tx.TxHash() {
+			t.Errorf("Unconfirmed transactions are %v, want [%s]", unconfirmed, tx.TxHash())
+		}
+		utxos, err := s.UnconfirmedUtxos(scriptA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Utxo{TxHash: tx.TxHash(), Vout: 0, Value: 30e8, Height: 0}
+		if len(utxos) != 1 || utxos[0] != want {
+			t.Errorf("Unconfirmed utxos of A are %+v, want [%+v]", utxos, want)
+		}
+
+		// The transaction, its outputs and their scripts already exist, so
+		// the block goes through the ON CONFLICT paths
+		c.mine(tx)
+		assertBalance(t, s, scriptA, Balance{Confirmed: 30e8})
+		assertBalance(t, s, scriptMiner, Balance{Maturing: 50e8})
+		utxos, err = s.Utxos(scriptA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != 1 || utxos[0].Height != 1 {
+			t.Errorf("Utxos of A are %+v, want one at height 1", utxos)
+		}
+		utxos, err = s.UnconfirmedUtxos(scriptA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != 0 {
+			t.Errorf("Confirmed utxos of A are still unconfirmed: %+v", utxos)
+		}
+		unconfirmed, err = s.UnconfirmedTransactions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unconfirmed) != 0 {
+			t.Errorf("Confirmed transaction is still unconfirmed: %v", unconfirmed)
+		}
+
+		// Adding it again once confirmed changes nothing, and removing it
+		// as if it were dropped from the mempool leaves it alone
+		err = s.AddUnconfirmedTransaction(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txHash := tx.TxHash()
+		err = s.RemoveUnconfirmedTransactions([]*chainhash.Hash{&txHash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBalance(t, s, scriptA, Balance{Confirmed: 30e8})
+		assertConsistent(t, s)
+	})
+}
+
+func TestRemoveUnconfirmedTransactions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c := &testChain{t: t, s: s}
+		c.mine()
+		tx := spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(50e8, scriptA))
+		err := s.AddUnconfirmedTransaction(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txHash := tx.TxHash()
+		err = s.RemoveUnconfirmedTransactions([]*chainhash.Hash{&txHash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBalance(t, s, scriptA, Balance{})
+		assertBalance(t, s, scriptMiner, Balance{Maturing: 50e8})
+		_, err = s.TransactionBlock(&txHash)
+		if err != ErrNotFound {
+			t.Errorf("TransactionBlock of a removed transaction returned %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestScriptReusedAcrossBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c := &testChain{t: t, s: s}
+		c.mine()
+		c.mine(spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(10e8, scriptA), wire.NewTxOut(40e8, scriptA)))
+		c.mine(spendTx([]*wire.OutPoint{outPoint(c.coinbase(1), 0)}, wire.NewTxOut(50e8, scriptA)))
+
+		assertBalance(t, s, scriptA, Balance{Confirmed: 100e8})
+		utxos, err := s.Utxos(scriptA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != 3 {
+			t.Errorf("A has %d utxos, want 3", len(utxos))
+		}
+		assertConsistent(t, s)
+	})
+}
+
+func TestRevertBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c := &testChain{t: t, s: s}
+		c.mine()
+		tx1 := spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(30e8, scriptA), wire.NewTxOut(20e8, scriptB))
+		c.mine(tx1)
+		tx2 := spendTx([]*wire.OutPoint{outPoint(tx1, 0)}, wire.NewTxOut(30e8, scriptB))
+		c.mine(tx2)
+		assertBalance(t, s, scriptA, Balance{})
+		assertBalance(t, s, scriptB, Balance{Confirmed: 50e8})
+
+		err := s.RevertBlocks(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tip, err := s.TipHeight()
+		if err != nil || tip != 1 {
+			t.Fatalf("TipHeight after reverting is %d (%v), want 1", tip, err)
+		}
+		tx2Hash := tx2.TxHash()
+		_, err = s.TransactionBlock(&tx2Hash)
+		if err != ErrNotFound {
+			t.Errorf("TransactionBlock of a reverted transaction returned %v, want ErrNotFound", err)
+		}
+		assertBalance(t, s, scriptA, Balance{Confirmed: 30e8})
+		assertBalance(t, s, scriptB, Balance{Confirmed: 20e8})
+		assertConsistent(t, s)
+
+		err = s.RevertBlocks(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBalance(t, s, scriptA, Balance{})
+		assertBalance(t, s, scriptB, Balance{})
+		assertBalance(t, s, scriptMiner, Balance{Maturing: 50e8})
+		_, err = s.BlockHash(1)
+		if err != ErrNotFound {
+			t.Errorf("BlockHash of a reverted block returned %v, want ErrNotFound", err)
+		}
+
+		// The blocks can be indexed again
+		c.blocks = c.blocks[:1]
+		c.mine(tx1)
+		c.mine(tx2)
+		assertBalance(t, s, scriptB, Balance{Confirmed: 50e8})
+		assertConsistent(t, s)
+	})
+}
+
+func TestHistory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c := &testChain{t: t, s: s}
+		c.mine()
+		tx1 := spendTx([]*wire.OutPoint{outPoint(c.coinbase(0), 0)}, wire.NewTxOut(30e8, scriptA), wire.NewTxOut(20e8, scriptB))
+		c.mine(tx1)
+		tx2 := spendTx([]*wire.OutPoint{outPoint(tx1, 0)}, wire.NewTxOut(10e8, scriptB), wire.NewTxOut(20e8, scriptA))
+		blk2 := c.mine(tx2)
+		tx3 := spendTx([]*wire.OutPoint{outPoint(tx2, 1)}, wire.NewTxOut(20e8, scriptB))
+		err := s.AddUnconfirmedTransaction(tx3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The first page has no cursor and starts at the unconfirmed ones
+		page, cursor, err := s.History(scriptA, "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 2 || cursor == "" {
+			t.Fatalf("First page is %+v with cursor %q, want 2 entries and a cursor", page, cursor)
+		}
+		if page[0].TxHash != tx3.TxHash() || page[0].Confirmed || page[0].Value != -20e8 {
+			t.Errorf("First entry is %+v, want unconfirmed %s spending 20 coins", page[0], tx3.TxHash())
+		}
+		blk2Hash := blk2.BlockHash()
+		if page[1].TxHash != tx2.TxHash() || !page[1].Confirmed || page[1].Height != 2 || *page[1].BlockHash != blk2Hash || page[1].Value != -10e8 {
+			t.Errorf("Second entry is %+v, want %s at height 2 spending 10 coins", page[1], tx2.TxHash())
+		}
+		if page[1].BlockTime == nil || !page[1].BlockTime.Equal(blk2.Header.Timestamp) {
+			t.Errorf("Second entry has block time %v, want %v", page[1].BlockTime, blk2.Header.Timestamp)
+		}
+
+		page, cursor, err = s.History(scriptA, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || cursor != "" {
+			t.Fatalf("Last page is %+v with cursor %q, want 1 entry and no cursor", page, cursor)
+		}
+		if page[0].TxHash != tx1.TxHash() || page[0].Height != 1 || page[0].Value != 30e8 {
+			t.Errorf("Last entry is %+v, want %s at height 1 receiving 30 coins", page[0], tx1.TxHash())
+		}
+
+		_, _, err = s.History(scriptA, "nonsense", 2)
+		if err != ErrInvalidCursor {
+			t.Errorf("History with an invalid cursor returned %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+// oldSchema is the schema from before block headers, difficulty, script
+// hashes, webhooks and the schema version were stored
+var oldSchema = map[string]string{
+	"sqlite": `
+CREATE TABLE blocks (id INTEGER PRIMARY KEY, height INTEGER, hash BLOB);
+CREATE TABLE scripts (id INTEGER PRIMARY KEY, script BLOB);
+CREATE UNIQUE INDEX scripts_idx_script ON scripts (script);
+`,
+	"postgres": postgresBaseTables,
+}
+
+// testDatabase is a database of one of the backends, which tests can
+// create with a schema, open and migrate
+type testDatabase struct {
+	create  func(t *testing.T, schema string)
+	open    func() (Store, error)
+	migrate func() error
+}
+
+func testDatabases() map[string]func(t *testing.T) testDatabase {
+	return map[string]func(t *testing.T) testDatabase{
+		"sqlite": func(t *testing.T) testDatabase {
+			path := filepath.Join(t.TempDir(), "test.db")
+			return testDatabase{
+				create: func(t *testing.T, schema string) {
+					db, err := sql.Open("sqlite3", "file:"+path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer db.Close()
+					_, err = db.Exec(schema)
+					if err != nil {
+						t.Fatal(err)
+					}
+				},
+				open:    func() (Store, error) { return NewSQLiteStore(path) },
+				migrate: func() error { return MigrateSQLite(path) },
+			}
+		},
+		"postgres": func(t *testing.T) testDatabase {
+			connStr := os.Getenv(postgresTestEnv)
+			if connStr == "" {
+				t.Skip(postgresTestEnv + " is not set")
+			}
+			dropPostgresTables(t, connStr)
+			return testDatabase{
+				create: func(t *testing.T, schema string) {
+					db, err := sql.Open("postgres", connStr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer db.Close()
+					_, err = db.Exec(schema)
+					if err != nil {
+						t.Fatal(err)
+					}
+				},
+				open:    func() (Store, error) { return NewPostgresStore(connStr) },
+				migrate: func() error { return MigratePostgres(connStr) },
+			}
+		},
+	}
+}
+
+func TestOpenRefusesUnmigratedDatabase(t *testing.T) {
+	for backend, newDatabase := range testDatabases() {
+		t.Run(backend, func(t *testing.T) {
+			db := newDatabase(t)
+			s, err := db.open()
+			if err == nil {
+				s.Close()
+				t.Fatal("An empty database was opened without migrating it")
+			}
+			if !strings.Contains(err.Error(), "migrate") {
+				t.Errorf("Error is %q, want it to say to run migrate", err)
+			}
+
+			err = db.migrate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Migrating an up to date database changes nothing
+			err = db.migrate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err = db.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+		})
+	}
+}
+
+func TestMigrateOldDatabase(t *testing.T) {
+	for backend, newDatabase := range testDatabases() {
+		t.Run(backend, func(t *testing.T) {
+			db := newDatabase(t)
+			db.create(t, oldSchema[backend])
+			_, err := db.open()
+			if err == nil || !strings.Contains(err.Error(), "migrate") {
+				t.Fatalf("Opening a database with the old schema returned %v, want an error saying to run migrate", err)
+			}
+
+			err = db.migrate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := db.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			c := &testChain{t: t, s: s}
+			blk := c.mine()
+			b, err := s.BlockByHeight(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Header() == nil || b.Header().BlockHash() != blk.BlockHash() {
+				t.Errorf("Header of the block was not stored")
+			}
+			stats, err := s.BlockStatsByHeight(0, 0)
+			if err != nil || len(stats) != 1 || stats[0].Difficulty == 0 {
+				t.Errorf("Block stats are %+v (%v), want the difficulty of block 0", stats, err)
+			}
+			_, err = s.ScriptByScriptHash(make([]byte, 32))
+			if err != ErrNotFound {
+				t.Errorf("ScriptByScriptHash returned %v, want ErrNotFound", err)
+			}
+			_, err = s.AddWebhook(scriptA, "http://localhost/hook", "secret")
+			if err != nil {
+				t.Errorf("AddWebhook: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Package storetest has in-memory fakes of the store interfaces, to test
+// the packages that use them without a database.
+package storetest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/vertcoin"
+)
+
+// Chain is an in-memory store.ChainStore. It keeps the headers of the
+// blocks and which block every transaction is in, but no outputs.
+type Chain struct {
+	mtx      sync.Mutex
+	blocks   map[int64]*store.Block
+	txBlocks map[chainhash.Hash]int64
+}
+
+var _ store.ChainStore = (*Chain)(nil)
+
+func NewChain() *Chain {
+	return &Chain{
+		blocks:   map[int64]*store.Block{},
+		txBlocks: map[chainhash.Hash]int64{},
+	}
+}
+
+func (c *Chain) TipHeight() (int64, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.blocks) == 0 {
+		return 0, store.ErrNotFound
+	}
+	return c.maxHeight(), nil
+}
+
+func (c *Chain) BlockHash(height int64) (*chainhash.Hash, error) {
+	blk, err := c.BlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return &blk.Hash, nil
+}
+
+func (c *Chain) BlockByHash(hash *chainhash.Hash) (*store.Block, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, b := range c.blocks {
+		if b.Hash.IsEqual(hash) {
+			cp := *b
+			return &cp, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (c *Chain) BlockByHeight(height int64) (*store.Block, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	b, ok := c.blocks[height]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	cp := *b
+	return &cp, nil
+}
+
+func (c *Chain) TransactionBlock(hash *chainhash.Hash) (*store.Block, error) {
+	c.mtx.Lock()
+	height, ok := c.txBlocks[*hash]
+	c.mtx.Unlock()
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return c.BlockByHeight(height)
+}
+
+func (c *Chain) BlockStatsByHeight(from, to int64) ([]store.BlockStat, error) {
+	return c.blockStats(func(b *store.Block) bool { return b.Height >= from && b.Height <= to })
+}
+
+func (c *Chain) BlockStatsByTime(from, to time.Time) ([]store.BlockStat, error) {
+	return c.blockStats(func(b *store.Block) bool { return !b.Time.Before(from) && !b.Time.After(to) })
+}
+
+func (c *Chain) blockStats(match func(b *store.Block) bool) ([]store.BlockStat, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	result := make([]store.BlockStat, 0)
+	tip := c.maxHeight()
+	for h := int64(0); h <= tip; h++ {
+		b, ok := c.blocks[h]
+		if !ok || b.Time == nil || !match(b) {
+			continue
+		}
+		result = append(result, store.BlockStat{Height: b.Height, Time: *b.Time, Difficulty: vertcoin.BitsToDifficulty(b.Bits)})
+	}
+	return result, nil
+}
+
+func (c *Chain) maxHeight() int64 {
+	tip := int64(-1)
+	for h := range c.blocks {
+		if h > tip {
+			tip = h
+		}
+	}
+	return tip
+}
+
+// InsertBlock stores the header of blk. Unlike the real stores, it fails
+// when there already is a block at height, to catch callers that don't
+// revert first.
+func (c *Chain) InsertBlock(height int64, blk *wire.MsgBlock) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.blocks[height]; ok {
+		return fmt.Errorf("Block at height %d already exists", height)
+	}
+	hdr := blk.Header
+	t := hdr.Timestamp
+	prev := hdr.PrevBlock
+	merkle := hdr.MerkleRoot
+	c.blocks[height] = &store.Block{
+		Height:     height,
+		Hash:       blk.BlockHash(),
+		Version:    hdr.Version,
+		PrevHash:   &prev,
+		MerkleRoot: &merkle,
+		Time:       &t,
+		Bits:       hdr.Bits,
+		Nonce:      hdr.Nonce,
+		TxCount:    int64(len(blk.Transactions)),
+	}
+	for _, tx := range blk.Transactions {
+		c.txBlocks[tx.TxHash()] = height
+	}
+	return nil
+}
+
+func (c *Chain) RevertBlocks(height int64) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for h := range c.blocks {
+		if h > height {
+			delete(c.blocks, h)
+		}
+	}
+	for tx, h := range c.txBlocks {
+		if h > height {
+			delete(c.txBlocks, tx)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package storetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+// NewSQLite returns a migrated, empty SQLite store in a temporary directory
+// that is closed when the test ends
+func NewSQLite(t testing.TB) store.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	err := store.MigrateSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
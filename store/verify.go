@@ -0,0 +1,78 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// verifyLimit is the number of offending rows listed per check
+const verifyLimit = 10
+
+// consistencyChecks are queries for rows that violate an invariant of the
+// index. Each returns the height or id of the offending rows.
+var consistencyChecks = []struct {
+	problem string
+	query   string
+}{
+	{"Heights with more than one block",
+		"SELECT height FROM blocks GROUP BY height HAVING COUNT(*) > 1 ORDER BY height"},
+	{"Missing blocks below the tip, at the start of each gap",
+		"SELECT b.height+1 FROM blocks b WHERE b.height < (SELECT MAX(height) FROM blocks) AND NOT EXISTS (SELECT 1 FROM blocks n WHERE n.height=b.height+1) ORDER BY b.height"},
+	{"Blocks that don't build on the block below them",
+		"SELECT b.height FROM blocks b INNER JOIN blocks p ON p.height=b.height-1 WHERE b.prev_hash IS NOT NULL AND p.hash <> b.prev_hash ORDER BY b.height"},
+	{"Blocks with a different number of transactions than they were indexed with",
+		"SELECT b.height FROM blocks b LEFT JOIN transactions t ON t.block_id=b.id WHERE b.tx_count IS NOT NULL GROUP BY b.id, b.height, b.tx_count HAVING COUNT(t.id) <> b.tx_count ORDER BY b.height"},
+	{"Transactions in a block that doesn't exist",
+		"SELECT t.id FROM transactions t WHERE t.block_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.id=t.block_id) ORDER BY t.id"},
+	{"Outputs created by a transaction that doesn't exist",
+		"SELECT o.id FROM outputs o WHERE NOT EXISTS (SELECT 1 FROM transactions t WHERE t.id=o.created_in_tx) ORDER BY o.id"},
+	{"Outputs spent by a transaction that doesn't exist",
+		"SELECT o.id FROM outputs o WHERE o.spent_in_tx IS NOT NULL AND NOT EXISTS (SELECT 1 FROM transactions t WHERE t.id=o.spent_in_tx) ORDER BY o.id"},
+	{"Outputs to a script that doesn't exist",
+		"SELECT o.id FROM outputs o WHERE NOT EXISTS (SELECT 1 FROM scripts s WHERE s.id=o.script_id) ORDER BY o.id"},
+	{"Outputs spent in a block below the one that created them",
+		"SELECT o.id FROM outputs o INNER JOIN transactions c ON c.id=o.created_in_tx INNER JOIN blocks cb ON cb.id=c.block_id INNER JOIN transactions sp ON sp.id=o.spent_in_tx INNER JOIN blocks sb ON sb.id=sp.block_id WHERE sb.height < cb.height ORDER BY o.id"},
+}
+
+func (s *sqlStore) Verify() ([]string, error) {
+	problems := make([]string, 0)
+	for _, c := range consistencyChecks {
+		ids, err := s.queryInt64s(c.query + " LIMIT " + strconv.Itoa(verifyLimit+1))
+		if err != nil {
+			return nil, fmt.Errorf("Error checking %s: %v", strings.ToLower(c.problem), err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		list := make([]string, 0, len(ids))
+		for i, id := range ids {
+			if i == verifyLimit {
+				list = append(list, "...")
+				break
+			}
+			list = append(list, strconv.FormatInt(id, 10))
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", c.problem, strings.Join(list, ", ")))
+	}
+	return problems, nil
+}
+
+func (s *sqlStore) queryInt64s(query string, args ...interface{}) ([]int64, error) {
+	result := make([]int64, 0)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i int64
+		err = rows.Scan(&i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, i)
+	}
+	return result, rows.Err()
+}
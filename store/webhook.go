@@ -0,0 +1,212 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func (s *sqlStore) AddWebhook(script []byte, url, secret string) (*Webhook, error) {
+	w := &Webhook{Script: script, URL: url, Secret: secret, Created: time.Unix(time.Now().Unix(), 0)}
+	err := s.db.QueryRow("INSERT INTO webhooks(script, url, secret, created) VALUES ($1,$2,$3,$4) RETURNING id", script, url, secret, w.Created.Unix()).Scan(&w.ID)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *sqlStore) Webhooks() ([]Webhook, error) {
+	result := make([]Webhook, 0)
+	rows, err := s.db.Query("SELECT id, script, url, secret, created FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var w Webhook
+		var created int64
+		err = rows.Scan(&w.ID, &w.Script, &w.URL, &w.Secret, &created)
+		if err != nil {
+			return nil, err
+		}
+		w.Created = time.Unix(created, 0)
+		result = append(result, w)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) DeleteWebhook(id int64) error {
+	trx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = trx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id=$1", id)
+	if err != nil {
+		trx.Rollback()
+		return err
+	}
+	res, err := trx.Exec("DELETE FROM webhooks WHERE id=$1", id)
+	if err != nil {
+		trx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		trx.Rollback()
+		return err
+	}
+	if n == 0 {
+		trx.Rollback()
+		return ErrNotFound
+	}
+	return trx.Commit()
+}
+
+func (s *sqlStore) WebhookActivity(height int64) ([]WebhookActivity, error) {
+	result := make([]WebhookActivity, 0)
+
+	rows, err := s.db.Query("select w.id, sc.script, t.hash, o.vout, o.value from webhooks w inner join scripts sc on sc.script=w.script inner join outputs o on o.script_id=sc.id inner join transactions t on t.id=o.created_in_tx inner join blocks b on b.id=t.block_id where b.height=$1 order by t.id, o.vout", height)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying created outputs: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a WebhookActivity
+		var txHash []byte
+		err = rows.Scan(&a.WebhookID, &a.Script, &txHash, &a.Vout, &a.Value)
+		if err != nil {
+			return nil, err
+		}
+		h, err := chainhash.NewHash(txHash)
+		if err != nil {
+			return nil, err
+		}
+		a.TxHash = *h
+		a.OutputTxHash = *h
+		result = append(result, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows2, err := s.db.Query("select w.id, sc.script, st.hash, ct.hash, o.vout, o.value from webhooks w inner join scripts sc on sc.script=w.script inner join outputs o on o.script_id=sc.id inner join transactions st on st.id=o.spent_in_tx inner join transactions ct on ct.id=o.created_in_tx inner join blocks b on b.id=st.block_id where b.height=$1 order by st.id, ct.id, o.vout", height)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying spent outputs: %v", err)
+	}
+	defer rows2.Close()
+	for rows2.Next() {
+		a := WebhookActivity{Spent: true}
+		var txHash, outputTxHash []byte
+		err = rows2.Scan(&a.WebhookID, &a.Script, &txHash, &outputTxHash, &a.Vout, &a.Value)
+		if err != nil {
+			return nil, err
+		}
+		h, err := chainhash.NewHash(txHash)
+		if err != nil {
+			return nil, err
+		}
+		a.TxHash = *h
+		h, err = chainhash.NewHash(outputTxHash)
+		if err != nil {
+			return nil, err
+		}
+		a.OutputTxHash = *h
+		result = append(result, a)
+	}
+	return result, rows2.Err()
+}
+
+func (s *sqlStore) EnqueueWebhookDeliveries(deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	trx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, d := range deliveries {
+		_, err = trx.Exec("INSERT INTO webhook_deliveries(webhook_id, payload, attempts, next_attempt, created) VALUES ($1,$2,0,$3,$3)", d.WebhookID, d.Payload, now)
+		if err != nil {
+			trx.Rollback()
+			return err
+		}
+	}
+	return trx.Commit()
+}
+
+func (s *sqlStore) DueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	result := make([]WebhookDelivery, 0)
+	rows, err := s.db.Query("SELECT d.id, d.webhook_id, w.url, w.secret, d.payload, d.attempts, d.next_attempt, d.last_error, d.created FROM webhook_deliveries d INNER JOIN webhooks w ON w.id=d.webhook_id WHERE d.next_attempt <= $1 ORDER BY d.next_attempt, d.id LIMIT $2", now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttempt, created int64
+		var lastError sql.NullString
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Payload, &d.Attempts, &nextAttempt, &lastError, &created)
+		if err != nil {
+			return nil, err
+		}
+		d.NextAttempt = time.Unix(nextAttempt, 0)
+		d.LastError = lastError.String
+		d.Created = time.Unix(created, 0)
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) CompleteWebhookDelivery(id int64) error {
+	_, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE id=$1", id)
+	return err
+}
+
+func (s *sqlStore) RetryWebhookDelivery(id int64, next time.Time, lastError string) error {
+	_, err := s.db.Exec("UPDATE webhook_deliveries SET attempts=attempts+1, next_attempt=$1, last_error=$2 WHERE id=$3", next.Unix(), lastError, id)
+	return err
+}
+
+func (s *sqlStore) DeadLetterWebhookDelivery(id int64, lastError string) error {
+	trx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = trx.Exec("INSERT INTO webhook_dead_letters(webhook_id, url, payload, attempts, last_error, created, failed) SELECT d.webhook_id, w.url, d.payload, d.attempts+1, $1, d.created, $2 FROM webhook_deliveries d INNER JOIN webhooks w ON w.id=d.webhook_id WHERE d.id=$3", lastError, time.Now().Unix(), id)
+	if err != nil {
+		trx.Rollback()
+		return err
+	}
+	_, err = trx.Exec("DELETE FROM webhook_deliveries WHERE id=$1", id)
+	if err != nil {
+		trx.Rollback()
+		return err
+	}
+	return trx.Commit()
+}
+
+func (s *sqlStore) WebhookDeadLetters(limit int) ([]WebhookDeadLetter, error) {
+	result := make([]WebhookDeadLetter, 0)
+	rows, err := s.db.Query("SELECT id, webhook_id, url, payload, attempts, last_error, created, failed FROM webhook_dead_letters ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d WebhookDeadLetter
+		var created, failed int64
+		var lastError sql.NullString
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Payload, &d.Attempts, &lastError, &created, &failed)
+		if err != nil {
+			return nil, err
+		}
+		d.LastError = lastError.String
+		d.Created = time.Unix(created, 0)
+		d.Failed = time.Unix(failed, 0)
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
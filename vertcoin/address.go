@@ -0,0 +1,98 @@
+package vertcoin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+// AddressToScript decodes a P2PKH, P2SH, P2WPKH or P2WSH address for any
+// of the Vertcoin networks into the output script it pays to.
+func AddressToScript(addr string) ([]byte, error) {
+	if isSegwitAddress(addr) {
+		return decodeSegwitAddress(addr)
+	}
+
+	decoded, version, err := base58.CheckDecode(addr)
+	if err != nil {
+		if err == base58.ErrChecksum {
+			return nil, errors.New("Address checksum mismatch")
+		}
+		return nil, errors.New("Address is neither valid base58 nor bech32")
+	}
+	if len(decoded) != 20 {
+		return nil, fmt.Errorf("Address contains a hash of %d bytes, expected 20", len(decoded))
+	}
+	for _, net := range Networks {
+		switch version {
+		case net.PubKeyHashAddrID:
+			return PayToPubKeyHashScript(decoded), nil
+		case net.ScriptHashAddrID:
+			return PayToScriptHashScript(decoded), nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown address version %d", version)
+}
+
+// isSegwitAddress reports whether addr starts with the bech32 prefix of one
+// of the networks. Bech32 addresses are either all lower or all upper case,
+// which also keeps base58 addresses that happen to start with it out.
+func isSegwitAddress(addr string) bool {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return false
+	}
+	lower := strings.ToLower(addr)
+	for _, net := range Networks {
+		if strings.HasPrefix(lower, net.Bech32HRP+"1") {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegwitAddress(addr string) ([]byte, error) {
+	_, data, err := bech32.Decode(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid bech32 address: %v", err)
+	}
+	if len(data) < 1 {
+		return nil, errors.New("Bech32 address has no witness version")
+	}
+	witnessVersion := data[0]
+	program, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid bech32 address: %v", err)
+	}
+	if witnessVersion != 0 {
+		return nil, fmt.Errorf("Unsupported witness version %d", witnessVersion)
+	}
+	if len(program) != 20 && len(program) != 32 {
+		return nil, fmt.Errorf("Witness program of %d bytes is neither P2WPKH nor P2WSH", len(program))
+	}
+	return PayToWitnessScript(witnessVersion, program), nil
+}
+
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	script := []byte{txscript.OP_DUP, txscript.OP_HASH160, byte(len(pubKeyHash))}
+	script = append(script, pubKeyHash...)
+	return append(script, txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+}
+
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	script := []byte{txscript.OP_HASH160, byte(len(scriptHash))}
+	script = append(script, scriptHash...)
+	return append(script, txscript.OP_EQUAL)
+}
+
+func PayToWitnessScript(version byte, program []byte) []byte {
+	op := byte(txscript.OP_0)
+	if version > 0 {
+		op = txscript.OP_1 + version - 1
+	}
+	script := []byte{op, byte(len(program))}
+	return append(script, program...)
+}
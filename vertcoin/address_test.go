@@ -0,0 +1,67 @@
+package vertcoin
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The addresses all encode the same 20 byte hash, or the same 32 byte
+// script hash for P2WSH
+const (
+	testHash160 = "06afd46bcdfd22ef94ac122aa11f241244a37ecc"
+	testHash256 = "fc5acc302aab97f821f9a61e1cc572e7968a603551e95d4ba12b51df6581482f"
+)
+
+func TestAddressToScript(t *testing.T) {
+	p2pkh := "76a914" + testHash160 + "88ac"
+	p2sh := "a914" + testHash160 + "87"
+	p2wpkh := "0014" + testHash160
+	p2wsh := "0020" + testHash256
+	for _, c := range []struct {
+		addr   string
+		script string
+	}{
+		// Mainnet
+		{"VacBbjBahG6w1D2icNENi2Uzdovym2Zz3m", p2pkh},
+		{"32JNcZWZqMX72bpzzgFLhkX56WviowgUtS", p2sh},
+		{"vtc1qq6hag67dl53wl99vzg42z8eyzfz2xlkvkg59kw", p2wpkh},
+		{"vtc1ql3dvcvp24wtlsg0e5c0pe3tju7tg5cp428546jap9dga7evpfqhs82e07f", p2wsh},
+		// Testnet
+		{"WnczZ45SpoVZTWSygdELAQJMXKhorot4f5", p2pkh},
+		{"2MsragJSbSp2TEPTYfosDKhWLJs8tcdHVy3", p2sh},
+		{"tvtc1qq6hag67dl53wl99vzg42z8eyzfz2xlkvp6hv9y", p2wpkh},
+		{"tvtc1ql3dvcvp24wtlsg0e5c0pe3tju7tg5cp428546jap9dga7evpfqhsv9a3pu", p2wsh},
+		// Bech32 can be all upper case
+		{"VTC1QQ6HAG67DL53WL99VZG42Z8EYZFZ2XLKVKG59KW", p2wpkh},
+	} {
+		script, err := AddressToScript(c.addr)
+		if err != nil {
+			t.Errorf("%s: %v", c.addr, err)
+			continue
+		}
+		if hex.EncodeToString(script) != c.script {
+			t.Errorf("%s: script is %x, want %s", c.addr, script, c.script)
+		}
+	}
+}
+
+func TestAddressToScriptInvalid(t *testing.T) {
+	for _, c := range []struct {
+		addr   string
+		reason string
+	}{
+		{"VacBbjBahG6w1D2icNENi2Uzdovym2Zz3n", "bad base58 checksum"},
+		{"vtc1qq6hag67dl53wl99vzg42z8eyzfz2xlkvkg59kq", "bad bech32 checksum"},
+		{"bc1qq6hag67dl53wl99vzg42z8eyzfz2xlkvxechjp", "bitcoin HRP"},
+		{"1cMh228HTCiwS8ZsaakH8A8wze1JR5ZsP", "bitcoin version byte"},
+		{"vtc1QQ6hag67dl53wl99vzg42z8eyzfz2xlkvkg59kw", "mixed case bech32"},
+		{"vtc1pl3dvcvp24wtlsg0e5c0pe3tju7tg5cp428546jap9dga7evpfqhscpf2rh", "witness version 1"},
+		{"", "empty"},
+		{"notanaddress", "garbage"},
+		{"7UYzEJiNECaWucMKwghe6Da5T1fToq8FT", "19 byte hash"},
+	} {
+		if script, err := AddressToScript(c.addr); err == nil {
+			t.Errorf("%s (%s) was accepted as %x", c.addr, c.reason, script)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package vertcoin
+
+// BitsToDifficulty converts the compact target in a block header to the
+// difficulty relative to the minimum difficulty target
+func BitsToDifficulty(bits uint32) float64 {
+	shift := (bits >> 24) & 0xff
+	diff := float64(0x0000ffff) / float64(bits&0x00ffffff)
+
+	for shift < 29 {
+		diff *= 256.0
+		shift++
+	}
+	for shift > 29 {
+		diff /= 256.0
+		shift--
+	}
+
+	return diff
+}
@@ -0,0 +1,25 @@
+package vertcoin
+
+// Params are the network specific address encoding parameters
+type Params struct {
+	Name             string
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+	Bech32HRP        string
+}
+
+var MainNetParams = Params{
+	Name:             "mainnet",
+	PubKeyHashAddrID: 0x47,
+	ScriptHashAddrID: 0x05,
+	Bech32HRP:        "vtc",
+}
+
+var TestNetParams = Params{
+	Name:             "testnet",
+	PubKeyHashAddrID: 0x4a,
+	ScriptHashAddrID: 0xc4,
+	Bech32HRP:        "tvtc",
+}
+
+var Networks = []*Params{&MainNetParams, &TestNetParams}
@@ -0,0 +1,100 @@
+package vertcoin
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// ScriptType is the kind of output script a wallet derives from its keys
+type ScriptType int
+
+const (
+	ScriptTypeP2PKH ScriptType = iota
+	ScriptTypeP2SHP2WPKH
+	ScriptTypeP2WPKH
+)
+
+func (t ScriptType) String() string {
+	switch t {
+	case ScriptTypeP2SHP2WPKH:
+		return "p2sh-p2wpkh"
+	case ScriptTypeP2WPKH:
+		return "p2wpkh"
+	default:
+		return "p2pkh"
+	}
+}
+
+// extendedKeyVersions maps the SLIP-132 versions of extended public keys to
+// the script type of the wallet they belong to
+var extendedKeyVersions = []struct {
+	version    []byte
+	scriptType ScriptType
+}{
+	{[]byte{0x04, 0x88, 0xb2, 0x1e}, ScriptTypeP2PKH},      // xpub
+	{[]byte{0x04, 0x9d, 0x7c, 0xb2}, ScriptTypeP2SHP2WPKH}, // ypub
+	{[]byte{0x04, 0xb2, 0x47, 0x46}, ScriptTypeP2WPKH},     // zpub
+	{[]byte{0x04, 0x35, 0x87, 0xcf}, ScriptTypeP2PKH},      // tpub
+	{[]byte{0x04, 0x4a, 0x52, 0x62}, ScriptTypeP2SHP2WPKH}, // upub
+	{[]byte{0x04, 0x5f, 0x1c, 0xf6}, ScriptTypeP2WPKH},     // vpub
+}
+
+// ExtendedKey is an extended public key together with the script type its
+// version implies
+type ExtendedKey struct {
+	Key        *hdkeychain.ExtendedKey
+	ScriptType ScriptType
+}
+
+// ParseExtendedKey parses an xpub, ypub or zpub (or their testnet
+// counterparts). Private keys are refused.
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	key, err := hdkeychain.NewKeyFromString(s)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsPrivate() {
+		return nil, errors.New("Extended private keys are not accepted")
+	}
+	for _, v := range extendedKeyVersions {
+		if bytes.Equal(key.Version(), v.version) {
+			return &ExtendedKey{Key: key, ScriptType: v.scriptType}, nil
+		}
+	}
+	return nil, errors.New("Unknown extended key version")
+}
+
+// Script derives the output script at chain/index below the key
+func (k *ExtendedKey) Script(chain, index uint32) ([]byte, error) {
+	child, err := k.Key.Derive(chain)
+	if err != nil {
+		return nil, err
+	}
+	child, err = child.Derive(index)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return PubKeyScript(k.ScriptType, pubKey.SerializeCompressed()), nil
+}
+
+// PubKeyScript returns the output script of the given type paying to a
+// compressed public key
+func PubKeyScript(t ScriptType, pubKey []byte) []byte {
+	pubKeyHash := btcutil.Hash160(pubKey)
+	switch t {
+	case ScriptTypeP2SHP2WPKH:
+		redeemScript := PayToWitnessScript(0, pubKeyHash)
+		return PayToScriptHashScript(btcutil.Hash160(redeemScript))
+	case ScriptTypeP2WPKH:
+		return PayToWitnessScript(0, pubKeyHash)
+	default:
+		return PayToPubKeyHashScript(pubKeyHash)
+	}
+}
@@ -0,0 +1,86 @@
+package vertcoin
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Account keys m/44'/0'/0', m/49'/0'/0' and m/84'/0'/0' of the mnemonic
+// "abandon abandon ... about" from the BIP-44, BIP-49 and BIP-84 test
+// vectors
+const (
+	testXpub = "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj"
+	testYpub = "ypub6Ww3ibxVfGzLrAH1PNcjyAWenMTbbAosGNB6VvmSEgytSER9azLDWCxoJwW7Ke7icmizBMXrzBx9979FfaHxHcrArf3zbeJJJUZPf663zsP"
+	testZpub = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	// The BIP-44 account key with the testnet version
+	testTpub = "tpubDCBWBScQPGv4Xk3JSbhw6wYYpayMjb2eAYyArpbSqQTbLDpphHGAetB6VQgVeftLML8vDSUEWcC2xDi3qJJ3YCDChJDvqVzpgoYSuT52MhJ"
+)
+
+func TestExtendedKeyScripts(t *testing.T) {
+	for _, c := range []struct {
+		key        string
+		scriptType ScriptType
+		// Scripts at 0/0, 0/1, 1/0 and 1/1
+		scripts []string
+	}{
+		{testXpub, ScriptTypeP2PKH, []string{
+			"76a914d986ed01b7a22225a70edbf2ba7cfb63a15cb3aa88ac",
+			"76a9146ae1301cf44ca525751d1763ac4fef12d115398688ac",
+			"76a914bae93c8e7fb682422d24780b1a12a550eff428f288ac",
+			"76a91420061f5945d843601e053288fe3d6f87e36129d588ac",
+		}},
+		{testYpub, ScriptTypeP2SHP2WPKH, []string{
+			"a9143fb6e95812e57bb4691f9a4a628862a61a4f769b87",
+			"a914d28f8c8309322c085021f00861f27c973bff03b787",
+			"a9141cc1e09a63d1ae795a7130e099b28a0b1d8e4fae87",
+			"a914245378460ca54786399a4937db677827044b475f87",
+		}},
+		{testZpub, ScriptTypeP2WPKH, []string{
+			"0014c0cebcd6c3d3ca8c75dc5ec62ebe55330ef910e2",
+			"00149c90f934ea51fa0f6504177043e0908da6929983",
+			"00143e34985dca6fddc9fb369940e4c7d8e2873f529c",
+			"00144227d834f1aae95273f0c87495f4ff0cb3665452",
+		}},
+		{testTpub, ScriptTypeP2PKH, []string{
+			"76a914d986ed01b7a22225a70edbf2ba7cfb63a15cb3aa88ac",
+			"76a9146ae1301cf44ca525751d1763ac4fef12d115398688ac",
+			"76a914bae93c8e7fb682422d24780b1a12a550eff428f288ac",
+			"76a91420061f5945d843601e053288fe3d6f87e36129d588ac",
+		}},
+	} {
+		key, err := ParseExtendedKey(c.key)
+		if err != nil {
+			t.Errorf("%s: %v", c.key[:4], err)
+			continue
+		}
+		if key.ScriptType != c.scriptType {
+			t.Errorf("%s: script type %s, want %s", c.key[:4], key.ScriptType, c.scriptType)
+		}
+		for i, want := range c.scripts {
+			chain, index := uint32(i/2), uint32(i%2)
+			script, err := key.Script(chain, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(script) != want {
+				t.Errorf("%s %d/%d: script is %x, want %s", c.key[:4], chain, index, script, want)
+			}
+		}
+	}
+}
+
+func TestParseExtendedKeyInvalid(t *testing.T) {
+	for _, s := range []string{
+		// BIP-32 test vector 1 master private key
+		"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+		// Litecoin version of the BIP-44 account key
+		"Ltub2YEz7qzkZSGcWK8PatiqktKQaGCGVz3ikCarLMHQrzYQKJnvH4upL5zhcF7jnaLM9e1bBzLupY1NN8aZMLYFVgYKvqN5pf6dbLiP3zaCxFw",
+		// Bad checksum
+		testXpub[:len(testXpub)-1] + "k",
+		"xpubnotakey",
+	} {
+		if _, err := ParseExtendedKey(s); err == nil {
+			t.Errorf("%s was accepted", s)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package webhook delivers notifications to the URLs registered for
+// watched scripts when they receive or spend funds.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/events"
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/gertjaap/ocm-backend/store"
+)
+
+const (
+	// SignatureHeader holds the hex HMAC-SHA256 of the body, keyed with the
+	// secret of the webhook, prefixed with "sha256="
+	SignatureHeader = "X-OCM-Signature"
+	DeliveryHeader  = "X-OCM-Delivery"
+	EventHeader     = "X-OCM-Event"
+
+	maxBackoff    = time.Hour
+	deliveryBatch = 50
+)
+
+var webhookLog = logging.Named("webhook")
+
+// Payload is the JSON body POSTed to a webhook
+type Payload struct {
+	Event     string        `json:"event"`
+	WebhookID int64         `json:"webhookId"`
+	Script    string        `json:"script"`
+	TxID      string        `json:"txid"`
+	Output    PayloadOutput `json:"output"`
+	Height    int64         `json:"height"`
+	BlockHash string        `json:"blockHash"`
+	Time      int64         `json:"time,omitempty"`
+}
+
+// PayloadOutput is the output that was received, or spent by TxID
+type PayloadOutput struct {
+	TxID  string `json:"txid"`
+	Vout  int64  `json:"vout"`
+	Value int64  `json:"value"`
+}
+
+// Dispatcher turns the blocks indexed by the processor into deliveries for
+// the watched scripts, and POSTs them. Deliveries are kept in the database
+// until they succeed, so they survive restarts. Failed deliveries are
+// retried with exponential backoff and moved to the dead letters after
+// maxAttempts.
+type Dispatcher struct {
+	Client *http.Client
+
+	store       store.Store
+	maxAttempts int
+	baseDelay   time.Duration
+	lastHeight  int64
+	wake        chan struct{}
+}
+
+func NewDispatcher(s store.Store, cfg config.WebhooksConfig) *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: cfg.Timeout},
+		store:       s,
+		maxAttempts: cfg.MaxAttempts,
+		baseDelay:   cfg.RetryDelay,
+		lastHeight:  -1,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Run scans the blocks announced on sub for activity of watched scripts and
+// delivers the resulting notifications. Blocks indexed while the dispatcher
+// was not running are not scanned.
+func (d *Dispatcher) Run(sub *events.Subscription) {
+	tip, err := d.store.TipHeight()
+	if err != nil && err != store.ErrNotFound {
+		webhookLog.Warn("Unable to determine tip height", "err", err)
+	} else if err == nil {
+		d.lastHeight = tip
+	}
+
+	go d.deliverLoop()
+	for e := range sub.C {
+		switch e.Type {
+		case events.TypeBlock:
+			d.scan(e.Height)
+		case events.TypeReorg:
+			// The blocks after the fork are processed again and will be
+			// scanned when they come in
+			if e.Height < d.lastHeight {
+				d.lastHeight = e.Height
+			}
+		}
+		if n := sub.Dropped(); n > 0 {
+			webhookLog.Warn("Dispatcher missed events", "count", n)
+		}
+	}
+}
+
+// scan enqueues deliveries for the blocks up to height that weren't
+// scanned yet
+func (d *Dispatcher) scan(height int64) {
+	from := d.lastHeight + 1
+	if d.lastHeight < 0 {
+		from = height
+	}
+	for ht := from; ht <= height; ht++ {
+		err := d.enqueue(ht)
+		if err != nil {
+			webhookLog.Error("Unable to enqueue deliveries", "height", ht, "err", err)
+			return
+		}
+		d.lastHeight = ht
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) enqueue(height int64) error {
+	activity, err := d.store.WebhookActivity(height)
+	if err != nil {
+		return err
+	}
+	if len(activity) == 0 {
+		return nil
+	}
+	blk, err := d.store.BlockByHeight(height)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]store.WebhookDelivery, 0, len(activity))
+	for _, a := range activity {
+		p := Payload{
+			Event:     "received",
+			WebhookID: a.WebhookID,
+			Script:    hex.EncodeToString(a.Script),
+			TxID:      a.TxHash.String(),
+			Output: PayloadOutput{
+				TxID:  a.OutputTxHash.String(),
+				Vout:  a.Vout,
+				Value: a.Value,
+			},
+			Height:    height,
+			BlockHash: blk.Hash.String(),
+		}
+		if blk.Time != nil {
+			p.Time = blk.Time.Unix()
+		}
+		if a.Spent {
+			p.Event = "spent"
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, store.WebhookDelivery{WebhookID: a.WebhookID, Payload: b})
+	}
+	return d.store.EnqueueWebhookDeliveries(deliveries)
+}
+
+func (d *Dispatcher) deliverLoop() {
+	for {
+		err := d.DeliverDue()
+		if err != nil {
+			webhookLog.Warn("Unable to deliver webhooks", "err", err)
+		}
+		select {
+		case <-d.wake:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// DeliverDue attempts all deliveries that are due, and schedules a retry or
+// dead letters the ones that fail
+func (d *Dispatcher) DeliverDue() error {
+	for {
+		due, err := d.store.DueWebhookDeliveries(time.Now(), deliveryBatch)
+		if err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for i := range due {
+			wg.Add(1)
+			go func(dl store.WebhookDelivery) {
+				defer wg.Done()
+				d.attempt(dl)
+			}(due[i])
+		}
+		wg.Wait()
+		if len(due) < deliveryBatch {
+			return nil
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(dl store.WebhookDelivery) {
+	err := d.post(dl)
+	if err == nil {
+		err = d.store.CompleteWebhookDelivery(dl.ID)
+		if err != nil {
+			webhookLog.Error("Unable to mark delivery as completed", "delivery", dl.ID, "err", err)
+		}
+		return
+	}
+
+	attempts := dl.Attempts + 1
+	if attempts >= d.maxAttempts {
+		webhookLog.Warn("Giving up on delivery", "delivery", dl.ID, "url", dl.URL, "attempts", attempts, "err", err)
+		err2 := d.store.DeadLetterWebhookDelivery(dl.ID, err.Error())
+		if err2 != nil {
+			webhookLog.Error("Unable to dead letter delivery", "delivery", dl.ID, "err", err2)
+		}
+		return
+	}
+
+	next := time.Now().Add(Backoff(d.baseDelay, attempts))
+	webhookLog.Debug("Delivery failed, retrying", "delivery", dl.ID, "url", dl.URL, "attempts", attempts, "retryAt", next.Format(time.RFC3339), "err", err)
+	err = d.store.RetryWebhookDelivery(dl.ID, next, err.Error())
+	if err != nil {
+		webhookLog.Error("Unable to reschedule delivery", "delivery", dl.ID, "err", err)
+	}
+}
+
+func (d *Dispatcher) post(dl store.WebhookDelivery) error {
+	var p Payload
+	err := json.Unmarshal(dl.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(dl.Secret, dl.Payload))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(dl.ID, 10))
+	req.Header.Set(EventHeader, p.Event)
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed with secret, as sent in
+// the X-OCM-Signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay before the next attempt after attempts failed
+// ones: base doubled for every attempt after the first, capped at an hour
+func Backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
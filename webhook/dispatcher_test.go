@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/config"
+	"github.com/gertjaap/ocm-backend/store"
+	"github.com/gertjaap/ocm-backend/store/storetest"
+)
+
+const testSecret = "s3cret"
+
+var testScript = []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+
+// receiver is a webhook endpoint that answers with status and records the
+// requests it gets
+type receiver struct {
+	srv *httptest.Server
+
+	mtx      sync.Mutex
+	status   int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{status: status}
+	r.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mtx.Lock()
+		r.requests = append(r.requests, receivedRequest{header: req.Header, body: body})
+		status := r.status
+		r.mtx.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]receivedRequest{}, r.requests...)
+}
+
+// newTestDispatcher returns a dispatcher with one delivery pending for a
+// webhook pointing at url
+func newTestDispatcher(t *testing.T, url string, cfg config.WebhooksConfig) (*Dispatcher, store.Store, []byte) {
+	s := storetest.NewSQLite(t)
+	w, err := s.AddWebhook(testScript, url, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(Payload{Event: "received", WebhookID: w.ID, TxID: "aa", Height: 1})
+	err = s.EnqueueWebhookDeliveries([]store.WebhookDelivery{{WebhookID: w.ID, Payload: payload}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Timeout = 5 * time.Second
+	return NewDispatcher(s, cfg), s, payload
+}
+
+func pending(t *testing.T, s store.Store, at time.Time) []store.WebhookDelivery {
+	t.Helper()
+	due, err := s.DueWebhookDeliveries(at, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return due
+}
+
+func TestDeliverDueSigns(t *testing.T) {
+	r := newReceiver(t, 200)
+	d, s, payload := newTestDispatcher(t, r.srv.URL, config.WebhooksConfig{MaxAttempts: 3, RetryDelay: time.Minute})
+
+	err := d.DeliverDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := r.received()
+	if len(reqs) != 1 {
+		t.Fatalf("Received %d requests, want 1", len(reqs))
+	}
+	req := reqs[0]
+	if string(req.body) != string(payload) {
+		t.Errorf("Body is %s, want %s", req.body, payload)
+	}
+	if got, want := req.header.Get(SignatureHeader), "sha256="+Sign(testSecret, req.body); got != want {
+		t.Errorf("Signature is %q, want %q", got, want)
+	}
+	if req.header.Get(SignatureHeader) == "sha256="+Sign("other", req.body) {
+		t.Error("Signature doesn't depend on the secret")
+	}
+	if req.header.Get(EventHeader) != "received" {
+		t.Errorf("Event header is %q, want received", req.header.Get(EventHeader))
+	}
+	if _, err := strconv.ParseInt(req.header.Get(DeliveryHeader), 10, 64); err != nil {
+		t.Errorf("Delivery header %q is not an id", req.header.Get(DeliveryHeader))
+	}
+
+	// Completed deliveries are not attempted again
+	if due := pending(t, s, time.Now().Add(24*time.Hour)); len(due) != 0 {
+		t.Errorf("Completed delivery is still pending: %+v", due)
+	}
+}
+
+func TestDeliverDueRetriesWithBackoff(t *testing.T) {
+	r := newReceiver(t, 500)
+	d, s, _ := newTestDispatcher(t, r.srv.URL, config.WebhooksConfig{MaxAttempts: 3, RetryDelay: time.Minute})
+
+	start := time.Now()
+	err := d.DeliverDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.received()) != 1 {
+		t.Fatalf("Received %d requests, want 1", len(r.received()))
+	}
+	if due := pending(t, s, time.Now()); len(due) != 0 {
+		t.Fatalf("Failed delivery is due again right away: %+v", due)
+	}
+
+	next := start.Add(Backoff(time.Minute, 1))
+	due := pending(t, s, next.Add(2*time.Second))
+	if len(due) != 1 {
+		t.Fatalf("Failed delivery is not due after the backoff")
+	}
+	if due[0].Attempts != 1 || due[0].LastError == "" {
+		t.Errorf("Retried delivery has %d attempts and error %q, want 1 and the status", due[0].Attempts, due[0].LastError)
+	}
+	if due[0].NextAttempt.Before(next.Add(-2*time.Second)) || due[0].NextAttempt.After(next.Add(2*time.Second)) {
+		t.Errorf("Next attempt is at %s, want about %s", due[0].NextAttempt, next)
+	}
+
+	// Nothing is attempted before it's due
+	err = d.DeliverDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.received()) != 1 {
+		t.Errorf("Delivery was attempted again before its backoff passed")
+	}
+}
+
+func TestDeliverDueDeadLetters(t *testing.T) {
+	r := newReceiver(t, 500)
+	// A negative delay makes every retry due right away
+	d, s, payload := newTestDispatcher(t, r.srv.URL, config.WebhooksConfig{MaxAttempts: 3, RetryDelay: -time.Minute})
+
+	for i := 0; i < 3; i++ {
+		letters, err := s.WebhookDeadLetters(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) != 0 {
+			t.Fatalf("Delivery was dead lettered after %d attempts, want 3", i)
+		}
+		err = d.DeliverDue()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(r.received()) != 3 {
+		t.Errorf("Received %d requests, want 3", len(r.received()))
+	}
+	letters, err := s.WebhookDeadLetters(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("Found %d dead letters, want 1", len(letters))
+	}
+	l := letters[0]
+	if l.Attempts != 3 || l.URL != r.srv.URL || string(l.Payload) != string(payload) || l.LastError == "" {
+		t.Errorf("Dead letter is %+v, want 3 attempts to %s", l, r.srv.URL)
+	}
+	if due := pending(t, s, time.Now().Add(24*time.Hour)); len(due) != 0 {
+		t.Errorf("Dead lettered delivery is still pending: %+v", due)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, c := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	} {
+		if got := Backoff(10*time.Second, c.attempts); got != c.want {
+			t.Errorf("Backoff after %d attempts is %s, want %s", c.attempts, got, c.want)
+		}
+	}
+}
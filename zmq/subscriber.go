@@ -0,0 +1,132 @@
+package zmq
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gertjaap/ocm-backend/logging"
+	"github.com/go-zeromq/zmq4"
+)
+
+const (
+	TopicHashBlock = "hashblock"
+	TopicRawBlock  = "rawblock"
+	TopicHashTx    = "hashtx"
+	TopicRawTx     = "rawtx"
+)
+
+var zmqLog = logging.Named("zmq")
+
+// Notification is a single message published by vertcoind, which
+// consists of the topic, the payload and a per-topic sequence number.
+type Notification struct {
+	Topic    string
+	Body     []byte
+	Sequence uint32
+}
+
+type Handler func(n Notification)
+
+// Subscriber connects to a vertcoind ZMQ publisher and dispatches the
+// notifications it receives to the handlers registered per topic. When
+// the connection drops, it reconnects until Close is called.
+type Subscriber struct {
+	endpoint string
+	handlers map[string][]Handler
+	lock     sync.RWMutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func NewSubscriber(endpoint string) *Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Subscriber{
+		endpoint: endpoint,
+		handlers: map[string][]Handler{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Handle registers a handler for a topic. It must be called before Run.
+func (s *Subscriber) Handle(topic string, h Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[topic] = append(s.handlers[topic], h)
+}
+
+func (s *Subscriber) Run() {
+	for {
+		err := s.subscribe()
+		if s.ctx.Err() != nil {
+			return
+		}
+		zmqLog.Warn("Subscription failed, reconnecting in 5 seconds", "endpoint", s.endpoint, "err", err)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+func (s *Subscriber) Close() {
+	s.cancel()
+}
+
+func (s *Subscriber) subscribe() error {
+	sub := zmq4.NewSub(s.ctx)
+	defer sub.Close()
+
+	err := sub.Dial(s.endpoint)
+	if err != nil {
+		return err
+	}
+
+	s.lock.RLock()
+	for topic := range s.handlers {
+		err = sub.SetOption(zmq4.OptionSubscribe, topic)
+		if err != nil {
+			s.lock.RUnlock()
+			return err
+		}
+	}
+	s.lock.RUnlock()
+
+	zmqLog.Info("Subscribed to notifications", "endpoint", s.endpoint)
+	for {
+		msg, err := sub.Recv()
+		if err != nil {
+			return err
+		}
+		n, err := parseNotification(msg.Frames)
+		if err != nil {
+			zmqLog.Warn("Ignoring message", "err", err)
+			continue
+		}
+		s.dispatch(n)
+	}
+}
+
+func (s *Subscriber) dispatch(n Notification) {
+	s.lock.RLock()
+	handlers := s.handlers[n.Topic]
+	s.lock.RUnlock()
+	for _, h := range handlers {
+		h(n)
+	}
+}
+
+func parseNotification(frames [][]byte) (Notification, error) {
+	if len(frames) < 2 {
+		return Notification{}, fmt.Errorf("Expected at least 2 frames, got %d", len(frames))
+	}
+	n := Notification{Topic: string(frames[0]), Body: frames[1]}
+	if len(frames) > 2 && len(frames[2]) == 4 {
+		n.Sequence = binary.LittleEndian.Uint32(frames[2])
+	}
+	return n, nil
+}
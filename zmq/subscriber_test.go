@@ -0,0 +1,132 @@
+package zmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+// publisher is a local stand-in for the ZMQ publisher of vertcoind
+type publisher struct {
+	t   *testing.T
+	pub zmq4.Socket
+	seq map[string]uint32
+}
+
+func newPublisher(t *testing.T) *publisher {
+	pub := zmq4.NewPub(context.Background())
+	err := pub.Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pub.Close() })
+	return &publisher{t: t, pub: pub, seq: map[string]uint32{}}
+}
+
+func (p *publisher) endpoint() string {
+	return "tcp://" + p.pub.Addr().String()
+}
+
+// publish sends a message the way vertcoind does: topic, body and a
+// little endian sequence number that counts per topic
+func (p *publisher) publish(topic string, body []byte) uint32 {
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, p.seq[topic])
+	err := p.pub.Send(zmq4.NewMsgFrom([]byte(topic), body, seq))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.seq[topic]++
+	return p.seq[topic] - 1
+}
+
+func receive(t *testing.T, c chan Notification) Notification {
+	t.Helper()
+	select {
+	case n := <-c:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("No notification received")
+		return Notification{}
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	p := newPublisher(t)
+	blocks := make(chan Notification, 100)
+	txs := make(chan Notification, 100)
+	s := NewSubscriber(p.endpoint())
+	s.Handle(TopicHashBlock, func(n Notification) { blocks <- n })
+	s.Handle(TopicRawTx, func(n Notification) { txs <- n })
+	go s.Run()
+	defer s.Close()
+
+	// A publisher drops messages until the subscription reaches it, so
+	// publish until the first one arrives
+	var first Notification
+	for received := false; !received; {
+		p.publish(TopicHashBlock, []byte{0x00})
+		select {
+		case first = <-blocks:
+			received = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if first.Topic != TopicHashBlock || !bytes.Equal(first.Body, []byte{0x00}) {
+		t.Fatalf("First notification is %+v", first)
+	}
+	for len(blocks) > 0 {
+		<-blocks
+	}
+
+	// Topics without a handler aren't subscribed to
+	p.publish(TopicHashTx, []byte{0x01})
+	txSeq := p.publish(TopicRawTx, []byte{0x02, 0x03})
+	blockSeq := p.publish(TopicHashBlock, []byte{0x04})
+
+	n := receive(t, txs)
+	if n.Topic != TopicRawTx || !bytes.Equal(n.Body, []byte{0x02, 0x03}) || n.Sequence != txSeq {
+		t.Errorf("Raw transaction notification is %+v, want body 0203 and sequence %d", n, txSeq)
+	}
+	n = receive(t, blocks)
+	if n.Topic != TopicHashBlock || !bytes.Equal(n.Body, []byte{0x04}) || n.Sequence != blockSeq {
+		t.Errorf("Block notification is %+v, want body 04 and sequence %d", n, blockSeq)
+	}
+	if blockSeq == 0 {
+		t.Errorf("Sequence of hashblock didn't count up")
+	}
+	select {
+	case n := <-txs:
+		t.Errorf("Unexpected notification %+v", n)
+	case n := <-blocks:
+		t.Errorf("Unexpected notification %+v", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	_, err := parseNotification([][]byte{[]byte(TopicRawTx)})
+	if err == nil {
+		t.Error("A message without a body was accepted")
+	}
+
+	n, err := parseNotification([][]byte{[]byte(TopicRawTx), {0x01}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Topic != TopicRawTx || n.Sequence != 0 {
+		t.Errorf("Notification without sequence is %+v", n)
+	}
+
+	n, err = parseNotification([][]byte{[]byte(TopicHashBlock), {0x01}, {0x2a, 0x01, 0x00, 0x00}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Sequence != 298 {
+		t.Errorf("Sequence is %d, want 298", n.Sequence)
+	}
+}